@@ -0,0 +1,462 @@
+package vertex
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"reflect"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	rpprof "runtime/pprof"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+	"github.com/julienschmidt/httprouter"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RouteDescription describes a registered route for the admin introspection endpoint
+type RouteDescription struct {
+	Path        string   `json:"path"`
+	Description string   `json:"description"`
+	Methods     []string `json:"methods"`
+	Handler     string   `json:"handler"`
+	Middleware  []string `json:"middleware"`
+	Security    string   `json:"security,omitempty"`
+	Renderer    string   `json:"renderer"`
+	Deprecated  bool     `json:"deprecated,omitempty"`
+}
+
+// APIDescription describes a registered API and its routes for the admin introspection endpoint
+type APIDescription struct {
+	Name     string             `json:"name"`
+	Title    string             `json:"title"`
+	Version  string             `json:"version"`
+	Root     string             `json:"root"`
+	Renderer string             `json:"renderer"`
+	Security string             `json:"security,omitempty"`
+	Sunset   *time.Time         `json:"sunset,omitempty"`
+	Routes   []RouteDescription `json:"routes"`
+}
+
+// typeName returns a readable name of a handler, middleware, renderer etc. For func adapters, we return the
+// name of the underlying func
+func typeName(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+
+	val := reflect.ValueOf(v)
+	if val.Kind() == reflect.Func {
+		if f := runtime.FuncForPC(val.Pointer()); f != nil {
+			return fmt.Sprintf("%T(%s)", v, f.Name())
+		}
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func typeNames(vs []Middleware) []string {
+	ret := make([]string, 0, len(vs))
+	for _, v := range vs {
+		ret = append(ret, typeName(v))
+	}
+	return ret
+}
+
+// methodNames returns the names of the methods the route is registered for
+func methodNames(m MethodFlag) []string {
+	ret := []string{}
+	if m&GET == GET {
+		ret = append(ret, "GET")
+	}
+	if m&POST == POST {
+		ret = append(ret, "POST")
+	}
+	return ret
+}
+
+// Describe returns a description of the API and its routes, as they are served
+func (a *API) Describe() APIDescription {
+
+	ret := APIDescription{
+		Name:     a.Name,
+		Title:    a.Title,
+		Version:  a.Version,
+		Root:     a.root(),
+		Renderer: typeName(a.Renderer),
+		Security: typeName(a.DefaultSecurityScheme),
+		Routes:   make([]RouteDescription, 0, len(a.Routes)),
+	}
+
+	if !a.Sunset.IsZero() {
+		ret.Sunset = &a.Sunset
+	}
+
+	for _, route := range a.Routes {
+
+		security := route.Security
+		if security == nil {
+			security = a.DefaultSecurityScheme
+		}
+
+		renderer := route.Renderer
+		if renderer == nil {
+			renderer = a.Renderer
+		}
+
+		ret.Routes = append(ret.Routes, RouteDescription{
+			Path:        a.FullPath(route.Path),
+			Description: route.Description,
+			Methods:     methodNames(route.Methods),
+			Handler:     typeName(route.Handler),
+			Middleware:  typeNames(append(append([]Middleware{}, a.Middleware...), route.Middleware...)),
+			Security:    typeName(security),
+			Renderer:    typeName(renderer),
+			Deprecated:  a.isDeprecated(route),
+		})
+	}
+
+	return ret
+}
+
+// secretKeyRe matches config keys whose values should not be shown
+var secretKeyRe = regexp.MustCompile(`(?i)(password|passwd|secret|token|credential|private|^key$|api_?key)`)
+
+const redacted = "********"
+
+// redact converts a generic yaml tree to a JSON friendly one, replacing the values of secret looking keys
+func redact(v interface{}) interface{} {
+
+	switch val := v.(type) {
+	case map[interface{}]interface{}:
+		ret := make(map[string]interface{}, len(val))
+		for k, sub := range val {
+			key := fmt.Sprintf("%v", k)
+			if secretKeyRe.MatchString(key) && sub != nil && sub != "" {
+				ret[key] = redacted
+			} else {
+				ret[key] = redact(sub)
+			}
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(val))
+		for i, sub := range val {
+			ret[i] = redact(sub)
+		}
+		return ret
+	}
+	return v
+}
+
+// RedactedConfig returns the effective configuration of the server and the registered APIs, with secrets redacted
+func RedactedConfig() (interface{}, error) {
+
+	conf := map[string]interface{}{
+		"server": Config.Server,
+		"auth":   Config.Auth,
+		"apis":   currentAPIConfigs(),
+	}
+
+	b, err := yaml.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree interface{}
+	if err := yaml.Unmarshal(b, &tree); err != nil {
+		return nil, err
+	}
+
+	return redact(tree), nil
+}
+
+var startTime = time.Now()
+
+// buildInfo returns the build and runtime info of the server
+func buildInfo() map[string]interface{} {
+
+	hostname, _ := os.Hostname()
+	ret := map[string]interface{}{
+		"go_version": runtime.Version(),
+		"hostname":   hostname,
+		"pid":        os.Getpid(),
+		"goroutines": runtime.NumGoroutine(),
+		"started":    startTime,
+		"uptime":     time.Since(startTime).String(),
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		ret["path"] = info.Path
+		ret["version"] = info.Main.Version
+		ret["sum"] = info.Main.Sum
+
+		settings := map[string]string{}
+		for _, s := range info.Settings {
+			settings[s.Key] = s.Value
+		}
+		ret["settings"] = settings
+	}
+
+	return ret
+}
+
+// pprofHandler serves the pprof profiles by name
+func pprofHandler(w http.ResponseWriter, r *Request) (interface{}, error) {
+
+	switch profile := r.FormValue("profile"); profile {
+	case "cmdline":
+		pprof.Cmdline(w, r.Request)
+	case "profile":
+		pprof.Profile(w, r.Request)
+	case "symbol":
+		pprof.Symbol(w, r.Request)
+	case "trace":
+		pprof.Trace(w, r.Request)
+	default:
+		if rpprof.Lookup(profile) == nil {
+			return nil, InvalidParamError("Unknown profile %s", profile)
+		}
+		pprof.Handler(profile).ServeHTTP(w, r.Request)
+	}
+	return nil, Hijacked
+}
+
+// isLocalConnection checks whether the request came from the local machine.
+// We check the connection's address and not the request's RemoteIP, since the latter can be set by forwarding headers
+func isLocalConnection(r *Request) bool {
+
+	if isLocalSocket(r) {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(r.Request.RemoteAddr)
+	if err != nil {
+		host = r.Request.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// localTrusted checks whether a request may skip authentication because it came from the local machine. This must be
+// allowed with trust_local_connections, since behind a local proxy or sidecar every request comes from the local machine
+func localTrusted(r *Request) bool {
+	return Config.Server.TrustLocalConnections && isLocalConnection(r)
+}
+
+// checkAuthConfig checks a request's basic auth credentials against the auth config section.
+// The default credentials are never accepted, unless allow_default_credentials is set
+func checkAuthConfig(w http.ResponseWriter, r *Request, realm string) error {
+
+	if Config.Auth.Password == defaultAuthPassword && !Config.Server.AllowDefaultCredentials {
+		logging.Warning("Refusing %s request with the default auth credentials", realm)
+		return UnauthorizedError("The default auth credentials are not accepted")
+	}
+
+	user, pass, ok := r.BasicAuth()
+	if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(Config.Auth.User)) != 1 ||
+		subtle.ConstantTimeCompare([]byte(pass), []byte(Config.Auth.Password)) != 1 {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, realm))
+		return UnauthorizedError("Invalid credentials")
+	}
+	return nil
+}
+
+// parseCIDRs parses a list of CIDRs or single addresses, skipping invalid ones
+func parseCIDRs(cidrs []string) []*net.IPNet {
+	ret := make([]*net.IPNet, 0, len(cidrs))
+	for _, addr := range cidrs {
+
+		// single addresses become single address CIDRs
+		if ip := net.ParseIP(addr); ip != nil {
+			if ip.To4() != nil {
+				addr += "/32"
+			} else {
+				addr += "/128"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(addr)
+		if err != nil {
+			logging.Error("Error parsing CIDR: %s", err)
+			continue
+		}
+		ret = append(ret, ipnet)
+	}
+	return ret
+}
+
+// adminIPFilter allows only requests from the admin_allowed_ips ranges. Like isLocalConnection, it checks the
+// connection's address and not the request's RemoteIP, which can be set by forwarding headers
+func adminIPFilter(cidrs []string) Middleware {
+
+	allowed := parseCIDRs(cidrs)
+
+	return MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+
+		if isLocalSocket(r) {
+			return next(w, r)
+		}
+
+		host, _, err := net.SplitHostPort(r.Request.RemoteAddr)
+		if err != nil {
+			host = r.Request.RemoteAddr
+		}
+
+		if ip := net.ParseIP(host); ip != nil {
+			for _, ipnet := range allowed {
+				if ipnet.Contains(ip) {
+					return next(w, r)
+				}
+			}
+		}
+
+		logging.Warning("Refusing admin request from %s", host)
+		return nil, ForbiddenError("Address %s not allowed", host)
+	})
+}
+
+// adminAuth is the default admin middleware, authenticating requests with the auth config section, or allowing local
+// requests if trust_local_connections is set
+var adminAuth = MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+
+	if !localTrusted(r) {
+		if err := checkAuthConfig(w, r, "vertex admin"); err != nil {
+			return nil, err
+		}
+	}
+	return next(w, r)
+})
+
+// AdminMiddleware sets the middleware protecting the admin endpoints, e.g. an IP range filter or basic auth.
+// By default, the admin endpoints are allowed only from the admin_allowed_ips ranges (the local machine unless
+// configured), and require the credentials of the auth config section
+func (s *Server) AdminMiddleware(mw ...Middleware) *Server {
+	s.adminMiddleware = mw
+	return s
+}
+
+// adminAPI builds the admin introspection API of the server. It is served on its own listener under /admin:
+//
+//	/admin/apis                 - all the registered APIs and their routes
+//	/admin/config               - the effective config, with secrets redacted
+//	/admin/build                - build and runtime info
+//	/admin/health               - the health report, with the errors of failed checks
+//	/admin/debug/vars           - expvar metrics, e.g. calls to deprecated routes
+//	/admin/debug/pprof          - the available pprof profiles
+//	/admin/debug/pprof/:profile - pprof profiles
+func (s *Server) adminAPI() *API {
+
+	mw := s.adminMiddleware
+	if len(mw) == 0 {
+		mw = []Middleware{adminIPFilter(Config.Server.AdminAllowedIPs), adminAuth}
+	}
+
+	return &API{
+		Name:          "admin",
+		Title:         "Server Administration",
+		Root:          "/admin",
+		Doc:           "Introspection of the running server",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Middleware:    mw,
+		Routes: Routes{
+			{
+				Path:        "/apis",
+				Description: "List the registered APIs and their routes",
+				Methods:     GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					ret := make([]APIDescription, 0, len(s.apis))
+					for _, a := range s.apis {
+						ret = append(ret, a.Describe())
+					}
+					return ret, nil
+				}),
+			},
+			{
+				Path:        "/config",
+				Description: "Show the effective config, with secrets redacted",
+				Methods:     GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					return RedactedConfig()
+				}),
+			},
+			{
+				Path:        "/build",
+				Description: "Show build and runtime info",
+				Methods:     GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					return buildInfo(), nil
+				}),
+			},
+			{
+				Path:        "/health",
+				Description: "Show the health report, with the errors of failed checks",
+				Methods:     GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					report := s.healthMonitor().Report()
+					report.Ready = s.Ready()
+					return report, nil
+				}),
+			},
+			{
+				Path:        "/debug/vars",
+				Description: "Show the expvar metrics",
+				Methods:     GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					expvar.Handler().ServeHTTP(w, r.Request)
+					return nil, Hijacked
+				}),
+			},
+			{
+				Path:        "/debug/pprof",
+				Description: "List the available pprof profiles",
+				Methods:     GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					ret := map[string]int{}
+					for _, p := range rpprof.Profiles() {
+						ret[p.Name()] = p.Count()
+					}
+					return ret, nil
+				}),
+			},
+			{
+				Path:        "/debug/pprof/{profile}",
+				Description: "Get a pprof profile",
+				Methods:     GET | POST,
+				Handler:     HandlerFunc(pprofHandler),
+			},
+		},
+	}
+}
+
+// runAdmin starts the admin listener
+func (s *Server) runAdmin(addr string) error {
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("Could not listen for admin requests: %s", err)
+	}
+
+	router := httprouter.New()
+	s.adminAPI().configure(router)
+
+	logging.Info("Serving admin endpoints on %s", l.Addr().String())
+	s.admin = &http.Server{
+		Handler:     router,
+		ReadTimeout: time.Duration(Config.Server.ClientTimeout) * time.Second,
+	}
+
+	go func() {
+		if err := s.admin.Serve(l); err != nil && err != http.ErrServerClosed {
+			logging.Error("Admin server stopped: %s", err)
+		}
+	}()
+	return nil
+}
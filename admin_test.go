@@ -0,0 +1,125 @@
+package vertex
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+type adminTestConfig struct {
+	DSN    string `yaml:"dsn"`
+	Secret string `yaml:"client_secret"`
+}
+
+func TestAdmin(t *testing.T) {
+
+	s := NewServer(":9939")
+	s.AddAPI(mockAPI)
+
+	registerAPIConfig("admintest", &adminTestConfig{DSN: "db.local", Secret: "hunter2"})
+	defer delete(Config.apiconfs, "admintest")
+
+	prevServer, prevAuth := Config.Server, Config.Auth
+	defer func() {
+		Config.Server, Config.Auth = prevServer, prevAuth
+	}()
+	Config.Auth.Password = "hunter2"
+	Config.Server.AdminAllowedIPs = []string{"127.0.0.1", "10.0.0.0/8"}
+
+	router := httprouter.New()
+	s.adminAPI().configure(router)
+
+	user, pass := "", ""
+	get := func(path, remoteAddr string, v interface{}) int {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		r.RemoteAddr = remoteAddr
+		if user != "" {
+			r.SetBasicAuth(user, pass)
+		}
+		router.ServeHTTP(w, r)
+		if v != nil && w.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), v))
+		}
+		return w.Code
+	}
+
+	// by default, every request needs the credentials of the auth config section
+	assert.Equal(t, http.StatusUnauthorized, get("/admin/apis", "127.0.0.1:1234", nil))
+	user, pass = Config.Auth.User, "wrong"
+	assert.Equal(t, http.StatusUnauthorized, get("/admin/apis", "10.0.0.1:1234", nil))
+	pass = "hunter2"
+	assert.Equal(t, http.StatusOK, get("/admin/apis", "10.0.0.1:1234", nil))
+
+	// addresses out of the allowed ranges are refused even with the right credentials
+	assert.Equal(t, http.StatusForbidden, get("/admin/apis", "192.168.1.1:1234", nil))
+	assert.Equal(t, http.StatusForbidden, get("/admin/debug/pprof/goroutine", "[2001:db8::1]:1234", nil))
+	assert.Equal(t, http.StatusForbidden, get("/admin/apis", "127.0.0.2:1234", nil))
+
+	// local requests are trusted only if configured, regardless of forwarding headers
+	user, pass = "", ""
+	Config.Server.TrustLocalConnections = true
+	assert.Equal(t, http.StatusUnauthorized, get("/admin/apis", "10.0.0.1:1234", nil))
+
+	var apis []APIDescription
+	assert.Equal(t, http.StatusOK, get("/admin/apis", "127.0.0.1:1234", &apis))
+	if assert.Len(t, apis, 1) {
+		assert.Equal(t, "/mock", apis[0].Root)
+		assert.Equal(t, "vertex.JSONRenderer", apis[0].Renderer)
+		assert.Equal(t, len(mockAPI.Routes), len(apis[0].Routes))
+
+		route := apis[0].Routes[0]
+		assert.Equal(t, "/mock/test", route.Path)
+		assert.Equal(t, []string{"GET"}, route.Methods)
+		assert.Equal(t, "vertex.MockHandler", route.Handler)
+		assert.Len(t, route.Middleware, 2)
+		assert.Contains(t, route.Security, "vertex.SecuritySchemeFunc")
+	}
+
+	var conf map[string]map[string]interface{}
+	assert.Equal(t, http.StatusOK, get("/admin/config", "127.0.0.1:1234", &conf))
+	assert.Equal(t, redacted, conf["auth"]["password"])
+	assert.Equal(t, Config.Auth.User, conf["auth"]["user"])
+	assert.Equal(t, Config.Server.ListenAddr, conf["server"]["listen"])
+
+	api := conf["apis"]["admintest"].(map[string]interface{})
+	assert.Equal(t, "db.local", api["dsn"])
+	assert.Equal(t, redacted, api["client_secret"])
+
+	var build map[string]interface{}
+	assert.Equal(t, http.StatusOK, get("/admin/build", "127.0.0.1:1234", &build))
+	assert.NotEmpty(t, build["go_version"])
+
+	// health checks are started on demand when the server isn't running
+	var health HealthReport
+	assert.Equal(t, http.StatusOK, get("/admin/health", "127.0.0.1:1234", &health))
+	assert.Equal(t, HealthOK, health.Status)
+	s.stopHealth()
+
+	var profiles map[string]int
+	assert.Equal(t, http.StatusOK, get("/admin/debug/pprof", "127.0.0.1:1234", &profiles))
+	assert.Contains(t, profiles, "goroutine")
+
+	assert.Equal(t, http.StatusOK, get("/admin/debug/pprof/goroutine", "127.0.0.1:1234", nil))
+	assert.NotEqual(t, http.StatusOK, get("/admin/debug/pprof/nope", "127.0.0.1:1234", nil))
+
+	// by default, only the local machine is allowed
+	Config.Server.AdminAllowedIPs = prevServer.AdminAllowedIPs
+	router = httprouter.New()
+	s.adminAPI().configure(router)
+	user, pass = Config.Auth.User, "hunter2"
+	assert.Equal(t, http.StatusForbidden, get("/admin/build", "10.0.0.1:1234", nil))
+	assert.Equal(t, http.StatusOK, get("/admin/build", "127.0.0.1:1234", nil))
+	assert.Equal(t, http.StatusOK, get("/admin/build", "[::1]:1234", nil))
+
+	// custom admin middleware replaces the default
+	router = httprouter.New()
+	s.AdminMiddleware(MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+		return next(w, r)
+	})).adminAPI().configure(router)
+	assert.Equal(t, http.StatusOK, get("/admin/build", "10.0.0.1:1234", nil))
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"net"
 	"net/http"
-	"net/url"
 	"path"
 	"reflect"
 	"regexp"
@@ -33,6 +32,34 @@ type API struct {
 	TestMiddleware        []Middleware
 	SwaggerMiddleware     []Middleware
 	AllowInsecure         bool
+
+	// Versions makes the API versioned - the server mounts it in each of the versions, e.g. /myapi/1.0 and /myapi/1.1,
+	// with the routes served in that version. Requests to the unversioned root, e.g. /myapi, are served by the version
+	// in their Accept-Version header, or the latest version. Versions must be dotted numbers, e.g. 1.2 - other versions
+	// are logged and skipped. Requests accepting a version that is not served get 406 Not Acceptable
+	Versions []string
+
+	// HealthChecks are run periodically by the server and reported by its /healthz and /readyz endpoints
+	HealthChecks []HealthCheck
+
+	// OnStart is called when the server starts, before it accepts requests. Use it to open resources like DB pools.
+	// If it returns an error, the server does not start
+	OnStart func() error
+	// OnStop is called after the server has shut down and finished handling requests
+	OnStop func() error
+
+	// RequestSigner signs the API's integration test requests, for APIs whose security scheme requires signed requests
+	RequestSigner RequestSigner
+
+	// Sunset is the date this version of the API will be retired. Setting it deprecates all the API's routes
+	Sunset time.Time
+	// Sunsets are the sunset dates of the versions of an API with Versions, instead of Sunset. Only the versions in
+	// it are deprecated
+	Sunsets map[string]time.Time
+	// EnforceSunset makes the API's routes respond with 410 Gone after the sunset date
+	EnforceSunset bool
+	// DeprecationLink is a URL describing the deprecation, e.g. a migration guide, linked from deprecated routes
+	DeprecationLink string
 }
 
 // return an httprouter compliant handler function for a route
@@ -45,6 +72,7 @@ func (a *API) handler(route Route) func(w http.ResponseWriter, r *http.Request,
 	}
 
 	validator := NewRequestValidator(route.requestInfo)
+	deps := newInjector(route.Handler)
 
 	security := route.Security
 	if security == nil {
@@ -53,25 +81,37 @@ func (a *API) handler(route Route) func(w http.ResponseWriter, r *http.Request,
 
 	// Build the middleware chain for the API middleware and the rout middleware.
 	// The route middleware comes after the API middleware
-	chain := buildChain(append(a.Middleware, route.Middleware...)...)
+	mws := append(append([]Middleware{}, a.Middleware...), route.Middleware...)
+	if a.isDeprecated(route) {
+		mws = append([]Middleware{a.deprecationMiddleware(route)}, mws...)
+	}
+	mws = append([]Middleware{routePathMiddleware(route)}, mws...)
+	chain := buildChain(mws...)
 
 	// add the handler itself as the final middleware
 	handlerMW := MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
 
 		var reqHandler RequestHandler
+		var instance reflect.Value
 		if T.Kind() == reflect.Struct {
 			// create a new request handler instance
-			reqHandler = reflect.New(T).Interface().(RequestHandler)
+			instance = reflect.New(T)
+			reqHandler = instance.Interface().(RequestHandler)
 		} else {
 			reqHandler = route.Handler
 		}
 
 		//read params
-		if err := parseInput(r.Request, reqHandler, validator); err != nil {
+		if err := parseInput(r.Request, reqHandler, validator, deps); err != nil {
 			logging.Error("Error reading input: %s", err)
 			return nil, NewError(err)
 		}
 
+		// the dependencies are set after decoding, so params can never reach the shared values
+		if instance.IsValid() {
+			deps.inject(instance)
+		}
+
 		return reqHandler.Handle(w, r)
 	})
 
@@ -86,6 +126,14 @@ func (a *API) handler(route Route) func(w http.ResponseWriter, r *http.Request,
 	return a.middlewareHandler(chain, security, route.Renderer)
 }
 
+// routePathMiddleware sets the path of the matched route on requests, before any other middleware runs
+func routePathMiddleware(route Route) Middleware {
+	return MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+		r.SetAttribute(AttrRoutePath, route.Path)
+		return next(w, r)
+	})
+}
+
 func (a *API) middlewareHandler(chain *step, security SecurityScheme, renderer Renderer) func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
 
 	// allow overriding the API's default renderer with a per-route one
@@ -99,7 +147,7 @@ func (a *API) middlewareHandler(chain *step, security SecurityScheme, renderer R
 
 		if !a.AllowInsecure && !req.Secure {
 			// local requests bypass security
-			if req.RemoteIP != "127.0.0.1" {
+			if req.RemoteIP != "127.0.0.1" && !isLocalSocket(req) {
 				http.Error(w, insecureAccessMessage, http.StatusForbidden)
 				return
 			}
@@ -163,6 +211,15 @@ func (a *API) FullPath(relpath string) string {
 	return ret
 }
 
+// parseRoutes parses the request info of the API's routes from their handlers
+func (a *API) parseRoutes() {
+	for i := range a.Routes {
+		if err := a.Routes[i].parseInfo(a.Routes[i].Path); err != nil {
+			logging.Error("Error parsing info for %s: %s", a.Routes[i].Path, err)
+		}
+	}
+}
+
 // configure registers the API's routes on a router. If the passed router is nil, we create a new one and return it.
 // The nil mode is used when an API is run in stand-alone mode.
 func (a *API) configure(router *httprouter.Router) *httprouter.Router {
@@ -171,28 +228,43 @@ func (a *API) configure(router *httprouter.Router) *httprouter.Router {
 		router = httprouter.New()
 	}
 
-	for i, route := range a.Routes {
+	a.parseRoutes()
+
+	// the methods and the routes of each path, for answering OPTIONS requests
+	paths := []string{}
+	pathMethods := map[string][]string{}
+	pathRoutes := map[string][]Route{}
+
+	for _, route := range a.Routes {
 
-		if err := route.parseInfo(route.Path); err != nil {
-			logging.Error("Error parsing info for %s: %s", route.Path, err)
-		}
-		a.Routes[i] = route
 		h := a.handler(route)
 
 		pth := a.FullPath(route.Path)
 
+		if _, found := pathRoutes[pth]; !found {
+			paths = append(paths, pth)
+		}
+		pathRoutes[pth] = append(pathRoutes[pth], route)
+
 		if route.Methods&GET == GET {
 			logging.Info("Registering GET handler %v to path %s", h, pth)
 			router.Handle("GET", pth, h)
+			pathMethods[pth] = append(pathMethods[pth], "GET")
 		}
 		if route.Methods&POST == POST {
 			logging.Info("Registering POST handler %v to path %s", h, pth)
 			router.Handle("POST", pth, h)
+			pathMethods[pth] = append(pathMethods[pth], "POST")
 
 		}
 
 	}
 
+	for _, pth := range paths {
+		logging.Info("Registering OPTIONS handler to path %s", pth)
+		router.Handle("OPTIONS", pth, a.preflightHandler(pathRoutes[pth], pathMethods[pth]))
+	}
+
 	chain := buildChain(a.SwaggerMiddleware...)
 	if chain == nil {
 		chain = buildChain(a.swaggerHandler())
@@ -203,18 +275,35 @@ func (a *API) configure(router *httprouter.Router) *httprouter.Router {
 	// Server the API documentation swagger
 	router.GET(a.FullPath("/swagger"), a.middlewareHandler(chain, nil, nil))
 
+	// Serve the OpenAPI 3 documentation, protected by the same middleware
+	chain = buildChain(a.SwaggerMiddleware...)
+	if chain == nil {
+		chain = buildChain(a.openAPIHandler())
+	} else {
+		chain.append(a.openAPIHandler())
+	}
+
+	router.GET(a.FullPath("/openapi.json"), a.middlewareHandler(chain, nil, nil))
+
 	chain = buildChain(a.TestMiddleware...)
 	if chain == nil {
-		chain = buildChain(a.testHandler())
+		// without test middleware, we protect the tests with the auth config section
+		chain = buildChain(testAuth, a.testHandler())
 	} else {
 		chain.append(a.testHandler())
 	}
 
 	router.GET(path.Join("/test", a.root(), ":category"), a.middlewareHandler(chain, nil, nil))
 
-	// Redirect /$api/$version/console => /console?url=/$api/$version/swagger
-	uiPath := fmt.Sprintf("/console?url=%s", url.QueryEscape(a.FullPath("/swagger")))
-	router.Handler("GET", a.FullPath("/console"), http.RedirectHandler(uiPath, 301))
+	// Redirect /$api/$version/console => /console?url=/$api/$version/swagger, or render the read-only docs
+	chain = buildChain(a.SwaggerMiddleware...)
+	if chain == nil {
+		chain = buildChain(a.consoleHandler())
+	} else {
+		chain.append(a.consoleHandler())
+	}
+
+	router.GET(a.FullPath("/console"), a.middlewareHandler(chain, nil, nil))
 
 	return router
 
@@ -223,6 +312,17 @@ func (a *API) configure(router *httprouter.Router) *httprouter.Router {
 // swaggerHandler handles the swagger description request for the API
 func (a *API) swaggerHandler() MiddlewareFunc {
 	return MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+		switch format := r.FormValue("format"); format {
+		case SwaggerYAML:
+			if err := a.swaggerYAML(w, r); err != nil {
+				return nil, err
+			}
+			return nil, Hijacked
+		case SwaggerJSON, "":
+		default:
+			return nil, InvalidParamError("Invalid swagger format '%s'", format)
+		}
+
 		apiDesc := a.ToSwagger(r.Host)
 		return apiDesc, nil
 	})
@@ -280,6 +380,19 @@ func (a API) ToSwagger(serverUrl string) *swagger.API {
 	ret := swagger.NewAPI(serverUrl, a.Title, a.Doc, a.Version, a.FullPath(""), schemes)
 	ret.Consumes = []string{"text/json"}
 	ret.Produces = a.Renderer.ContentTypes()
+
+	// describe the default security scheme if it knows how
+	if sec, ok := a.DefaultSecurityScheme.(SwaggerSecurityScheme); ok {
+		name, def := sec.SecurityDefinition()
+		ret.SecurityDefinitions[name] = def
+		ret.Security = []map[string][]string{{name: {}}}
+	}
+
+	names, descriptions := a.tags()
+	for _, name := range names {
+		ret.Tags = append(ret.Tags, swagger.Tag{Name: strings.Title(name), Description: descriptions[name]})
+	}
+
 	for _, route := range a.Routes {
 
 		ri := route.requestInfo
@@ -311,6 +424,8 @@ func (a API) ToSwagger(serverUrl string) *swagger.API {
 			}
 		}
 
+		method.Deprecated = a.isDeprecated(route)
+
 		// register methods
 		if route.Methods&POST == POST {
 			p["post"] = method
@@ -9,23 +9,121 @@ import (
 )
 
 type serverConfig struct {
-	// Listening address for the server, e.g. ":8080"
+	// Listening address for the server, e.g. ":8080", "unix:/run/vertex.sock", or "systemd" for sockets passed by
+	// systemd socket activation
 	ListenAddr string `yaml:"listen"`
 
+	// Additional addresses to serve the APIs on, e.g. an internal plain HTTP port next to the public HTTPS one
+	Listeners []listenerConfig `yaml:"listeners"`
+
+	// Permissions of Unix domain sockets we listen on, in octal. Defaults to 0660
+	UnixSocketMode string `yaml:"unix_socket_mode"`
+
 	// Should we allow non http access to the API? use only on dev machines
 	AllowInsecure bool `yaml:"allow_insecure"`
 
-	// The location of the console UI html files on the local machine
+	// The location of the console UI html files on the local machine. If empty, the console files embedded in the
+	// server are served
 	ConsoleFilesPath string `yaml:"console_files_path"`
 
+	// The default page of the APIs' /console [swagger | docs]. swagger redirects to the interactive console, and docs
+	// renders a read-only documentation page. Either can be chosen with the style param, e.g. /console?style=docs
+	ConsoleStyle string `yaml:"console_style"`
+
 	// Minimal logging level [DEBUG | INFO | WARN | ERROR | CRITICAL]
 	LoggingLevel string `yaml:"logging_level"`
 
 	// Disconnect idle clients after T seconds
 	ClientTimeout int `yaml:"client_timeout_sec"`
+
+	// TLS certificate and key files. If set, the server serves HTTPS
+	TLSCert string `yaml:"tls_cert"`
+	TLSKey  string `yaml:"tls_key"`
+
+	// Additional certificates for other server names, chosen by SNI
+	TLSCertificates []tlsCertConfig `yaml:"tls_certificates"`
+
+	// Minimal TLS version [1.0 | 1.1 | 1.2 | 1.3]. Defaults to 1.2
+	TLSMinVersion string `yaml:"tls_min_version"`
+
+	// Allowed cipher suite names (e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256). If empty, Go's defaults are used.
+	// This only applies to TLS 1.2 and below
+	TLSCipherSuites []string `yaml:"tls_cipher_suites"`
+
+	// Check certificate files for changes every T seconds, and reload them if they changed. 0 means reload only on SIGHUP
+	TLSReloadInterval int `yaml:"tls_reload_interval_sec"`
+
+	// If set, listen on this address for plain HTTP requests and redirect them to HTTPS, e.g. ":80"
+	HTTPRedirectAddr string `yaml:"http_redirect_listen"`
+
+	// The port clients reach the server's HTTPS on, used in the redirects of http_redirect_listen. Defaults to 443
+	HTTPSPort int `yaml:"https_port"`
+
+	// When shutting down, report not ready for T seconds before we stop accepting requests
+	ShutdownDelay int `yaml:"shutdown_delay_sec"`
+
+	// Wait up to T seconds for in-flight requests to finish when shutting down
+	ShutdownTimeout int `yaml:"shutdown_timeout_sec"`
+
+	// If set, serve the admin introspection endpoints and pprof on this address, e.g. "127.0.0.1:9945"
+	AdminAddr string `yaml:"admin_listen"`
+
+	// The addresses or CIDRs allowed to use the admin endpoints, in addition to their credentials. Requests on unix
+	// sockets are always allowed
+	AdminAllowedIPs []string `yaml:"admin_allowed_ips"`
+
+	// Run the APIs' health checks every T seconds. The health endpoints report the last results
+	HealthCheckInterval int `yaml:"health_check_interval_sec"`
+
+	// Fail health checks that take more than T seconds
+	HealthCheckTimeout int `yaml:"health_check_timeout_sec"`
+
+	// Show the errors of failed health checks in the public health endpoints. Otherwise they only report statuses,
+	// and the errors are in the admin API's /admin/health
+	HealthErrors bool `yaml:"health_errors"`
+
+	// Fail startup if the config has any problem, e.g. unknown keys, unregistered API sections or invalid values.
+	// If false, the problems are only logged
+	StrictConfig bool `yaml:"strict_config"`
+
+	// Allow the /test endpoints and the admin API to authenticate requests with the default auth credentials.
+	// Use only on dev machines
+	AllowDefaultCredentials bool `yaml:"allow_default_credentials"`
+
+	// Let requests from the local machine use the /test endpoints and the admin API without credentials. Don't set it
+	// if a proxy or a sidecar on the same machine forwards remote requests to the server
+	TrustLocalConnections bool `yaml:"trust_local_connections"`
+
+	// Check the config file for changes every T seconds when watching it with WatchConfigs. 0 means reload only on SIGHUP
+	ConfigReloadInterval int `yaml:"config_reload_interval_sec"`
+
+	// A PEM bundle of CAs to verify client certificates with, for mutual TLS
+	TLSClientCA string `yaml:"tls_client_ca"`
+
+	// Client certificate policy [none | request | require | verify_if_given | require_and_verify].
+	// If a client CA is set and no policy is given, we default to verify_if_given
+	TLSClientAuth string `yaml:"tls_client_auth"`
+}
+
+// An additional listening address. It can be a TCP address, unix:<path> for a Unix domain socket, or systemd[:name]
+// for sockets inherited from systemd socket activation
+type listenerConfig struct {
+	Addr string `yaml:"listen"`
+	// Serve plain HTTP on this address even if the server serves TLS
+	Plain bool `yaml:"plain"`
+}
+
+// A TLS certificate and its key
+type tlsCertConfig struct {
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
 }
 
-// General-purpose to just protect some urls
+// defaultAuthPassword is the password of the auth section if none is configured. It is never accepted unless
+// allow_default_credentials is set
+const defaultAuthPassword = "xetrev"
+
+// General-purpose to just protect some urls, e.g. the /test endpoints
 type authConfig struct {
 	User     string `yaml:"user"`
 	Password string `yaml:"password"`
@@ -47,14 +145,27 @@ var Config = struct {
 	Server: serverConfig{
 		ListenAddr:       ":9944",
 		AllowInsecure:    false,
-		ConsoleFilesPath: "../console",
+		ConsoleFilesPath: "",
+		ConsoleStyle:     ConsoleSwagger,
 		LoggingLevel:     "INFO",
 		ClientTimeout:    60,
+
+		TLSMinVersion:     "1.2",
+		TLSReloadInterval: 60,
+		HTTPSPort:         443,
+		ShutdownTimeout:   30,
+
+		AdminAllowedIPs: []string{"127.0.0.0/8", "::1"},
+
+		HealthCheckInterval: 10,
+		HealthCheckTimeout:  5,
+
+		ConfigReloadInterval: 10,
 	},
 
 	Auth: authConfig{
 		User:     "vertext",
-		Password: "xetrev",
+		Password: defaultAuthPassword,
 	},
 
 	APIConfigs: make(map[string]interface{}),
@@ -67,7 +178,11 @@ var Config = struct {
 //		myApi:
 //			foo: bar
 func registerAPIConfig(name string, conf interface{}) {
+	configLock.Lock()
 	Config.apiconfs[name] = conf
+	liveConfigs[name] = conf
+	configLock.Unlock()
+	snapshotAPIConfig(name, conf)
 }
 
 func ReadConfigs() error {
@@ -96,12 +211,24 @@ func ReadConfigs() error {
 				logging.Error("Error marshalling config for API %s: %s", k, err)
 
 			}
-		} else {
-			logging.Warning("API Section %s in config file not registered with server", k)
 		}
 
 	}
 
+	// environment variables override the values in the file
+	applyEnvOverrides()
+
+	if problems := CheckConfigs(); len(problems) > 0 {
+		for _, p := range problems {
+			logging.Warning("Config problem: %s", p)
+		}
+
+		if Config.Server.StrictConfig {
+			logging.Error("Found %d problems in config in strict mode", len(problems))
+			return ConfigErrors(problems)
+		}
+	}
+
 	return nil
 
 }
@@ -0,0 +1,139 @@
+package vertex
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/dvirsky/go-pylog/logging"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding config values
+const EnvPrefix = "VERTEX"
+
+// envFileSuffix marks environment variables holding the path of a file to read the value from, e.g. a mounted secret
+const envFileSuffix = "_FILE"
+
+var envNameRe = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// EnvName returns the name of the environment variable overriding a config value, given its path of yaml keys.
+// e.g. EnvName("apis", "testung", "api_key") is VERTEX_APIS_TESTUNG_API_KEY
+func EnvName(path ...string) string {
+	return envNameRe.ReplaceAllString(strings.ToUpper(strings.Join(append([]string{EnvPrefix}, path...), "_")), "_")
+}
+
+// lookupEnv returns the value of an environment variable, or the contents of the file named in its _FILE variable
+func lookupEnv(name string) (string, bool, error) {
+
+	val, found := os.LookupEnv(name)
+	file, fileFound := os.LookupEnv(name + envFileSuffix)
+
+	if found && fileFound {
+		return "", false, fmt.Errorf("%s: both %s and %s%s are set", name, name, name, envFileSuffix)
+	}
+
+	if fileFound {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", false, fmt.Errorf("%s%s: %s", name, envFileSuffix, err)
+		}
+		// files usually end with a newline that is not a part of the secret
+		return strings.TrimRight(string(b), "\r\n"), true, nil
+	}
+
+	return val, found, nil
+}
+
+// applyEnv overrides the fields of a config struct from environment variables named by their yaml paths. Strings are
+// taken as is, and other values are parsed as yaml, e.g. VERTEX_SERVER_TLS_CIPHER_SUITES="[TLS_A, TLS_B]"
+func applyEnv(v reflect.Value, path ...string) []error {
+
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var ret []error
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		key, inline := yamlFieldName(f)
+		if key == "-" {
+			continue
+		}
+
+		fieldPath := path
+		if !inline {
+			fieldPath = append(append([]string{}, path...), key)
+		}
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct || (fv.Kind() == reflect.Ptr && fv.Type().Elem().Kind() == reflect.Struct) {
+			ret = append(ret, applyEnv(fv, fieldPath...)...)
+			continue
+		}
+
+		name := EnvName(fieldPath...)
+		val, found, err := lookupEnv(name)
+		if err != nil {
+			ret = append(ret, err)
+			continue
+		}
+		if !found {
+			continue
+		}
+
+		if fv.Kind() == reflect.String {
+			fv.SetString(val)
+		} else {
+			parsed := reflect.New(fv.Type())
+			if err := yaml.Unmarshal([]byte(val), parsed.Interface()); err != nil {
+				ret = append(ret, fmt.Errorf("%s: invalid value: %s", name, err))
+				continue
+			}
+			fv.Set(parsed.Elem())
+		}
+
+		logging.Info("Config value %s set from the environment", strings.Join(fieldPath, "."))
+	}
+
+	return ret
+}
+
+// envProblems holds the errors of the last environment overrides, so CheckConfigs can report them
+var envProblems []error
+
+// applyEnvOverrides overrides the server, auth and registered API configs from the environment, e.g
+//
+//	VERTEX_SERVER_LISTEN=:8080
+//	VERTEX_AUTH_PASSWORD_FILE=/run/secrets/vertex_password
+//	VERTEX_APIS_TESTUNG_API_KEY=s3cr3t
+func applyEnvOverrides() []error {
+
+	ret := applyEnv(reflect.ValueOf(&Config.Server), "server")
+	ret = append(ret, applyEnv(reflect.ValueOf(&Config.Auth), "auth")...)
+
+	for _, name := range sortedKeys(Config.apiconfs) {
+		if conf := Config.apiconfs[name]; conf != nil {
+			ret = append(ret, applyEnv(reflect.ValueOf(conf), "apis", name)...)
+		}
+	}
+
+	envProblems = ret
+	return ret
+}
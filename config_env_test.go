@@ -0,0 +1,163 @@
+package vertex
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+type envTestConfig struct {
+	APIKey  string   `yaml:"api_key"`
+	Limit   int      `yaml:"limit"`
+	Enabled bool     `yaml:"enabled"`
+	Hosts   []string `yaml:"hosts"`
+	Nested  struct {
+		Timeout float64 `yaml:"timeout_sec"`
+	} `yaml:"nested"`
+	Ignored string `yaml:"-"`
+}
+
+func setEnv(t *testing.T, vals map[string]string) func() {
+	for k, v := range vals {
+		os.Setenv(k, v)
+	}
+	return func() {
+		for k := range vals {
+			os.Unsetenv(k)
+		}
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	assert.Equal(t, "VERTEX_SERVER_LISTEN", EnvName("server", "listen"))
+	assert.Equal(t, "VERTEX_APIS_TESTUNG_API_KEY", EnvName("apis", "testung", "api_key"))
+	assert.Equal(t, "VERTEX_APIS_MY_API_V2_KEY", EnvName("apis", "my-api.v2", "key"))
+}
+
+func TestApplyEnv(t *testing.T) {
+
+	secret, err := ioutil.TempFile("", "vertex-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(secret.Name())
+	secret.WriteString("s3cr3t\n")
+	secret.Close()
+
+	defer setEnv(t, map[string]string{
+		"VERTEX_APIS_ENVTEST_API_KEY_FILE":       secret.Name(),
+		"VERTEX_APIS_ENVTEST_LIMIT":              "42",
+		"VERTEX_APIS_ENVTEST_ENABLED":            "true",
+		"VERTEX_APIS_ENVTEST_HOSTS":              "[a.com, b.com]",
+		"VERTEX_APIS_ENVTEST_NESTED_TIMEOUT_SEC": "1.5",
+		"VERTEX_APIS_ENVTEST_IGNORED":            "nope",
+	})()
+
+	conf := &envTestConfig{Limit: 1, Hosts: []string{"default.com"}}
+	assert.Empty(t, applyEnv(reflect.ValueOf(conf), "apis", "envtest"))
+
+	assert.Equal(t, "s3cr3t", conf.APIKey)
+	assert.Equal(t, 42, conf.Limit)
+	assert.True(t, conf.Enabled)
+	assert.Equal(t, []string{"a.com", "b.com"}, conf.Hosts)
+	assert.Equal(t, 1.5, conf.Nested.Timeout)
+	assert.Equal(t, "", conf.Ignored)
+
+	// invalid values and conflicting variables are reported, and the other values are still applied
+	defer setEnv(t, map[string]string{
+		"VERTEX_APIS_ENVTEST_LIMIT":   "many",
+		"VERTEX_APIS_ENVTEST_API_KEY": "other",
+	})()
+
+	conf = &envTestConfig{Limit: 1}
+	errs := applyEnv(reflect.ValueOf(conf), "apis", "envtest")
+	assert.Len(t, errs, 2)
+	assert.Equal(t, 1, conf.Limit)
+	assert.Equal(t, "", conf.APIKey)
+	assert.True(t, conf.Enabled)
+}
+
+func TestEnvOverrides(t *testing.T) {
+
+	conf := &envTestConfig{Limit: 1}
+	registerAPIConfig("envtest", conf)
+	defer delete(Config.apiconfs, "envtest")
+
+	prevServer, prevAuth := Config.Server, Config.Auth
+	defer func() {
+		Config.Server, Config.Auth = prevServer, prevAuth
+	}()
+
+	defer setEnv(t, map[string]string{
+		"VERTEX_SERVER_LISTEN":      ":7777",
+		"VERTEX_AUTH_PASSWORD":      "hunter2",
+		"VERTEX_APIS_ENVTEST_LIMIT": "5",
+	})()
+
+	assert.Empty(t, applyEnvOverrides())
+	assert.Equal(t, ":7777", Config.Server.ListenAddr)
+	assert.Equal(t, "hunter2", Config.Auth.Password)
+	assert.Equal(t, 5, conf.Limit)
+}
+
+func TestTestAuth(t *testing.T) {
+
+	prevServer, prevAuth := Config.Server, Config.Auth
+	defer func() {
+		Config.Server, Config.Auth = prevServer, prevAuth
+	}()
+
+	allowed := func(remoteAddr, user, pass string) bool {
+		r, _ := http.NewRequest("GET", "/test/mock/warning", nil)
+		r.RemoteAddr = remoteAddr
+		if user != "" {
+			r.SetBasicAuth(user, pass)
+		}
+
+		passed := false
+		testAuth(httptest.NewRecorder(), NewRequest(r), func(w http.ResponseWriter, r *Request) (interface{}, error) {
+			passed = true
+			return nil, nil
+		})
+		return passed
+	}
+
+	// the default credentials are refused from remote clients
+	Config.Auth.Password = defaultAuthPassword
+	Config.Server.AllowDefaultCredentials = false
+	assert.False(t, allowed("10.0.0.1:1234", Config.Auth.User, defaultAuthPassword))
+	assert.False(t, allowed("127.0.0.1:1234", Config.Auth.User, defaultAuthPassword))
+
+	// local requests need no credentials only if the operator trusts them
+	assert.False(t, allowed("127.0.0.1:1234", "", ""))
+	Config.Server.TrustLocalConnections = true
+	assert.True(t, allowed("127.0.0.1:1234", "", ""))
+	assert.False(t, allowed("10.0.0.1:1234", "", ""))
+	Config.Server.TrustLocalConnections = false
+
+	Config.Server.AllowDefaultCredentials = true
+	assert.True(t, allowed("10.0.0.1:1234", Config.Auth.User, defaultAuthPassword))
+
+	Config.Auth.Password = "hunter2"
+	assert.False(t, allowed("10.0.0.1:1234", "", ""))
+	assert.False(t, allowed("10.0.0.1:1234", Config.Auth.User, "wrong"))
+	assert.True(t, allowed("10.0.0.1:1234", Config.Auth.User, "hunter2"))
+	assert.True(t, allowed("127.0.0.1:1234", Config.Auth.User, "hunter2"))
+
+	// APIs without test middleware are protected by it
+	router := httprouter.New()
+	mockAPI.configure(router)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", path.Join("/test", mockAPI.root(), "warning"), nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -0,0 +1,346 @@
+package vertex
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigChangeListener can be implemented by API config structs that want to know when their values change
+// after a config reload. It is called on the registered config struct, with the previous and the new config structs
+// as returned by APIConfig
+type ConfigChangeListener interface {
+	OnConfigChange(old, new interface{})
+}
+
+// ConfigValidator can be implemented by API config structs to validate their values, in addition to the validation
+// tags of their fields (see CheckConfigs). A reload with invalid values is rejected as a whole
+type ConfigValidator interface {
+	Validate() error
+}
+
+// liveConfigs holds the current values of the registered API configs. A reload stores a new config struct in them
+// instead of changing the existing one, so readers never see a partially updated config
+var liveConfigs = map[string]interface{}{}
+
+// configLock guards liveConfigs and Config.APIConfigs, which are replaced by reloads while requests read them
+var configLock sync.RWMutex
+
+// reloadLock makes sure only one reload runs at a time, e.g. a SIGHUP during a file change reload
+var reloadLock sync.Mutex
+
+// APIConfig returns the current config struct of an API registered with the given name.
+//
+// Before any reload, this is the struct passed to Register. After a reload it is a new struct with the reloaded
+// values, and the registered struct keeps the values read on startup. Handlers that should see reloaded values must
+// call APIConfig on every request rather than keep the struct, and must not change it
+func APIConfig(name string) interface{} {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return liveConfigs[name]
+}
+
+// apiConfigSections returns the API sections of the config file, as of the last read or reload
+func apiConfigSections() map[string]interface{} {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return Config.APIConfigs
+}
+
+// currentAPIConfigs returns the current values of all the registered API configs
+func currentAPIConfigs() map[string]interface{} {
+	configLock.RLock()
+	defer configLock.RUnlock()
+
+	ret := make(map[string]interface{}, len(Config.apiconfs))
+	for name, conf := range Config.apiconfs {
+		if live := liveConfigs[name]; live != nil {
+			conf = live
+		}
+		ret[name] = conf
+	}
+	return ret
+}
+
+// apiConfigDefaults holds the default values of the registered API configs, as they were registered, so reloads
+// start from the defaults and not from the previous values
+var apiConfigDefaults = map[string][]byte{}
+
+func snapshotAPIConfig(name string, conf interface{}) {
+	b, err := yaml.Marshal(conf)
+	if err != nil {
+		logging.Error("Could not snapshot config defaults for API %s: %s", name, err)
+		return
+	}
+	apiConfigDefaults[name] = b
+}
+
+// ConfigFile returns the path of the config file, as given in the -conf command line flag
+func ConfigFile() string {
+	if f := flag.Lookup("conf"); f != nil {
+		return f.Value.String()
+	}
+	return ""
+}
+
+// rawConfig is the config file as generic sections, for detecting changes in them
+type rawConfig struct {
+	Server     map[string]interface{} `yaml:"server"`
+	Auth       map[string]interface{} `yaml:"auth"`
+	APIConfigs map[string]interface{} `yaml:"apis"`
+}
+
+// newAPIConfig creates a fresh copy of a registered API config from its defaults and its config file section
+func newAPIConfig(name string, current interface{}, section interface{}) (interface{}, error) {
+
+	ret := reflect.New(reflect.TypeOf(current).Elem()).Interface()
+
+	if err := yaml.Unmarshal(apiConfigDefaults[name], ret); err != nil {
+		return nil, fmt.Errorf("Could not read defaults for API %s: %s", name, err)
+	}
+
+	// an empty section would reset the config to zero values instead of the defaults
+	if section != nil {
+		b, err := yaml.Marshal(section)
+		if err != nil {
+			return nil, fmt.Errorf("Could not marshal config for API %s: %s", name, err)
+		}
+
+		if err := yaml.Unmarshal(b, ret); err != nil {
+			return nil, fmt.Errorf("Could not read config for API %s: %s", name, err)
+		}
+	}
+
+	if errs := applyEnv(reflect.ValueOf(ret), "apis", name); len(errs) > 0 {
+		return nil, ConfigErrors(errs)
+	}
+
+	// unknown keys fail the reload only in strict mode, like they fail startup
+	problems := validateConfigValue("apis."+name, reflect.ValueOf(ret))
+	if unknown := unknownConfigKeys(name, section, ret); len(unknown) > 0 {
+		if Config.Server.StrictConfig {
+			problems = append(problems, unknown...)
+		} else {
+			for _, p := range unknown {
+				logging.Warning("Config problem: %s", p)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, ConfigErrors(problems)
+	}
+
+	return ret, nil
+}
+
+// sectionChanged checks whether a server config section in the file differs from the values we run with
+func sectionChanged(current interface{}, section map[string]interface{}) bool {
+
+	updated := reflect.New(reflect.TypeOf(current))
+	updated.Elem().Set(reflect.ValueOf(current))
+
+	b, _ := yaml.Marshal(section)
+	if err := yaml.Unmarshal(b, updated.Interface()); err != nil {
+		return true
+	}
+	return !reflect.DeepEqual(current, updated.Elem().Interface())
+}
+
+// configEqual compares the config values of two config structs, as they are read from the config file
+func configEqual(a, b interface{}) bool {
+	ab, _ := yaml.Marshal(a)
+	bb, _ := yaml.Marshal(b)
+	return string(ab) == string(bb)
+}
+
+// ReloadConfigs re-reads the config file and updates the registered API configs.
+//
+// All the API sections are read and validated before any of them is changed. Each changed section is then read into a
+// new config struct, which APIConfig returns from then on - the structs are swapped atomically and never changed in
+// place. Registered config structs implementing ConfigChangeListener are notified if their values changed, with the
+// previous and the new struct.
+//
+// The raw API sections in Config.APIConfigs are replaced as well, so code reading them while configs may be reloaded
+// races with the reload - use APIConfig instead.
+//
+// The server and auth sections are not reloaded, since most of their values are used only when the server starts.
+// Changes to them, and to API sections not registered with the server, are reported in the log
+func ReloadConfigs() error {
+
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	path := ConfigFile()
+	if path == "" {
+		return errors.New("No config file to reload")
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Could not read config file: %s", err)
+	}
+
+	var raw rawConfig
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return fmt.Errorf("Could not parse config file: %s", err)
+	}
+
+	// read and validate everything before changing anything
+	updated := map[string]interface{}{}
+	for name, registered := range Config.apiconfs {
+		if registered == nil {
+			continue
+		}
+
+		conf, err := newAPIConfig(name, registered, raw.APIConfigs[name])
+		if err != nil {
+			return err
+		}
+		updated[name] = conf
+	}
+
+	if sectionChanged(Config.Server, raw.Server) {
+		logging.Warning("The server config section changed. Server config changes take effect only after a restart")
+	}
+	if sectionChanged(Config.Auth, raw.Auth) {
+		logging.Warning("The auth config section changed. Auth config changes take effect only after a restart")
+	}
+
+	sections := apiConfigSections()
+	for name, section := range raw.APIConfigs {
+		if _, found := Config.apiconfs[name]; !found && !reflect.DeepEqual(section, sections[name]) {
+			logging.Warning("API Section %s in config file changed, but it is not registered with server", name)
+		}
+	}
+
+	// swap the values and collect the ones that changed
+	type change struct {
+		listener ConfigChangeListener
+		old, new interface{}
+	}
+	changes := []change{}
+
+	configLock.Lock()
+	for name, conf := range updated {
+		old := liveConfigs[name]
+		if configEqual(old, conf) {
+			continue
+		}
+
+		liveConfigs[name] = conf
+		logging.Info("Config for API %s changed", name)
+
+		if l, ok := Config.apiconfs[name].(ConfigChangeListener); ok {
+			changes = append(changes, change{l, old, conf})
+		}
+	}
+	Config.APIConfigs = raw.APIConfigs
+	configLock.Unlock()
+
+	// notify after all the configs are swapped, so listeners see the new values of other APIs too
+	for _, c := range changes {
+		c.listener.OnConfigChange(c.old, c.new)
+	}
+
+	return nil
+}
+
+// ConfigWatcher reloads the config file when it changes or when the process receives a SIGHUP
+type ConfigWatcher struct {
+	path    string
+	modTime time.Time
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// WatchConfigs starts watching the config file for changes every checkInterval, and on SIGHUP. If checkInterval is 0,
+// the file is only reloaded on SIGHUP. See ReloadConfigs for what is reloaded
+func WatchConfigs(checkInterval time.Duration) (*ConfigWatcher, error) {
+
+	path := ConfigFile()
+	if path == "" {
+		return nil, errors.New("No config file to watch")
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not stat config file: %s", err)
+	}
+
+	w := &ConfigWatcher{
+		path:    path,
+		modTime: fi.ModTime(),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go w.watch(checkInterval)
+	return w, nil
+}
+
+// changed checks whether the config file was modified since we last read it
+func (w *ConfigWatcher) changed() bool {
+	fi, err := os.Stat(w.path)
+	if err != nil {
+		logging.Warning("Could not stat config file %s: %s", w.path, err)
+		return false
+	}
+
+	if fi.ModTime().Equal(w.modTime) {
+		return false
+	}
+	w.modTime = fi.ModTime()
+	return true
+}
+
+func (w *ConfigWatcher) watch(checkInterval time.Duration) {
+
+	defer close(w.done)
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	var tick <-chan time.Time
+	if checkInterval > 0 {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-sighup:
+			logging.Info("Got SIGHUP, reloading config file %s", w.path)
+		case <-tick:
+			if !w.changed() {
+				continue
+			}
+			logging.Info("Config file %s changed, reloading", w.path)
+		}
+
+		if err := ReloadConfigs(); err != nil {
+			logging.Error("Error reloading configs, keeping the old ones: %s", err)
+		}
+	}
+}
+
+// Close stops watching the config file, waiting for a running reload to finish
+func (w *ConfigWatcher) Close() {
+	close(w.stop)
+	<-w.done
+}
@@ -0,0 +1,206 @@
+package vertex
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type reloadTestConfig struct {
+	Limit   int               `yaml:"limit"`
+	Keys    map[string]string `yaml:"keys"`
+	changes []*reloadTestConfig
+}
+
+func (c *reloadTestConfig) Validate() error {
+	if c.Limit < 0 {
+		return errors.New("negative limit")
+	}
+	return nil
+}
+
+func (c *reloadTestConfig) OnConfigChange(old, new interface{}) {
+	c.changes = append(c.changes, old.(*reloadTestConfig))
+}
+
+func TestReloadConfigs(t *testing.T) {
+
+	conf := &reloadTestConfig{Limit: 10, Keys: map[string]string{"default": "1"}}
+	other := &reloadTestConfig{Limit: 5}
+	registerAPIConfig("reloadtest", conf)
+	registerAPIConfig("reloadother", other)
+	defer func() {
+		delete(Config.apiconfs, "reloadtest")
+		delete(Config.apiconfs, "reloadother")
+	}()
+
+	fp, err := ioutil.TempFile("", "vertex-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp.Close()
+	defer os.Remove(fp.Name())
+
+	write := func(s string) {
+		if err := ioutil.WriteFile(fp.Name(), []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prevConf := ConfigFile()
+	flag.Set("conf", fp.Name())
+	defer flag.Set("conf", prevConf)
+
+	write(`
+apis:
+  reloadtest:
+    limit: 20
+    keys:
+      foo: bar
+`)
+	assert.NoError(t, ReadConfigs())
+	assert.Equal(t, 20, conf.Limit)
+
+	live := func(name string) *reloadTestConfig {
+		return APIConfig(name).(*reloadTestConfig)
+	}
+	assert.True(t, live("reloadtest") == conf)
+
+	// reloading the same file changes nothing
+	assert.NoError(t, ReloadConfigs())
+	assert.Len(t, conf.changes, 0)
+
+	write(`
+apis:
+  reloadtest:
+    limit: 30
+  unregistered:
+    foo: bar
+`)
+	assert.NoError(t, ReloadConfigs())
+	assert.Equal(t, 30, live("reloadtest").Limit)
+
+	// the registered struct is never changed by reloads
+	assert.Equal(t, 20, conf.Limit)
+
+	// values removed from the file go back to their defaults, and not to the previous values
+	assert.Equal(t, map[string]string{"default": "1"}, live("reloadtest").Keys)
+
+	if assert.Len(t, conf.changes, 1) {
+		assert.Equal(t, 20, conf.changes[0].Limit)
+		assert.Equal(t, "bar", conf.changes[0].Keys["foo"])
+	}
+	assert.Len(t, other.changes, 0)
+
+	// an invalid section fails the reload, and no config is changed
+	write(`
+apis:
+  reloadtest:
+    limit: 40
+  reloadother:
+    limit: -1
+`)
+	assert.Error(t, ReloadConfigs())
+	assert.Equal(t, 30, live("reloadtest").Limit)
+	assert.Equal(t, 5, live("reloadother").Limit)
+
+	write(`apis: [`)
+	assert.Error(t, ReloadConfigs())
+	assert.Equal(t, 30, live("reloadtest").Limit)
+
+	// the watcher picks up changes to the file
+	w, err := WatchConfigs(10 * time.Millisecond)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer w.Close()
+
+	time.Sleep(20 * time.Millisecond)
+	write(`
+apis:
+  reloadtest:
+    limit: 50
+`)
+	for i := 0; i < 100 && live("reloadtest").Limit != 50; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 50, live("reloadtest").Limit)
+}
+
+// TestConcurrentReload reads the config while it is reloaded. Run with -race
+func TestConcurrentReload(t *testing.T) {
+
+	conf := &reloadTestConfig{Limit: 1, Keys: map[string]string{"a": "1"}}
+	registerAPIConfig("reloadrace", conf)
+	defer delete(Config.apiconfs, "reloadrace")
+
+	fp, err := ioutil.TempFile("", "vertex-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp.Close()
+	defer os.Remove(fp.Name())
+
+	prevConf := ConfigFile()
+	flag.Set("conf", fp.Name())
+	defer flag.Set("conf", prevConf)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				// the limit and the keys always come from the same reload
+				c := APIConfig("reloadrace").(*reloadTestConfig)
+				if c.Limit > 1 && c.Keys["a"] != fmt.Sprint(c.Limit) {
+					t.Errorf("Inconsistent config: limit %d, keys %v", c.Limit, c.Keys)
+				}
+			}
+		}()
+	}
+
+	// the raw sections are replaced by reloads too
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			CheckConfigs()
+			if _, err := RedactedConfig(); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	for i := 2; i < 50; i++ {
+		s := fmt.Sprintf("apis:\n  reloadrace:\n    limit: %d\n    keys:\n      a: \"%d\"\n", i, i)
+		if err := ioutil.WriteFile(fp.Name(), []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+		assert.NoError(t, ReloadConfigs())
+	}
+	close(stop)
+	wg.Wait()
+
+	assert.Equal(t, 49, APIConfig("reloadrace").(*reloadTestConfig).Limit)
+	assert.Len(t, conf.changes, 48)
+}
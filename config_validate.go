@@ -0,0 +1,283 @@
+package vertex
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dvirsky/go-pylog/logging"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigErrors is returned by ReadConfigs in strict mode, and holds all the problems found in the config
+type ConfigErrors []error
+
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d problems found in config: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// yamlLineRe matches the line numbers yaml adds to its errors. We strip them from API sections, since we
+// re-marshal the sections and the line numbers do not match the config file
+var yamlLineRe = regexp.MustCompile(`^line \d+: `)
+
+// yamlErrors splits a yaml error to its individual problems
+func yamlErrors(prefix string, err error, stripLines bool) []error {
+
+	te, ok := err.(*yaml.TypeError)
+	if !ok {
+		return []error{fmt.Errorf("%s: %s", prefix, err)}
+	}
+
+	ret := make([]error, 0, len(te.Errors))
+	for _, msg := range te.Errors {
+		if stripLines {
+			msg = yamlLineRe.ReplaceAllString(msg, "")
+		}
+		ret = append(ret, fmt.Errorf("%s: %s", prefix, msg))
+	}
+	return ret
+}
+
+// unknownConfigKeys reads an API config section strictly, returning keys that do not match the config struct
+// and values of the wrong type
+func unknownConfigKeys(name string, section interface{}, conf interface{}) []error {
+
+	if section == nil {
+		return nil
+	}
+
+	b, err := yaml.Marshal(section)
+	if err != nil {
+		return []error{fmt.Errorf("apis.%s: %s", name, err)}
+	}
+
+	fresh := reflect.New(reflect.TypeOf(conf).Elem()).Interface()
+	if err := yaml.UnmarshalStrict(b, fresh); err != nil {
+		return yamlErrors("apis."+name, err, true)
+	}
+	return nil
+}
+
+// yamlFieldName returns the key of a struct field in the config file, and whether it is inlined. Like yaml,
+// we default to the lowercased field name
+func yamlFieldName(f reflect.StructField) (string, bool) {
+
+	parts := strings.Split(f.Tag.Get("yaml"), ",")
+	for _, opt := range parts[1:] {
+		if opt == "inline" {
+			return "", true
+		}
+	}
+
+	if parts[0] == "" {
+		return strings.ToLower(f.Name), false
+	}
+	return parts[0], false
+}
+
+// checkRange checks the min/max/minlen/maxlen tags of a config field
+func checkRange(path string, f reflect.StructField, v reflect.Value) []error {
+
+	var ret []error
+
+	bound := func(tag string) (float64, bool) {
+		s := f.Tag.Get(tag)
+		if s == "" {
+			return 0, false
+		}
+		b, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			ret = append(ret, fmt.Errorf("%s: invalid %s tag '%s'", path, tag, s))
+			return 0, false
+		}
+		return b, true
+	}
+
+	var num float64
+	isNum := true
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		num = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		num = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		num = v.Float()
+	default:
+		isNum = false
+	}
+
+	if isNum {
+		if min, ok := bound("min"); ok && num < min {
+			ret = append(ret, fmt.Errorf("%s: value %v is less than the minimum %v", path, num, min))
+		}
+		if max, ok := bound("max"); ok && num > max {
+			ret = append(ret, fmt.Errorf("%s: value %v is more than the maximum %v", path, num, max))
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		if min, ok := bound("minlen"); ok && float64(v.Len()) < min {
+			ret = append(ret, fmt.Errorf("%s: length %d is less than the minimum %v", path, v.Len(), min))
+		}
+		if max, ok := bound("maxlen"); ok && float64(v.Len()) > max {
+			ret = append(ret, fmt.Errorf("%s: length %d is more than the maximum %v", path, v.Len(), max))
+		}
+	}
+
+	return ret
+}
+
+// validateConfigValue checks the values of a config struct and its nested structs against their tags:
+//
+//	required:"true"           - the value must be set, either in the file or as a default
+//	min:"N" max:"N"           - range of numeric values
+//	minlen:"N" maxlen:"N"     - length of strings, lists and maps
+//
+// Structs implementing ConfigValidator are then validated with their Validate method
+func validateConfigValue(path string, v reflect.Value) []error {
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var ret []error
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name, inline := yamlFieldName(f)
+		if name == "-" {
+			continue
+		}
+
+		fieldPath := path
+		if !inline {
+			fieldPath = path + "." + name
+		}
+
+		fv := v.Field(i)
+		if f.Tag.Get("required") == "true" && fv.IsZero() {
+			ret = append(ret, fmt.Errorf("%s: required value is missing", fieldPath))
+			continue
+		}
+
+		ret = append(ret, checkRange(fieldPath, f, fv)...)
+		ret = append(ret, validateConfigValue(fieldPath, fv)...)
+	}
+
+	var iface interface{}
+	if v.CanAddr() {
+		iface = v.Addr().Interface()
+	} else {
+		iface = v.Interface()
+	}
+
+	if cv, ok := iface.(ConfigValidator); ok {
+		if err := cv.Validate(); err != nil {
+			ret = append(ret, fmt.Errorf("%s: %s", path, err))
+		}
+	}
+
+	return ret
+}
+
+// checkDefaultCredentials reports using the default auth password if any endpoint of the server authenticates with the
+// auth config section: the /test endpoints of APIs without TestMiddleware, and the admin API without AdminMiddleware.
+// In strict mode this fails the server, otherwise it is only logged
+func (s *Server) checkDefaultCredentials() error {
+
+	if Config.Auth.Password != defaultAuthPassword || Config.Server.AllowDefaultCredentials {
+		return nil
+	}
+
+	users := []string{}
+	for _, a := range s.apis {
+		if len(a.TestMiddleware) == 0 {
+			users = append(users, fmt.Sprintf("the /test endpoints of API %s", a.Name))
+		}
+	}
+	if Config.Server.AdminAddr != "" && len(s.adminMiddleware) == 0 {
+		users = append(users, "the admin API")
+	}
+	if len(users) == 0 {
+		return nil
+	}
+
+	err := fmt.Errorf("auth.password: the default password is used by %s. Set a password, or set "+
+		"server.allow_default_credentials on dev machines", strings.Join(users, ", "))
+	if Config.Server.StrictConfig {
+		return err
+	}
+	logging.Warning("Config problem: %s", err)
+	return nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	ret := make([]string, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// CheckConfigs checks the config file and the registered API configs read from it, and returns all the problems found:
+// unknown keys, values of the wrong type, invalid environment overrides, unregistered API sections, missing required
+// values, values out of range and errors returned by the configs' Validate methods.
+//
+// It should be called after ReadConfigs. In strict mode, ReadConfigs fails if any problem is found
+func CheckConfigs() []error {
+
+	var ret []error
+
+	if path := ConfigFile(); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return []error{fmt.Errorf("Could not read config file: %s", err)}
+		}
+
+		if err := yaml.UnmarshalStrict(b, &confType{}); err != nil {
+			ret = append(ret, yamlErrors(path, err, false)...)
+		}
+	}
+
+	ret = append(ret, envProblems...)
+
+	sections := apiConfigSections()
+	for _, name := range sortedKeys(sections) {
+		section := sections[name]
+		conf, found := Config.apiconfs[name]
+		if !found || conf == nil {
+			ret = append(ret, fmt.Errorf("apis.%s: API section is not registered with the server", name))
+			continue
+		}
+		ret = append(ret, unknownConfigKeys(name, section, conf)...)
+	}
+
+	for _, name := range sortedKeys(Config.apiconfs) {
+		if conf := Config.apiconfs[name]; conf != nil {
+			ret = append(ret, validateConfigValue("apis."+name, reflect.ValueOf(conf))...)
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,180 @@
+package vertex
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type validateTestLimits struct {
+	Rate  int     `yaml:"rate" min:"1" max:"1000"`
+	Ratio float64 `yaml:"ratio" max:"1"`
+}
+
+type validateTestConfig struct {
+	Host   string             `yaml:"host" required:"true"`
+	Tags   []string           `yaml:"tags" maxlen:"2"`
+	Limits validateTestLimits `yaml:"limits"`
+	Mode   string             `yaml:"mode"`
+}
+
+func (c *validateTestConfig) Validate() error {
+	if c.Mode != "" && c.Mode != "fast" && c.Mode != "safe" {
+		return errors.New("mode must be fast or safe")
+	}
+	return nil
+}
+
+func errorStrings(errs []error) []string {
+	ret := make([]string, 0, len(errs))
+	for _, err := range errs {
+		ret = append(ret, err.Error())
+	}
+	return ret
+}
+
+func TestValidateConfigValue(t *testing.T) {
+
+	conf := &validateTestConfig{Host: "localhost", Limits: validateTestLimits{Rate: 10}}
+	assert.Empty(t, validateConfigValue("apis.test", reflect.ValueOf(conf)))
+
+	conf = &validateTestConfig{
+		Tags:   []string{"a", "b", "c"},
+		Limits: validateTestLimits{Rate: 0, Ratio: 1.5},
+		Mode:   "slow",
+	}
+
+	problems := validateConfigValue("apis.test", reflect.ValueOf(conf))
+	msgs := errorStrings(problems)
+	assert.Equal(t, []string{
+		"apis.test.host: required value is missing",
+		"apis.test.tags: length 3 is more than the maximum 2",
+		"apis.test.limits.rate: value 0 is less than the minimum 1",
+		"apis.test.limits.ratio: value 1.5 is more than the maximum 1",
+		"apis.test: mode must be fast or safe",
+	}, msgs)
+}
+
+func TestCheckConfigs(t *testing.T) {
+
+	conf := &validateTestConfig{Limits: validateTestLimits{Rate: 10}}
+	registerAPIConfig("validatetest", conf)
+	defer delete(Config.apiconfs, "validatetest")
+
+	fp, err := ioutil.TempFile("", "vertex-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fp.Name())
+	fp.WriteString(`
+server:
+  strict_config: true
+  no_such_option: 1
+apis:
+  validatetest:
+    host: example.com
+    hots: example.com
+    limits:
+      rate: nope
+  notregistered:
+    foo: bar
+`)
+	fp.Close()
+
+	prevConf := ConfigFile()
+	flag.Set("conf", fp.Name())
+	defer flag.Set("conf", prevConf)
+
+	prevAPIConfigs := Config.APIConfigs
+	defer func() {
+		Config.APIConfigs = prevAPIConfigs
+		Config.Server.StrictConfig = false
+	}()
+
+	err = ReadConfigs()
+	if !assert.Error(t, err) {
+		return
+	}
+
+	problems, ok := err.(ConfigErrors)
+	if !assert.True(t, ok) {
+		return
+	}
+
+	msgs := strings.Join(errorStrings(problems), "\n")
+	assert.Contains(t, msgs, "no_such_option not found")
+	assert.Contains(t, msgs, "apis.validatetest: field hots not found")
+	assert.Contains(t, msgs, "apis.validatetest: cannot unmarshal !!str `nope` into int")
+	assert.Contains(t, msgs, "apis.notregistered: API section is not registered with the server")
+	assert.NotContains(t, msgs, "apis.validatetest.host")
+
+	// without strict mode, we only log the problems
+	fp2, err := ioutil.TempFile("", "vertex-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fp2.Name())
+	fp2.WriteString(`
+server:
+  strict_config: false
+apis:
+  validatetest:
+    host: example.com
+    hots: example.com
+`)
+	fp2.Close()
+	flag.Set("conf", fp2.Name())
+	Config.APIConfigs = map[string]interface{}{}
+
+	assert.NoError(t, ReadConfigs())
+	assert.NotEmpty(t, CheckConfigs())
+}
+
+func TestCheckDefaultCredentials(t *testing.T) {
+
+	prevServer, prevAuth := Config.Server, Config.Auth
+	defer func() {
+		Config.Server, Config.Auth = prevServer, prevAuth
+	}()
+	Config.Auth.Password = defaultAuthPassword
+	Config.Server.StrictConfig = true
+	Config.Server.AdminAddr = ""
+
+	protected := &API{Name: "protected", TestMiddleware: []Middleware{MiddlewareFunc(nil)}}
+	s := &Server{apis: []*API{protected}}
+
+	// no endpoint uses the auth config section
+	assert.NoError(t, s.checkDefaultCredentials())
+
+	Config.Server.AdminAddr = ":9948"
+	if err := s.checkDefaultCredentials(); assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "the admin API")
+	}
+
+	s.AdminMiddleware(MiddlewareFunc(nil))
+	assert.NoError(t, s.checkDefaultCredentials())
+
+	s.apis = append(s.apis, &API{Name: "open"})
+	if err := s.checkDefaultCredentials(); assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "API open")
+		assert.NotContains(t, err.Error(), "API protected")
+	}
+
+	Config.Server.AllowDefaultCredentials = true
+	assert.NoError(t, s.checkDefaultCredentials())
+
+	Config.Server.AllowDefaultCredentials = false
+	Config.Auth.Password = "hunter2"
+	assert.NoError(t, s.checkDefaultCredentials())
+
+	// without strict mode it is only logged
+	Config.Auth.Password = defaultAuthPassword
+	Config.Server.StrictConfig = false
+	assert.NoError(t, s.checkDefaultCredentials())
+}
@@ -0,0 +1,230 @@
+package vertex
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/EverythingMe/vertex/swagger"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// embeddedConsole holds the swagger-ui console files, so the console works wherever the server binary runs from
+//
+//go:embed console
+var embeddedConsole embed.FS
+
+// Console styles of the APIs' /console pages
+const (
+	// ConsoleSwagger redirects to the interactive swagger-ui console
+	ConsoleSwagger = "swagger"
+	// ConsoleDocs renders a read-only documentation page of the API
+	ConsoleDocs = "docs"
+)
+
+// consoleFiles returns the console files to serve - from ConsoleFilesPath if it is set, or the embedded ones otherwise
+func consoleFiles() http.FileSystem {
+
+	if Config.Server.ConsoleFilesPath != "" {
+		logging.Info("Serving console files from %s", Config.Server.ConsoleFilesPath)
+		return http.Dir(Config.Server.ConsoleFilesPath)
+	}
+
+	sub, err := fs.Sub(embeddedConsole, "console")
+	if err != nil {
+		// this can only happen if the embed directive is broken
+		panic(err)
+	}
+	return http.FS(sub)
+}
+
+// docsOperation is a single method of a path, as displayed in the docs page
+type docsOperation struct {
+	Id          string
+	Method      string
+	Path        string
+	Description string
+	Deprecated  bool
+	Params      []swagger.Param
+	Responses   []docsResponse
+}
+
+type docsResponse struct {
+	Code        string
+	Description string
+	Headers     map[string]swagger.Header
+	Schema      string
+}
+
+// docsPage is the data of the docs page template
+type docsPage struct {
+	API        *swagger.API
+	Operations []docsOperation
+	Console    string
+	Swagger    string
+}
+
+// newDocsPage arranges the swagger description of an API for the docs page, with its operations sorted by path and
+// global params resolved
+func newDocsPage(desc *swagger.API, consoleUrl, swaggerUrl string) docsPage {
+
+	ret := docsPage{
+		API:     desc,
+		Console: consoleUrl,
+		Swagger: swaggerUrl,
+	}
+
+	paths := make([]string, 0, len(desc.Paths))
+	for p := range desc.Paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		for _, m := range []string{"get", "post", "put", "delete"} {
+			method, found := desc.Paths[p][m]
+			if !found {
+				continue
+			}
+
+			op := docsOperation{
+				Id:          strings.Trim(strings.NewReplacer("/", "-", "{", "", "}", "").Replace(m+p), "-"),
+				Method:      strings.ToUpper(m),
+				Path:        path.Join(desc.Basepath, p),
+				Description: method.Description,
+				Deprecated:  method.Deprecated,
+				Params:      make([]swagger.Param, 0, len(method.Parameters)),
+			}
+
+			for _, param := range method.Parameters {
+				if param.Ref != "" {
+					param = desc.Parameters[strings.TrimPrefix(param.Ref, "#/parameters/")]
+				}
+				op.Params = append(op.Params, param)
+			}
+
+			codes := make([]string, 0, len(method.Responses))
+			for code := range method.Responses {
+				codes = append(codes, code)
+			}
+			sort.Strings(codes)
+
+			for _, code := range codes {
+				resp := method.Responses[code]
+				r := docsResponse{Code: code, Description: resp.Description, Headers: resp.Headers}
+				if resp.Schema != nil {
+					if b, err := json.MarshalIndent(resp.Schema, "", "  "); err == nil {
+						r.Schema = string(b)
+					}
+				}
+				op.Responses = append(op.Responses, r)
+			}
+
+			ret.Operations = append(ret.Operations, op)
+		}
+	}
+
+	return ret
+}
+
+var docsTemplate = template.Must(template.New("docs").Funcs(template.FuncMap{
+	"lower": strings.ToLower,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.API.Info.Title}} {{.API.Info.Version}}</title>
+<style>
+body { margin: 0; font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #333; }
+nav { position: fixed; top: 0; bottom: 0; width: 260px; overflow-y: auto; background: #fafafa; border-right: 1px solid #e5e5e5; padding: 16px 0; }
+nav a { display: block; padding: 4px 16px; color: #333; text-decoration: none; font-size: 13px; }
+nav a:hover { background: #eee; }
+main { margin-left: 260px; padding: 24px 40px; max-width: 900px; }
+section { border-bottom: 1px solid #eee; padding: 16px 0; }
+table { border-collapse: collapse; width: 100%; font-size: 14px; }
+th, td { text-align: left; padding: 6px 8px; border-bottom: 1px solid #f0f0f0; vertical-align: top; }
+pre { background: #263238; color: #eee; padding: 12px; overflow-x: auto; font-size: 12px; }
+code { font-size: 13px; }
+.method { display: inline-block; min-width: 44px; padding: 2px 6px; border-radius: 3px; color: #fff; font-size: 11px; font-weight: bold; text-align: center; }
+.get { background: #2f8132; } .post { background: #186fb0; } .put { background: #95507c; } .delete { background: #cc3333; }
+.required { color: #cc3333; font-size: 11px; }
+.deprecated { color: #999; font-size: 12px; font-weight: normal; text-transform: uppercase; }
+</style>
+</head>
+<body>
+<nav>
+	<a href="#top"><strong>{{.API.Info.Title}}</strong></a>
+	{{range .Operations}}<a href="#{{.Id}}"><span class="method {{lower .Method}}">{{.Method}}</span> {{.Path}}</a>
+	{{end}}
+</nav>
+<main>
+	<h1 id="top">{{.API.Info.Title}} <small>{{.API.Info.Version}}</small></h1>
+	<p>{{.API.Info.Description}}</p>
+	<p><a href="{{.Console}}">Interactive console</a> | <a href="{{.Swagger}}">Swagger description</a></p>
+	{{if .API.SecurityDefinitions}}<h2>Authentication</h2>
+	<table>
+	{{range $name, $def := .API.SecurityDefinitions}}<tr><td><code>{{$name}}</code></td><td>{{$def.Type}}{{if $def.Name}} <code>{{$def.Name}}</code> in {{$def.In}}{{end}}</td><td>{{$def.Description}}</td></tr>
+	{{end}}</table>{{end}}
+	{{range .Operations}}<section id="{{.Id}}">
+		<h3><span class="method {{lower .Method}}">{{.Method}}</span> <code>{{.Path}}</code>{{if .Deprecated}} <span class="deprecated">deprecated</span>{{end}}</h3>
+		<p>{{.Description}}</p>
+		{{if .Params}}<h4>Parameters</h4>
+		<table>
+			<tr><th>Name</th><th>In</th><th>Type</th><th>Description</th></tr>
+			{{range .Params}}<tr>
+				<td><code>{{.Name}}</code>{{if .Required}} <span class="required">required</span>{{end}}</td>
+				<td>{{.In}}</td>
+				<td>{{.Type}}{{if .Items}} of {{.Items}}{{end}}{{if .Format}} ({{.Format}}){{end}}</td>
+				<td>{{.Description}}{{if .Default}}<br>Default: <code>{{.Default}}</code>{{end}}{{if .Enum}}<br>One of: {{range .Enum}}<code>{{.}}</code> {{end}}{{end}}{{if .Pattern}}<br>Pattern: <code>{{.Pattern}}</code>{{end}}</td>
+			</tr>{{end}}
+		</table>{{end}}
+		{{if .Responses}}<h4>Responses</h4>
+		{{range .Responses}}<p><strong>{{.Code}}</strong> {{.Description}}</p>
+		{{range $name, $h := .Headers}}<p>Header <code>{{$name}}</code> {{$h.Description}}</p>{{end}}
+		{{if .Schema}}<pre>{{.Schema}}</pre>{{end}}{{end}}{{end}}
+	</section>
+	{{end}}
+</main>
+</body>
+</html>
+`))
+
+// consoleHandler serves the API's /console page - either a redirect to the swagger-ui console, or a read-only
+// documentation page. The style is chosen by the style param, defaulting to the console_style config
+func (a *API) consoleHandler() MiddlewareFunc {
+
+	swaggerUrl := a.FullPath("/swagger")
+	uiPath := fmt.Sprintf("/console?url=%s", url.QueryEscape(swaggerUrl))
+
+	return MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+
+		style := r.FormValue("style")
+		if style == "" {
+			style = Config.Server.ConsoleStyle
+		}
+
+		switch style {
+		case ConsoleDocs:
+			page := newDocsPage(a.ToSwagger(r.Host), uiPath, swaggerUrl)
+
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			if err := docsTemplate.Execute(w, page); err != nil {
+				logging.Error("Error rendering docs for API %s: %s", a.Name, err)
+			}
+		case ConsoleSwagger, "":
+			http.Redirect(w, r.Request, uiPath, http.StatusFound)
+		default:
+			return nil, InvalidParamError("Invalid console style '%s'", style)
+		}
+
+		return nil, Hijacked
+	})
+}
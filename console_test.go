@@ -0,0 +1,85 @@
+package vertex
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsoleFiles(t *testing.T) {
+
+	prev := Config.Server.ConsoleFilesPath
+	defer func() { Config.Server.ConsoleFilesPath = prev }()
+
+	get := func(fs http.FileSystem, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		http.FileServer(fs).ServeHTTP(w, r)
+		return w
+	}
+
+	// the embedded files are served by default
+	Config.Server.ConsoleFilesPath = ""
+	w := get(consoleFiles(), "/swagger-ui.js")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Body.Bytes())
+
+	// the directory overrides them
+	dir, err := ioutil.TempDir("", "vertex-console")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("custom console"), 0644)
+
+	Config.Server.ConsoleFilesPath = dir
+	w = get(consoleFiles(), "/")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "custom console", w.Body.String())
+	assert.Equal(t, http.StatusNotFound, get(consoleFiles(), "/swagger-ui.js").Code)
+}
+
+func TestConsoleDocs(t *testing.T) {
+
+	prev := Config.Server.ConsoleStyle
+	defer func() { Config.Server.ConsoleStyle = prev }()
+
+	router := httprouter.New()
+	mockAPI.configure(router)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		r.RemoteAddr = "127.0.0.1:1234"
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// by default we redirect to the swagger console
+	Config.Server.ConsoleStyle = ConsoleSwagger
+	w := get("/mock/console")
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/console?url=%2Fmock%2Fswagger", w.Header().Get("Location"))
+
+	w = get("/mock/console?style=docs")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+
+	body := w.Body.String()
+	assert.Contains(t, body, "<title>Testung API! 1.0</title>")
+	assert.Contains(t, body, `href="#get-test2"`)
+	assert.Contains(t, body, "<code>/mock/testvoid</code>")
+	assert.Contains(t, body, `href="/console?url=%2Fmock%2Fswagger"`)
+
+	// the docs can be the default
+	Config.Server.ConsoleStyle = ConsoleDocs
+	assert.Equal(t, http.StatusOK, get("/mock/console").Code)
+
+	assert.Equal(t, http.StatusBadRequest, get("/mock/console?style=fancy").Code)
+}
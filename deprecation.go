@@ -0,0 +1,58 @@
+package vertex
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// deprecatedCalls counts the calls to deprecated routes, by API root and route path. It is published with expvar, and
+// served by the admin API's /debug/vars
+var deprecatedCalls = expvar.NewMap("vertex_deprecated_calls")
+
+// isDeprecated tells whether a route is deprecated - either by itself, or because its API version has a sunset date
+func (a *API) isDeprecated(route Route) bool {
+	return route.Deprecated || !a.Sunset.IsZero()
+}
+
+// deprecationLinks formats the Link header of deprecated routes, pointing to the API's deprecation info
+func (a *API) deprecationLinks() string {
+	if a.DeprecationLink == "" {
+		return ""
+	}
+
+	links := []string{fmt.Sprintf("<%s>; rel=\"deprecation\"; type=\"text/html\"", a.DeprecationLink)}
+	if !a.Sunset.IsZero() {
+		links = append(links, fmt.Sprintf("<%s>; rel=\"sunset\"; type=\"text/html\"", a.DeprecationLink))
+	}
+	return strings.Join(links, ", ")
+}
+
+// deprecationMiddleware adds the Deprecation, Sunset and Link headers (RFC 8594) to the responses of a deprecated
+// route and counts its calls. If the API enforces its sunset, requests after the sunset date are refused as gone
+func (a *API) deprecationMiddleware(route Route) MiddlewareFunc {
+
+	key := a.FullPath(route.Path)
+	links := a.deprecationLinks()
+
+	return MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+
+		deprecatedCalls.Add(key, 1)
+
+		w.Header().Set("Deprecation", "true")
+		if !a.Sunset.IsZero() {
+			w.Header().Set("Sunset", a.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if links != "" {
+			w.Header().Add("Link", links)
+		}
+
+		if a.EnforceSunset && !a.Sunset.IsZero() && time.Now().After(a.Sunset) {
+			return nil, GoneError("%s was retired on %s", key, a.Sunset.UTC().Format(http.TimeFormat))
+		}
+
+		return next(w, r)
+	})
+}
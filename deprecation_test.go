@@ -0,0 +1,116 @@
+package vertex
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func deprecationTestAPI(sunset time.Time, enforce bool) *API {
+	return &API{
+		Name:            "deprecatung",
+		Version:         "1.0",
+		Renderer:        JSONRenderer{},
+		AllowInsecure:   true,
+		Sunset:          sunset,
+		EnforceSunset:   enforce,
+		DeprecationLink: "https://example.com/migrate",
+		Routes: Routes{
+			{
+				Path:       "/old",
+				Methods:    GET,
+				Deprecated: true,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					return "old", nil
+				}),
+			},
+			{
+				Path:    "/new",
+				Methods: GET,
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					return "new", nil
+				}),
+			},
+		},
+	}
+}
+
+func TestDeprecatedRoute(t *testing.T) {
+
+	a := deprecationTestAPI(time.Time{}, false)
+	srv := NewServer(":9947")
+	srv.AddAPI(a)
+
+	s := httptest.NewServer(srv.Handler())
+	defer s.Close()
+
+	calls := func() int64 {
+		if v := deprecatedCalls.Get(a.FullPath("/old")); v != nil {
+			var n int64
+			fmt.Sscan(v.String(), &n)
+			return n
+		}
+		return 0
+	}
+	before := calls()
+
+	res, err := http.Get(s.URL + a.FullPath("/old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "true", res.Header.Get("Deprecation"))
+	assert.Empty(t, res.Header.Get("Sunset"))
+	assert.Equal(t, `<https://example.com/migrate>; rel="deprecation"; type="text/html"`, res.Header.Get("Link"))
+	assert.Equal(t, before+1, calls())
+
+	res, err = http.Get(s.URL + a.FullPath("/new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	assert.Empty(t, res.Header.Get("Deprecation"))
+
+	sw := a.ToSwagger("localhost")
+	assert.True(t, sw.Paths["/old"]["get"].Deprecated)
+	assert.False(t, sw.Paths["/new"]["get"].Deprecated)
+}
+
+func TestSunset(t *testing.T) {
+
+	sunset := time.Now().Add(-time.Hour)
+
+	for _, enforce := range []bool{false, true} {
+		a := deprecationTestAPI(sunset, enforce)
+		srv := NewServer(":9947")
+		srv.AddAPI(a)
+		s := httptest.NewServer(srv.Handler())
+
+		res, err := http.Get(s.URL + a.FullPath("/new"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		s.Close()
+
+		// a sunset deprecates all the API's routes
+		assert.Equal(t, "true", res.Header.Get("Deprecation"))
+		assert.Equal(t, sunset.UTC().Format(http.TimeFormat), res.Header.Get("Sunset"))
+		assert.True(t, strings.Contains(res.Header.Get("Link"), `rel="sunset"`))
+
+		if enforce {
+			assert.Equal(t, http.StatusGone, res.StatusCode)
+		} else {
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+		}
+
+		assert.True(t, a.ToSwagger("localhost").Paths["/new"]["get"].Deprecated)
+	}
+}
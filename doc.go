@@ -186,6 +186,7 @@
 //  - HTTP Basic Auth
 //  - Response Caching
 //  - Force Secure (https) Access
+//  - Response Compression (gzip, brotli, zstd)
 //
 // Renderers
 //
@@ -45,6 +45,21 @@ const (
 	// Some middleware took over the request, and the renderer should not render the response
 	ErrHijacked
 
+	// No route matches the request path
+	ErrNotFound
+
+	// The route does not support the request method
+	ErrMethodNotAllowed
+
+	// The route has been retired, and will not be served again
+	ErrGone
+
+	// The client is known, but may not access the requested resource
+	ErrForbidden
+
+	// The resource is not available in the representation or version the client accepts
+	ErrNotAcceptable
+
 	insecureAccessMessage = "Insecure http Access not allowed"
 )
 
@@ -85,6 +100,16 @@ func httpError(err error) (re int, rm string) {
 			return statusFunc(http.StatusServiceUnavailable)
 		case ErrBackOff:
 			return statusFunc(http.StatusServiceUnavailable)
+		case ErrNotFound:
+			return statusFunc(http.StatusNotFound)
+		case ErrMethodNotAllowed:
+			return statusFunc(http.StatusMethodNotAllowed)
+		case ErrGone:
+			return statusFunc(http.StatusGone)
+		case ErrForbidden:
+			return statusFunc(http.StatusForbidden)
+		case ErrNotAcceptable:
+			return statusFunc(http.StatusNotAcceptable)
 		case ErrGeneralFailure:
 			fallthrough
 		default:
@@ -186,11 +211,37 @@ func InsecureAccessDenied(msg string, args ...interface{}) error {
 	return newErrorfCode(ErrInsecureAccessDenied, msg, args...)
 }
 
+// ForbiddenError returns an error signifying the client may not access the requested resource, and logging in again
+// will not help
+func ForbiddenError(msg string, args ...interface{}) error {
+	return newErrorfCode(ErrForbidden, msg, args...)
+}
+
 // ResourceUnavailable returns an error meaning we do not want to serve this request, the client should not retry
 func ResourceUnavailableError(msg string, args ...interface{}) error {
 	return newErrorfCode(ErrResourceUnavailable, msg, args...)
 }
 
+// NotFoundError returns an error signifying no resource matches the request
+func NotFoundError(msg string, args ...interface{}) error {
+	return newErrorfCode(ErrNotFound, msg, args...)
+}
+
+// MethodNotAllowedError returns an error signifying the requested resource does not support the request method
+func MethodNotAllowedError(msg string, args ...interface{}) error {
+	return newErrorfCode(ErrMethodNotAllowed, msg, args...)
+}
+
+// NotAcceptableError returns an error signifying the resource is not available in the version the client accepts
+func NotAcceptableError(msg string, args ...interface{}) error {
+	return newErrorfCode(ErrNotAcceptable, msg, args...)
+}
+
+// GoneError returns an error signifying the requested resource has been retired
+func GoneError(msg string, args ...interface{}) error {
+	return newErrorfCode(ErrGone, msg, args...)
+}
+
 // BackOff returns a back-off error with a message formatted for the given amount of backoff time
 func BackOffError(duration time.Duration) error {
 
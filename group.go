@@ -0,0 +1,74 @@
+package vertex
+
+import (
+	"path"
+	"strings"
+)
+
+// Group nests routes under a shared path prefix, middleware and security scheme, e.g.
+//
+//	Group("/users", []Middleware{auditLog}, adminOnly,
+//		Route{Path: "/{id}", ...},
+//		Route{Path: "/{id}/rename", ...},
+//	).Tag("Users", "Manage user accounts")
+//
+// The group's middleware runs before the routes' own middleware, and its security scheme applies to routes that do not
+// define their own. Groups can be nested, and since they are plain Routes, packages can export them to be mounted in
+// another package's API:
+//
+//	a.Routes = append(a.Routes, users.Routes()...)
+func Group(prefix string, middleware []Middleware, security SecurityScheme, routes ...Route) Routes {
+
+	ret := make(Routes, 0, len(routes))
+	for _, route := range routes {
+
+		p := path.Join("/", prefix, route.Path)
+		// keep trailing slashes, path.Join strips them
+		if strings.HasSuffix(route.Path, "/") && p != "/" {
+			p += "/"
+		}
+		route.Path = p
+
+		if len(middleware) > 0 {
+			route.Middleware = append(append([]Middleware{}, middleware...), route.Middleware...)
+		}
+
+		if route.Security == nil {
+			route.Security = security
+		}
+
+		ret = append(ret, route)
+	}
+
+	return ret
+}
+
+// Tag sets the swagger tag of the routes that do not have one yet, with a description shown in the API's tag list.
+// Without a tag, routes are tagged by the first segment of their path
+func (r Routes) Tag(name, description string) Routes {
+	for i := range r {
+		if r[i].Tag == "" {
+			r[i].Tag = name
+			r[i].tagDescription = description
+		}
+	}
+	return r
+}
+
+// tags returns the tags of the API's routes and their descriptions, in the order they first appear
+func (a *API) tags() (names []string, descriptions map[string]string) {
+
+	descriptions = map[string]string{}
+	for _, route := range a.Routes {
+		if route.Tag == "" {
+			continue
+		}
+		if desc, found := descriptions[route.Tag]; !found {
+			names = append(names, route.Tag)
+			descriptions[route.Tag] = route.tagDescription
+		} else if desc == "" {
+			descriptions[route.Tag] = route.tagDescription
+		}
+	}
+	return
+}
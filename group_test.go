@@ -0,0 +1,86 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var denySecurity = SecuritySchemeFunc(func(r *Request) error {
+	return UnauthorizedError("denied")
+})
+
+func groupTestRoutes() Routes {
+
+	users := Group("/users", []Middleware{makeMockMW("inner")}, NopSecurity,
+		Route{Path: "/{id}", Methods: GET, Handler: versionHandler("get"), Middleware: []Middleware{makeMockMW("route")}},
+		Route{Path: "/", Methods: POST, Handler: versionHandler("create"), Security: denySecurity},
+	).Tag("users", "Manage user accounts")
+
+	return Group("/admin", []Middleware{makeMockMW("outer")}, denySecurity,
+		append(users, Route{Path: "ping", Methods: GET, Handler: versionHandler("ping")})...,
+	).Tag("admin", "Administration")
+}
+
+func TestGroup(t *testing.T) {
+
+	routes := groupTestRoutes()
+	if !assert.Len(t, routes, 3) {
+		return
+	}
+
+	assert.Equal(t, "/admin/users/{id}", routes[0].Path)
+	assert.Equal(t, "/admin/users/", routes[1].Path)
+	assert.Equal(t, "/admin/ping", routes[2].Path)
+
+	assert.Len(t, routes[0].Middleware, 3)
+	assert.Len(t, routes[2].Middleware, 1)
+
+	// inner tags are kept
+	assert.Equal(t, "users", routes[0].Tag)
+	assert.Equal(t, "admin", routes[2].Tag)
+
+	a := &API{Name: "grouptung", Version: "1.0", Renderer: JSONRenderer{}, Routes: routes}
+	a.parseRoutes()
+	sw := a.ToSwagger("localhost")
+
+	if assert.Len(t, sw.Tags, 2) {
+		assert.Equal(t, "Users", sw.Tags[0].Name)
+		assert.Equal(t, "Manage user accounts", sw.Tags[0].Description)
+		assert.Equal(t, "Admin", sw.Tags[1].Name)
+	}
+	assert.Equal(t, []string{"Admin"}, sw.Paths["/admin/ping"]["get"].Tags)
+	assert.Equal(t, []string{"Users"}, sw.Paths["/admin/users/{id}"]["get"].Tags)
+}
+
+func TestGroupServing(t *testing.T) {
+
+	a := &API{
+		Name:          "grouptung",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes:        groupTestRoutes(),
+	}
+
+	srv := NewServer(":9947")
+	srv.AddAPI(a)
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, a.FullPath(path), nil)
+		srv.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	// the outer group's middleware runs first, then the inner group's, then the route's own
+	w := serve("GET", "/admin/users/1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"outer", "inner", "route"}, w.Header()[middlewareHeader])
+
+	// the innermost security scheme applies
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/admin/users/").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("GET", "/admin/ping").Code)
+}
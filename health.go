@@ -0,0 +1,342 @@
+package vertex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+	"github.com/julienschmidt/httprouter"
+)
+
+// HealthCheck is a cheap, in-process check of an API's health - e.g. pinging its database.
+//
+// Like Testers, checks are either critical or warnings. A failing critical check makes the server report it is not ready
+// (and unhealthy), while failing warnings are only reported.
+//
+// Check gets a context that is done when the check times out, and should return as soon as it is. A check that is
+// still running is not started again, and fails until it returns
+type HealthCheck struct {
+	Name     string
+	Category string
+	Check    func(ctx context.Context) error
+}
+
+// CriticalCheck creates a health check that fails the server's readiness when it fails
+func CriticalCheck(name string, check func(ctx context.Context) error) HealthCheck {
+	return HealthCheck{Name: name, Category: CriticalTests, Check: check}
+}
+
+// WarningCheck creates a health check that is only reported when it fails
+func WarningCheck(name string, check func(ctx context.Context) error) HealthCheck {
+	return HealthCheck{Name: name, Category: WarningTests, Check: check}
+}
+
+// Health statuses
+const (
+	HealthOK      = "ok"
+	HealthWarning = "warning"
+	HealthFailing = "failing"
+)
+
+// CheckResult is the result of a single health check
+type CheckResult struct {
+	Status   string        `json:"status"`
+	Category string        `json:"category"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// APIHealth is the health of a single API and its checks
+type APIHealth struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// HealthReport is the health of the entire server, as reported by the health endpoints
+type HealthReport struct {
+	Status    string               `json:"status"`
+	Ready     bool                 `json:"ready"`
+	CheckedAt time.Time            `json:"checked_at"`
+	APIs      map[string]APIHealth `json:"apis"`
+}
+
+// worse returns the worse of two statuses
+func worse(a, b string) string {
+	if a == HealthFailing || b == HealthFailing {
+		return HealthFailing
+	}
+	if a == HealthWarning || b == HealthWarning {
+		return HealthWarning
+	}
+	return HealthOK
+}
+
+// healthMonitor runs the APIs' health checks periodically and caches the results, so probes are cheap
+type healthMonitor struct {
+	apis      []*API
+	interval  time.Duration
+	timeout   time.Duration
+	report    HealthReport
+	mutex     sync.RWMutex
+	stop      chan struct{}
+	closeOnce sync.Once
+
+	// the checks that are still running, including ones that timed out
+	running      map[string]bool
+	runningMutex sync.Mutex
+}
+
+// defaultHealthCheckTimeout is used if no check timeout is configured
+const defaultHealthCheckTimeout = 5 * time.Second
+
+// newHealthMonitor creates a monitor for the APIs' checks. If interval is 0, the checks are run only once
+func newHealthMonitor(apis []*API, interval, timeout time.Duration) *healthMonitor {
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	return &healthMonitor{
+		apis:     apis,
+		interval: interval,
+		timeout:  timeout,
+		stop:     make(chan struct{}),
+		running:  make(map[string]bool),
+	}
+}
+
+// runCheck runs a single check, failing it if it does not finish in time. key identifies the check among the checks of
+// all the APIs
+func (m *healthMonitor) runCheck(key string, check HealthCheck) CheckResult {
+
+	ret := CheckResult{Status: HealthOK, Category: check.Category}
+
+	st := time.Now()
+	err := m.callCheck(key, check)
+	ret.Duration = time.Since(st)
+
+	if err != nil {
+		ret.Error = err.Error()
+		ret.Status = HealthWarning
+		if check.Category == CriticalTests {
+			ret.Status = HealthFailing
+		}
+	}
+	return ret
+}
+
+// callCheck calls a check with a context that is done when it times out. A check that ignores the context and keeps
+// running is not called again until it returns, so stuck checks don't pile up
+func (m *healthMonitor) callCheck(key string, check HealthCheck) error {
+
+	m.runningMutex.Lock()
+	busy := m.running[key]
+	m.running[key] = true
+	m.runningMutex.Unlock()
+
+	if busy {
+		return errors.New("still running since a previous check")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() {
+		defer func() {
+			m.runningMutex.Lock()
+			delete(m.running, key)
+			m.runningMutex.Unlock()
+		}()
+		defer func() {
+			if e := recover(); e != nil {
+				errc <- fmt.Errorf("panic: %v", e)
+			}
+		}()
+		errc <- check.Check(ctx)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out after %s", m.timeout)
+	}
+}
+
+// check runs all the checks of all the APIs and caches the report
+func (m *healthMonitor) check() {
+
+	report := HealthReport{
+		Status:    HealthOK,
+		CheckedAt: time.Now(),
+		APIs:      make(map[string]APIHealth, len(m.apis)),
+	}
+
+	for _, a := range m.apis {
+		h := APIHealth{
+			Status: HealthOK,
+			Checks: make(map[string]CheckResult, len(a.HealthChecks)),
+		}
+
+		for _, check := range a.HealthChecks {
+			res := m.runCheck(a.Name+"/"+check.Name, check)
+			if res.Status != HealthOK {
+				logging.Warning("Health check %s of API %s is %s: %s", check.Name, a.Name, res.Status, res.Error)
+			}
+			h.Checks[check.Name] = res
+			h.Status = worse(h.Status, res.Status)
+		}
+
+		report.APIs[a.Name] = h
+		report.Status = worse(report.Status, h.Status)
+	}
+
+	m.mutex.Lock()
+	m.report = report
+	m.mutex.Unlock()
+}
+
+// run checks periodically until the monitor is stopped
+func (m *healthMonitor) run() {
+
+	if m.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.check()
+		}
+	}
+}
+
+// Close stops the periodic checks. It is safe to call more than once
+func (m *healthMonitor) Close() {
+	m.closeOnce.Do(func() {
+		close(m.stop)
+	})
+}
+
+// Report returns the last cached health report
+func (m *healthMonitor) Report() HealthReport {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.report
+}
+
+// withoutErrors returns a copy of the report without the errors of the checks, which may expose internals like
+// database addresses to anyone who can reach the health endpoints
+func (r HealthReport) withoutErrors() HealthReport {
+
+	apis := make(map[string]APIHealth, len(r.APIs))
+	for name, a := range r.APIs {
+		checks := make(map[string]CheckResult, len(a.Checks))
+		for checkName, check := range a.Checks {
+			check.Error = ""
+			checks[checkName] = check
+		}
+		a.Checks = checks
+		apis[name] = a
+	}
+	r.APIs = apis
+	return r
+}
+
+func writeHealth(w http.ResponseWriter, report HealthReport, ok bool) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if !Config.Server.HealthErrors {
+		report = report.withoutErrors()
+	}
+
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logging.Error("Error writing health report: %s", err)
+	}
+}
+
+// newHealth runs the health checks of the server's APIs once, so probes have results right away, and starts running
+// them every health_check_interval_sec
+func (s *Server) newHealth() *healthMonitor {
+	m := newHealthMonitor(s.apis, time.Duration(Config.Server.HealthCheckInterval)*time.Second,
+		time.Duration(Config.Server.HealthCheckTimeout)*time.Second)
+	m.check()
+	go m.run()
+	return m
+}
+
+// startHealth starts checking the server's health, replacing the monitor of a previous run, if any
+func (s *Server) startHealth() {
+	s.healthMutex.Lock()
+	defer s.healthMutex.Unlock()
+
+	if s.health != nil {
+		s.health.Close()
+	}
+	s.health = s.newHealth()
+}
+
+// stopHealth stops the periodic health checks. The last report is still served
+func (s *Server) stopHealth() {
+	s.healthMutex.Lock()
+	defer s.healthMutex.Unlock()
+
+	if s.health != nil {
+		s.health.Close()
+	}
+}
+
+// healthMonitor returns the server's health monitor. If the server is not running, e.g. when its Handler is served by
+// another http.Server, the monitor is started on the first call
+func (s *Server) healthMonitor() *healthMonitor {
+	s.healthMutex.Lock()
+	defer s.healthMutex.Unlock()
+
+	if s.health == nil {
+		s.health = s.newHealth()
+	}
+	return s.health
+}
+
+// registerHealth registers the server's health endpoints:
+//
+//	/livez   - returns 200 as long as the server is serving requests
+//	/healthz - the health report of all the APIs. Fails with 503 if a critical check fails
+//	/readyz  - like /healthz, but also fails when the server is not ready, e.g. when it is shutting down
+//
+// The errors of failed checks are reported only if the server's health_errors config is set. The admin API's
+// /admin/health always reports them
+func (s *Server) registerHealth() {
+
+	s.router.GET("/livez", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("ok\n"))
+	})
+
+	s.router.GET("/healthz", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		report := s.healthMonitor().Report()
+		report.Ready = s.Ready()
+		writeHealth(w, report, report.Status != HealthFailing)
+	})
+
+	s.router.GET("/readyz", func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		report := s.healthMonitor().Report()
+		report.Ready = s.Ready()
+		writeHealth(w, report, report.Ready && report.Status != HealthFailing)
+	})
+}
@@ -0,0 +1,161 @@
+package vertex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+
+	dbUp := true
+	slowStopped := make(chan struct{}, 1)
+	unstuck := make(chan struct{})
+	defer close(unstuck)
+
+	api := &API{
+		Name: "healthy",
+		HealthChecks: []HealthCheck{
+			CriticalCheck("db", func(ctx context.Context) error {
+				if !dbUp {
+					return errors.New("db is down")
+				}
+				return nil
+			}),
+			WarningCheck("cache", func(ctx context.Context) error { return errors.New("cache is cold") }),
+			WarningCheck("slow", func(ctx context.Context) error {
+				select {
+				case <-time.After(time.Second):
+				case <-ctx.Done():
+					slowStopped <- struct{}{}
+				}
+				return nil
+			}),
+			WarningCheck("stuck", func(ctx context.Context) error { <-unstuck; return nil }),
+			WarningCheck("panicky", func(ctx context.Context) error { panic("oh noes") }),
+		},
+	}
+
+	s := NewServer(":9938")
+	s.apis = append(s.apis, api, &API{Name: "empty"})
+	s.health = newHealthMonitor(s.apis, 0, 50*time.Millisecond)
+
+	get := func(path string) (int, HealthReport) {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		s.Handler().ServeHTTP(w, r)
+
+		var report HealthReport
+		if path != "/livez" {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		}
+		return w.Code, report
+	}
+
+	s.health.check()
+	atomic.StoreInt32(&s.ready, 1)
+
+	code, report := get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, HealthWarning, report.Status)
+	assert.True(t, report.Ready)
+
+	assert.Equal(t, HealthOK, report.APIs["empty"].Status)
+	checks := report.APIs["healthy"].Checks
+	assert.Equal(t, HealthOK, checks["db"].Status)
+	assert.Equal(t, HealthWarning, checks["cache"].Status)
+
+	// the errors are not public by default
+	for name, check := range checks {
+		assert.Empty(t, check.Error, name)
+	}
+	assert.Contains(t, s.health.Report().APIs["healthy"].Checks["cache"].Error, "cache is cold")
+
+	Config.Server.HealthErrors = true
+	_, report = get("/healthz")
+	Config.Server.HealthErrors = false
+
+	checks = report.APIs["healthy"].Checks
+	assert.Equal(t, "cache is cold", checks["cache"].Error)
+	assert.Contains(t, checks["slow"].Error, "timed out")
+	assert.Contains(t, checks["panicky"].Error, "oh noes")
+
+	// timed out checks are told to stop
+	select {
+	case <-slowStopped:
+	case <-time.After(time.Second):
+		t.Error("The slow check's context was not done when it timed out")
+	}
+
+	code, _ = get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+
+	// results are cached until the next check
+	dbUp = false
+	code, _ = get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+
+	s.health.check()
+	code, report = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, HealthFailing, report.Status)
+	assert.Equal(t, HealthFailing, report.APIs["healthy"].Checks["db"].Status)
+
+	// checks that ignore their context are not started again while they run
+	stuck := s.health.Report().APIs["healthy"].Checks["stuck"]
+	assert.Equal(t, HealthWarning, stuck.Status)
+	assert.Contains(t, stuck.Error, "still running")
+
+	code, _ = get("/healthz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	// a server that is not ready fails readiness even if all checks pass
+	dbUp = true
+	s.health.check()
+	atomic.StoreInt32(&s.ready, 0)
+	code, report = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, report.Ready)
+
+	code, _ = get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = get("/livez")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestHealthEmbedded(t *testing.T) {
+
+	checked := make(chan struct{}, 10)
+	s := NewServer(":9938")
+	s.AddAPI(&API{
+		Name:          "embedded",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		HealthChecks: []HealthCheck{
+			CriticalCheck("db", func(ctx context.Context) error {
+				checked <- struct{}{}
+				return nil
+			}),
+		},
+	})
+	defer s.stopHealth()
+
+	// the health endpoints are served and checked without running the server, e.g. when the server's handler is
+	// served by another http.Server
+	for _, path := range []string{"/livez", "/healthz", "/healthz"} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		s.Handler().ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code, path)
+	}
+	assert.Len(t, checked, 1)
+}
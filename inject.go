@@ -0,0 +1,113 @@
+package vertex
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/dvirsky/go-pylog/logging"
+
+	"github.com/EverythingMe/vertex/schema"
+)
+
+// injector copies the dependencies of a handler prototype into new handler instances. Dependencies are the fields
+// tagged with inject:"true", e.g.
+//
+//	type UserHandler struct {
+//		Id string    `schema:"id" required:"true"`
+//		DB *sql.DB   `inject:"true"`
+//	}
+//
+//	Route{Path: "/user/{id}", Handler: UserHandler{DB: db}, ...}
+//
+// Each request gets a new handler with the route's DB, and only the params decoded from the request.
+// Injected fields are not params, so they are neither decoded nor documented. They must be exported
+type injector struct {
+	prototype reflect.Value
+	fields    []int
+	// the param names the schema decoder would decode into the injected fields
+	names []string
+}
+
+// newInjector creates an injector for a handler prototype, or returns nil if it has no injected fields.
+// Injected fields that are not exported can't be set, so they are logged and skipped, and stay empty in requests
+func newInjector(handler RequestHandler) *injector {
+
+	v := reflect.ValueOf(handler)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	ret := &injector{prototype: v}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !schema.IsInjected(field) {
+			continue
+		}
+
+		if field.PkgPath != "" {
+			logging.Error("Injected field %s.%s is not exported, and will not be injected", v.Type().Name(), field.Name)
+			continue
+		}
+		ret.fields = append(ret.fields, i)
+
+		name := strings.Split(field.Tag.Get("schema"), ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		ret.names = append(ret.names, name)
+	}
+
+	if len(ret.fields) == 0 {
+		return nil
+	}
+	return ret
+}
+
+// inject copies the prototype's dependencies into a handler instance, overwriting anything decoded into them
+func (i *injector) inject(handler reflect.Value) {
+	if i == nil {
+		return
+	}
+
+	handler = handler.Elem()
+	for _, f := range i.fields {
+		handler.Field(f).Set(i.prototype.Field(f))
+	}
+}
+
+// params returns the request params without the ones named after injected fields, so the schema decoder never decodes
+// into them, and invalid values for them can't fail requests
+func (i *injector) params(form url.Values) url.Values {
+	if i == nil {
+		return form
+	}
+
+	ret := url.Values{}
+	for k, v := range form {
+		if !i.isInjected(k) {
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+// isInjected tells whether a param would be decoded into an injected field, or into one of its fields. The schema
+// decoder matches names case insensitively
+func (i *injector) isInjected(param string) bool {
+	param = strings.ToLower(param)
+	for _, name := range i.names {
+		name = strings.ToLower(name)
+		if param == name || strings.HasPrefix(param, name+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package vertex
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type injectTestStore struct {
+	Prefix string
+}
+
+type injectTestHandler struct {
+	Name    string           `schema:"name" required:"true"`
+	Store   *injectTestStore `inject:"true"`
+	Suffix  string           `inject:"true"`
+	Timeout time.Duration    `schema:"timeout" inject:"true"`
+}
+
+type injectTestUnexported struct {
+	Name  string           `schema:"name"`
+	store *injectTestStore `inject:"true"`
+}
+
+func (h injectTestUnexported) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	if h.store == nil {
+		return nil, NewErrorf("dependency not injected")
+	}
+	return h.store.Prefix + h.Name, nil
+}
+
+func (h injectTestHandler) Handle(w http.ResponseWriter, r *Request) (interface{}, error) {
+	if h.Store == nil {
+		return nil, NewErrorf("dependency not injected")
+	}
+	return fmt.Sprintf("%s%s%s", h.Store.Prefix, h.Name, h.Suffix), nil
+}
+
+func TestInjection(t *testing.T) {
+
+	store := &injectTestStore{Prefix: "hello "}
+
+	a := &API{
+		Name:          "injectung",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/value", Methods: GET, Handler: injectTestHandler{Store: store, Suffix: "!"}},
+			{Path: "/pointer", Methods: GET, Handler: &injectTestHandler{Store: store, Suffix: "?"}},
+		},
+	}
+
+	srv := NewServer(":9947")
+	srv.AddAPI(a)
+
+	s := httptest.NewServer(srv.Handler())
+	defer s.Close()
+
+	get := func(path string) string {
+		res, err := http.Get(s.URL + a.FullPath(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		return string(b)
+	}
+
+	assert.Equal(t, `"hello world!"`, get("/value?name=world"))
+	assert.Equal(t, `"hello world?"`, get("/pointer?name=world"))
+
+	// params can't overwrite dependencies, or reach into them
+	assert.Equal(t, `"hello world!"`, get("/value?name=world&Suffix=x&Store.Prefix=pwned"))
+	assert.Equal(t, "hello ", store.Prefix)
+
+	// invalid values for dependencies are ignored like any other param that isn't the handler's
+	assert.Equal(t, `"hello world!"`, get("/value?name=world&Timeout=abc&timeout=xyz&Store.Prefix=1&Store=2"))
+
+	// dependencies are not params
+	params := a.Routes[0].requestInfo.Params
+	if assert.Len(t, params, 1) {
+		assert.Equal(t, "name", params[0].Name)
+	}
+
+	sw := a.ToSwagger("localhost")
+	assert.Len(t, sw.Paths["/value"]["get"].Parameters, 1)
+
+	// unexported dependencies can't be injected, so they are skipped without failing the server
+	unexported := &API{
+		Name:          "injectunexported",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/value", Methods: GET, Handler: injectTestUnexported{store: store}},
+		},
+	}
+	srv = NewServer(":9947")
+	assert.NotPanics(t, func() {
+		srv.AddAPI(unexported)
+	})
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", unexported.FullPath("/value")+"?name=world", nil)
+	srv.Handler().ServeHTTP(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
@@ -0,0 +1,255 @@
+package vertex
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// Address prefixes for listeners that are not TCP addresses
+const (
+	// UnixPrefix marks a Unix domain socket address, e.g. unix:/run/vertex.sock
+	UnixPrefix = "unix:"
+	// SystemdPrefix marks sockets inherited from systemd socket activation. "systemd" alone takes all the inherited
+	// sockets, and systemd:<name> takes the sockets named <name> with FileDescriptorName in the socket unit
+	SystemdPrefix = "systemd"
+)
+
+// defaultUnixSocketMode is the permissions of Unix sockets if none are configured - only the user and group of the
+// server can connect
+const defaultUnixSocketMode = 0660
+
+// localConnKey is the context key marking connections that can only come from the local machine
+type localConnKey struct{}
+
+// markLocalConn is the ConnContext of the server. It marks connections on Unix domain sockets, including ones inherited
+// from systemd, as local - they have no remote address to tell where they came from
+func markLocalConn(ctx context.Context, c net.Conn) context.Context {
+	if c.LocalAddr().Network() == "unix" {
+		return context.WithValue(ctx, localConnKey{}, true)
+	}
+	return ctx
+}
+
+// isLocalSocket tells whether a request came in on a Unix domain socket
+func isLocalSocket(r *Request) bool {
+	local, _ := r.Request.Context().Value(localConnKey{}).(bool)
+	return local
+}
+
+// listenerSpec is an address the server listens on
+type listenerSpec struct {
+	addr string
+	// serve plain HTTP on this listener even if the server serves TLS
+	plain bool
+}
+
+// AddListener makes the server serve its APIs on another address as well, e.g. an internal port next to the public one.
+// The address can be a TCP address, unix:<path> for a Unix domain socket, or systemd[:name] for sockets
+// inherited from systemd socket activation.
+//
+// If the server serves TLS, plain makes this listener serve plain HTTP, e.g. for an internal port or a local socket
+func (s *Server) AddListener(addr string, plain bool) *Server {
+	s.extraListeners = append(s.extraListeners, listenerSpec{addr: addr, plain: plain})
+	return s
+}
+
+// Addrs returns the addresses the server is listening on
+func (s *Server) Addrs() []net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ret := make([]net.Addr, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		ret = append(ret, l.Addr())
+	}
+	return ret
+}
+
+// parseFileMode parses an octal file mode, e.g. "0660"
+func parseFileMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return defaultUnixSocketMode, nil
+	}
+
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("Invalid unix socket mode '%s'", mode)
+	}
+	return os.FileMode(m), nil
+}
+
+// listenUnix listens on a Unix domain socket, removing a stale socket file left by a previous run
+func listenUnix(path string, mode string) (net.Listener, error) {
+
+	perm, err := parseFileMode(mode)
+	if err != nil {
+		return nil, err
+	}
+
+	// only remove sockets, so a typo in the path can't delete a regular file
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("Could not listen on %s: file exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("Could not remove stale socket %s: %s", path, err)
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, perm); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("Could not set permissions of socket %s: %s", path, err)
+	}
+	return l, nil
+}
+
+// systemdListenersStart is the first file descriptor passed by systemd
+const systemdListenersStart = 3
+
+var systemd struct {
+	once      sync.Once
+	listeners map[string][]net.Listener
+	err       error
+}
+
+// systemdListeners returns the listeners passed to the process by systemd socket activation, grouped by their names.
+// They are read from the environment once, and the environment is then cleared so child processes do not take them
+func systemdListeners() (map[string][]net.Listener, error) {
+
+	systemd.once.Do(func() {
+
+		defer func() {
+			os.Unsetenv("LISTEN_PID")
+			os.Unsetenv("LISTEN_FDS")
+			os.Unsetenv("LISTEN_FDNAMES")
+		}()
+
+		systemd.listeners = map[string][]net.Listener{}
+
+		pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+		if err != nil || pid != os.Getpid() {
+			// not for us
+			return
+		}
+
+		n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil {
+			systemd.err = fmt.Errorf("Invalid LISTEN_FDS: %s", err)
+			return
+		}
+
+		names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+		for i := 0; i < n; i++ {
+			fd := systemdListenersStart + i
+			syscall.CloseOnExec(fd)
+
+			name := "LISTEN_FD_" + strconv.Itoa(fd)
+			if i < len(names) && names[i] != "" {
+				name = names[i]
+			}
+
+			f := os.NewFile(uintptr(fd), name)
+			l, err := net.FileListener(f)
+			// FileListener dups the descriptor, so we close the original either way
+			f.Close()
+			if err != nil {
+				systemd.err = fmt.Errorf("Could not use inherited socket %s: %s", name, err)
+				return
+			}
+
+			systemd.listeners[name] = append(systemd.listeners[name], l)
+		}
+
+		logging.Info("Inherited %d sockets from systemd", n)
+	})
+
+	return systemd.listeners, systemd.err
+}
+
+// listen opens the listeners of an address. systemd addresses can result in several listeners
+func listen(addr string) ([]net.Listener, error) {
+
+	switch {
+	case strings.HasPrefix(addr, UnixPrefix):
+		l, err := listenUnix(strings.TrimPrefix(addr, UnixPrefix), Config.Server.UnixSocketMode)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+
+	case addr == SystemdPrefix || strings.HasPrefix(addr, SystemdPrefix+":"):
+		inherited, err := systemdListeners()
+		if err != nil {
+			return nil, err
+		}
+
+		var ret []net.Listener
+		if name := strings.TrimPrefix(strings.TrimPrefix(addr, SystemdPrefix), ":"); name != "" {
+			ret = inherited[name]
+		} else {
+			for _, ls := range inherited {
+				ret = append(ret, ls...)
+			}
+		}
+
+		if len(ret) == 0 {
+			return nil, fmt.Errorf("No sockets inherited from systemd for %s", addr)
+		}
+		return ret, nil
+
+	default:
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{l}, nil
+	}
+}
+
+// listenAll opens the listeners of all the server's addresses, wrapping them with TLS unless they are plain.
+// If one of them fails, the ones already opened are closed
+func (s *Server) listenAll(tlsConfig *tls.Config) ([]net.Listener, error) {
+
+	specs := append([]listenerSpec{{addr: s.addr}}, s.extraListeners...)
+	for _, conf := range Config.Server.Listeners {
+		specs = append(specs, listenerSpec{addr: conf.Addr, plain: conf.Plain})
+	}
+
+	var ret []net.Listener
+	for _, spec := range specs {
+		ls, err := listen(spec.addr)
+		if err != nil {
+			for _, l := range ret {
+				l.Close()
+			}
+			return nil, fmt.Errorf("Could not listen on %s: %s", spec.addr, err)
+		}
+
+		for _, l := range ls {
+			if tlsConfig != nil && !spec.plain {
+				l = tls.NewListener(l, tlsConfig)
+				logging.Info("Listening for HTTPS requests on %s", l.Addr())
+			} else {
+				logging.Info("Listening for HTTP requests on %s", l.Addr())
+			}
+			ret = append(ret, l)
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,210 @@
+package vertex
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFileMode(t *testing.T) {
+
+	m, err := parseFileMode("")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0660), m)
+
+	m, err = parseFileMode("0600")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), m)
+
+	_, err = parseFileMode("0999")
+	assert.Error(t, err)
+	_, err = parseFileMode("01777")
+	assert.Error(t, err)
+}
+
+func TestMultipleListeners(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "vertex-listeners")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "vertex.sock")
+
+	// a stale socket from a previous run is replaced
+	stale, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	prevMode := Config.Server.UnixSocketMode
+	Config.Server.UnixSocketMode = "0600"
+	defer func() { Config.Server.UnixSocketMode = prevMode }()
+
+	api := &API{
+		Name:     "listeners",
+		Version:  "1.0",
+		Renderer: JSONRenderer{},
+		Routes: Routes{
+			{
+				Path:        "/hello",
+				Description: "hello",
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					return "hello", nil
+				}),
+				Methods: GET,
+			},
+		},
+	}
+
+	s := NewServer("127.0.0.1:0").AddListener(UnixPrefix+sock, true).AddListener("127.0.0.1:0", true)
+	s.AddAPI(api)
+
+	done := make(chan error)
+	go func() {
+		done <- s.Run()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	addrs := s.Addrs()
+	if !assert.Len(t, addrs, 3) {
+		return
+	}
+
+	fi, err := os.Stat(sock)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	}
+
+	get := func(client *http.Client, host string) string {
+		res, err := client.Get("http://" + host + "/listeners/1.0/hello")
+		if err != nil {
+			return err.Error()
+		}
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		return string(b)
+	}
+
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+
+	assert.Contains(t, get(http.DefaultClient, addrs[0].String()), "hello")
+	assert.Contains(t, get(unixClient, "vertex"), "hello")
+	assert.Contains(t, get(http.DefaultClient, addrs[2].String()), "hello")
+
+	// shutting down closes all the listeners and removes the socket
+	assert.NoError(t, s.Shutdown(context.Background()))
+	assert.NoError(t, <-done)
+
+	_, err = os.Stat(sock)
+	assert.True(t, os.IsNotExist(err))
+	_, err = net.Dial("tcp", addrs[2].String())
+	assert.Error(t, err)
+}
+
+func TestListenErrors(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "vertex-listeners")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// we never remove files that are not sockets
+	file := filepath.Join(dir, "notasocket")
+	ioutil.WriteFile(file, []byte("data"), 0644)
+	_, err = listen(UnixPrefix + file)
+	assert.Error(t, err)
+	_, err = os.Stat(file)
+	assert.NoError(t, err)
+
+	// sockets passed to other processes are ignored
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	os.Setenv("LISTEN_FDS", "1")
+	_, err = listen(SystemdPrefix)
+	assert.Error(t, err)
+	assert.Equal(t, "", os.Getenv("LISTEN_FDS"))
+
+	// a failing listener closes the ones already opened
+	s := NewServer("127.0.0.1:0").AddListener(UnixPrefix+file, false)
+	_, err = s.listenAll(nil)
+	assert.Error(t, err)
+}
+
+func TestListenerFailure(t *testing.T) {
+
+	prevTimeout := Config.Server.ShutdownTimeout
+	Config.Server.ShutdownTimeout = 1
+	defer func() { Config.Server.ShutdownTimeout = prevTimeout }()
+
+	stopped := make(chan struct{})
+	api := &API{
+		Name:     "failing",
+		Version:  "1.0",
+		Renderer: JSONRenderer{},
+		OnStop: func() error {
+			close(stopped)
+			return nil
+		},
+		Routes: Routes{
+			{
+				Path:        "/hello",
+				Description: "hello",
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					return "hello", nil
+				}),
+				Methods: GET,
+			},
+		},
+	}
+
+	s := NewServer("127.0.0.1:0").AddListener("127.0.0.1:0", false)
+	s.AddAPI(api)
+
+	done := make(chan error)
+	go func() {
+		done <- s.Run()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	addrs := s.Addrs()
+	if !assert.Len(t, addrs, 2) {
+		return
+	}
+
+	// one listener failing returns its error and shuts down the whole server
+	s.mutex.Lock()
+	s.listeners[1].Close()
+	s.mutex.Unlock()
+
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after a listener failed")
+	}
+
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("The server was not shut down after a listener failed")
+	}
+	assert.False(t, s.Ready())
+
+	_, err := net.Dial("tcp", addrs[0].String())
+	assert.Error(t, err)
+}
@@ -3,40 +3,133 @@ package middleware
 import (
 	"net/http"
 
+	"github.com/dvirsky/go-pylog/logging"
+
 	"github.com/EverythingMe/vertex"
 )
 
-// APIKeyValidator is a simple request validator middleware that looks for an API key in the request form.
-// If a key exists and it's in the list of allowed keys - the request is approved
+// Request attributes set by the APIKeyValidator for downstream middleware and handlers
+const (
+	// The owner of the request's API key (string)
+	AttrAPIKeyOwner = "api_key_owner"
+	// The scopes granted to the request's API key ([]string)
+	AttrAPIKeyScopes = "api_key_scopes"
+)
+
+// DefaultAPIKeyHeader is the default header the APIKeyValidator looks for keys in
+const DefaultAPIKeyHeader = "X-API-Key"
+
+// APIKeyValidator is a simple request validator middleware that looks for an API key in the request headers or form.
+// If a key exists in the validator's key store, it is enabled, not expired and has all the required scopes - the request is approved.
+//
+// The key's owner and scopes are set as the AttrAPIKeyOwner and AttrAPIKeyScopes request attributes.
+//
+// APIKeyValidator can also be used as an API or route SecurityScheme
 type APIKeyValidator struct {
-	paramName string
-	validKeys map[string]struct{}
+	paramName  string
+	headerName string
+	store      KeyStore
+	scopes     []string
 }
 
 // NewAPIKeyValidator creates a new validator middleware. paramName is the GET/POST parameter name
 // we look for in requests. validKeys are a list of keys this filter will approve
 func NewAPIKeyValidator(paramName string, validKeys ...string) *APIKeyValidator {
-	ret := &APIKeyValidator{
-		paramName: paramName,
-		validKeys: make(map[string]struct{}, len(validKeys)),
-	}
+	ret := NewAPIKeyStoreValidator(paramName, NewMemoryKeyStore())
 
 	ret.Add(validKeys...)
 	return ret
 }
 
-// Add a new key(s) to the validator
+// NewAPIKeyStoreValidator creates a new validator middleware that looks up keys in a key store.
+// paramName is the GET/POST parameter name we look for in requests, if the key is not in the X-API-Key header
+func NewAPIKeyStoreValidator(paramName string, store KeyStore) *APIKeyValidator {
+	return &APIKeyValidator{
+		paramName:  paramName,
+		headerName: DefaultAPIKeyHeader,
+		store:      store,
+	}
+}
+
+// Add a new key(s) to the validator. This works only for validators backed by a memory key store.
+// The added keys are owned by no one, have no scopes and never expire
 func (v *APIKeyValidator) Add(keys ...string) {
 
+	store, ok := v.store.(*MemoryKeyStore)
+	if !ok {
+		logging.Error("Cannot add keys to a validator not backed by a memory key store")
+		return
+	}
+
 	for _, k := range keys {
-		v.validKeys[k] = struct{}{}
+		store.Add(APIKey{Key: k, Enabled: true})
+	}
+}
+
+// Header sets the header name we look for keys in. An empty name disables header lookup
+func (v *APIKeyValidator) Header(name string) *APIKeyValidator {
+	v.headerName = name
+	return v
+}
+
+// RequireScopes sets the scopes a key must have in order to be approved
+func (v *APIKeyValidator) RequireScopes(scopes ...string) *APIKeyValidator {
+	v.scopes = scopes
+	return v
+}
+
+// extract the key from the request. The header takes precedence over the form param
+func (v *APIKeyValidator) requestKey(r *vertex.Request) string {
+	if v.headerName != "" {
+		if key := r.Header.Get(v.headerName); key != "" {
+			return key
+		}
+	}
+
+	return r.FormValue(v.paramName)
+}
+
+// redactKey returns a prefix of a key that identifies it in errors and logs without giving it away
+func redactKey(key string) string {
+	if len(key) < 8 {
+		return "***"
+	}
+	return key[:4] + "***"
+}
+
+// Validate checks the request's API key, and sets the key attributes on the request.
+//
+// Missing, unknown, disabled or expired keys are unauthorized. Valid keys without the required scopes are forbidden
+func (v *APIKeyValidator) Validate(r *vertex.Request) error {
+
+	key, found := v.store.Get(v.requestKey(r))
+	if !found {
+		return vertex.UnauthorizedError("missing or invalid api key '%s'", redactKey(v.requestKey(r)))
+	}
+
+	if !key.Enabled {
+		return vertex.UnauthorizedError("api key '%s' is disabled", redactKey(key.Key))
+	}
+
+	if key.Expired() {
+		return vertex.UnauthorizedError("api key '%s' expired at %s", redactKey(key.Key), key.Expiry)
+	}
+
+	for _, scope := range v.scopes {
+		if !key.HasScope(scope) {
+			return vertex.ForbiddenError("api key '%s' is missing scope '%s'", redactKey(key.Key), scope)
+		}
 	}
+
+	r.SetAttribute(AttrAPIKeyOwner, key.Owner)
+	r.SetAttribute(AttrAPIKeyScopes, key.Scopes)
+	return nil
 }
 
 func (v *APIKeyValidator) Handle(w http.ResponseWriter, r *vertex.Request, next vertex.HandlerFunc) (interface{}, error) {
 
-	if _, found := v.validKeys[r.FormValue(v.paramName)]; !found {
-		return nil, vertex.UnauthorizedError("missing or invalid api key '%s'", r.FormValue(v.paramName))
+	if err := v.Validate(r); err != nil {
+		return nil, err
 	}
 
 	return next(w, r)
@@ -0,0 +1,244 @@
+package middleware
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/dvirsky/go-pylog/logging"
+
+	"github.com/EverythingMe/vertex"
+)
+
+// AttrPeerIdentity is the request attribute holding the verified *PeerIdentity of a client certificate
+const AttrPeerIdentity = "peer_identity"
+
+// DefaultClientCertHeader is the default header a trusted TLS terminating proxy forwards client certificates in
+const DefaultClientCertHeader = "X-Client-Cert"
+
+// PeerIdentity is the identity of a caller authenticated by its client certificate
+type PeerIdentity struct {
+	CommonName string
+	// The DNS, email and URI subject alternative names of the certificate
+	SANs []string
+	// True if the certificate was forwarded by a trusted proxy and not presented to us directly
+	Forwarded   bool
+	Certificate *x509.Certificate
+}
+
+func newPeerIdentity(cert *x509.Certificate, forwarded bool) *PeerIdentity {
+	ret := &PeerIdentity{
+		CommonName:  cert.Subject.CommonName,
+		SANs:        make([]string, 0, len(cert.DNSNames)+len(cert.EmailAddresses)+len(cert.URIs)),
+		Forwarded:   forwarded,
+		Certificate: cert,
+	}
+
+	ret.SANs = append(ret.SANs, cert.DNSNames...)
+	ret.SANs = append(ret.SANs, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		ret.SANs = append(ret.SANs, u.String())
+	}
+	return ret
+}
+
+// ClientCertAuth is a security scheme that authenticates callers by their verified TLS client certificates.
+//
+// The server must be configured with a client CA (see the tls_client_ca server config) so that certificates are
+// verified during the handshake. If allowlists of common names or subject alternative names are set, the
+// certificate must match at least one of them.
+//
+// Behind a TLS terminating proxy, the scheme can read the client certificate from a header the proxy forwards it in
+// (a url-escaped PEM, like nginx's $ssl_client_escaped_cert), but only from trusted proxy addresses. Forwarded
+// certificates are verified against the given CA pool.
+//
+// The verified identity is set as the AttrPeerIdentity request attribute.
+// ClientCertAuth can also be used as a middleware
+type ClientCertAuth struct {
+	commonNames map[string]bool
+	sans        map[string]bool
+
+	proxyHeader string
+	proxyRoots  *x509.CertPool
+	proxies     []*net.IPNet
+}
+
+// NewClientCertAuth creates a new client certificate security scheme that accepts any verified certificate
+func NewClientCertAuth() *ClientCertAuth {
+	return &ClientCertAuth{
+		commonNames: map[string]bool{},
+		sans:        map[string]bool{},
+	}
+}
+
+// AllowCommonNames adds subject common names to the allowlist
+func (c *ClientCertAuth) AllowCommonNames(names ...string) *ClientCertAuth {
+	for _, n := range names {
+		c.commonNames[n] = true
+	}
+	return c
+}
+
+// AllowSANs adds subject alternative names (DNS names, emails or URIs) to the allowlist
+func (c *ClientCertAuth) AllowSANs(names ...string) *ClientCertAuth {
+	for _, n := range names {
+		c.sans[n] = true
+	}
+	return c
+}
+
+// TrustProxy enables reading client certificates forwarded in a header, from proxies in the given CIDR ranges.
+// Forwarded certificates are verified against roots. If header is empty, DefaultClientCertHeader is used
+func (c *ClientCertAuth) TrustProxy(header string, roots *x509.CertPool, cidrs ...string) *ClientCertAuth {
+
+	if roots == nil {
+		logging.Error("Trusted proxy mode requires a CA pool to verify forwarded certificates, not enabling it")
+		return c
+	}
+
+	if header == "" {
+		header = DefaultClientCertHeader
+	}
+
+	for _, addr := range cidrs {
+
+		// for normal addresses - we make it a single address cidr
+		if ip := net.ParseIP(addr); ip != nil {
+			if ip.To4() != nil {
+				addr = addr + "/32"
+			} else {
+				addr = addr + "/128"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(addr)
+		if err != nil {
+			logging.Error("Error parsing CIDR: %s", err)
+			continue
+		}
+		c.proxies = append(c.proxies, ipnet)
+	}
+
+	c.proxyHeader = header
+	c.proxyRoots = roots
+	return c
+}
+
+// isTrustedProxy checks the address of the connection itself, since the proxy may have set the forwarded-for headers
+func (c *ClientCertAuth) isTrustedProxy(r *vertex.Request) bool {
+
+	host, _, err := net.SplitHostPort(r.Request.RemoteAddr)
+	if err != nil {
+		host = r.Request.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, ipnet := range c.proxies {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedCert parses and verifies a certificate forwarded by a trusted proxy
+func (c *ClientCertAuth) forwardedCert(value string) (*x509.Certificate, error) {
+
+	if unescaped, err := url.QueryUnescape(value); err == nil {
+		value = unescaped
+	}
+
+	block, _ := pem.Decode([]byte(value))
+	if block == nil {
+		return nil, errors.New("forwarded client certificate is not a valid PEM")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse forwarded client certificate: %s", err)
+	}
+
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:     c.proxyRoots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return nil, fmt.Errorf("could not verify forwarded client certificate: %s", err)
+	}
+
+	return cert, nil
+}
+
+// peerCertificate extracts the request's verified client certificate, directly or from a trusted proxy
+func (c *ClientCertAuth) peerCertificate(r *vertex.Request) (*x509.Certificate, bool, error) {
+
+	// we only accept certificates verified in the handshake
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		return r.TLS.VerifiedChains[0][0], false, nil
+	}
+
+	if c.proxyHeader != "" {
+		if value := r.Header.Get(c.proxyHeader); value != "" {
+			if !c.isTrustedProxy(r) {
+				return nil, false, fmt.Errorf("forwarded client certificate from untrusted address %s", r.Request.RemoteAddr)
+			}
+
+			cert, err := c.forwardedCert(value)
+			return cert, true, err
+		}
+	}
+
+	return nil, false, errors.New("no verified client certificate")
+}
+
+// allowed checks the identity against the allowlists. With no allowlists, any verified identity is allowed
+func (c *ClientCertAuth) allowed(id *PeerIdentity) bool {
+
+	if len(c.commonNames) == 0 && len(c.sans) == 0 {
+		return true
+	}
+
+	if c.commonNames[id.CommonName] {
+		return true
+	}
+
+	for _, san := range id.SANs {
+		if c.sans[san] {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate authenticates the request's client certificate and sets its identity on the request
+func (c *ClientCertAuth) Validate(r *vertex.Request) error {
+
+	cert, forwarded, err := c.peerCertificate(r)
+	if err != nil {
+		return vertex.UnauthorizedError("%s", err)
+	}
+
+	id := newPeerIdentity(cert, forwarded)
+	if !c.allowed(id) {
+		logging.Warning("Client certificate %s (SANs: %v) is not allowed", id.CommonName, id.SANs)
+		return vertex.UnauthorizedError("client certificate '%s' is not allowed", id.CommonName)
+	}
+
+	r.SetAttribute(AttrPeerIdentity, id)
+	return nil
+}
+
+func (c *ClientCertAuth) Handle(w http.ResponseWriter, r *vertex.Request, next vertex.HandlerFunc) (interface{}, error) {
+
+	if err := c.Validate(r); err != nil {
+		return nil, err
+	}
+
+	return next(w, r)
+}
@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/EverythingMe/vertex"
+)
+
+// newTestCert creates a client certificate signed by parent, or a self signed CA if parent is nil
+func newTestCert(t *testing.T, cn string, dnsNames []string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	if parent == nil {
+		tpl.IsCA = true
+		tpl.BasicConstraintsValid = true
+		tpl.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = tpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestClientCertAuth(t *testing.T) {
+
+	ca, caKey := newTestCert(t, "Test CA", nil, nil, nil)
+	billing, _ := newTestCert(t, "billing", []string{"billing.internal"}, ca, caKey)
+	search, _ := newTestCert(t, "search", []string{"search.internal"}, ca, caKey)
+
+	otherCA, otherKey := newTestCert(t, "Other CA", nil, nil, nil)
+	rogue, _ := newTestCert(t, "billing", nil, otherCA, otherKey)
+
+	newRequest := func(remoteAddr string, verified *x509.Certificate, forwarded *x509.Certificate) *vertex.Request {
+		hr, _ := http.NewRequest("GET", "/foo", nil)
+		hr.RemoteAddr = remoteAddr
+		if verified != nil {
+			hr.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{verified, ca}}}
+		}
+		if forwarded != nil {
+			pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: forwarded.Raw})
+			hr.Header.Set(DefaultClientCertHeader, url.QueryEscape(string(pemCert)))
+		}
+		return vertex.NewRequest(hr)
+	}
+
+	// without allowlists, any verified certificate is allowed
+	auth := NewClientCertAuth()
+	r := newRequest("10.0.0.1:1234", search, nil)
+	assert.NoError(t, auth.Validate(r))
+	id, _ := r.Attribute(AttrPeerIdentity)
+	if assert.NotNil(t, id) {
+		assert.Equal(t, "search", id.(*PeerIdentity).CommonName)
+		assert.Equal(t, []string{"search.internal"}, id.(*PeerIdentity).SANs)
+		assert.False(t, id.(*PeerIdentity).Forwarded)
+	}
+
+	assert.Error(t, auth.Validate(newRequest("10.0.0.1:1234", nil, nil)))
+
+	// allowlists by common name or SAN
+	auth = NewClientCertAuth().AllowCommonNames("billing").AllowSANs("reports.internal")
+	assert.NoError(t, auth.Validate(newRequest("10.0.0.1:1234", billing, nil)))
+	assert.Error(t, auth.Validate(newRequest("10.0.0.1:1234", search, nil)))
+
+	auth.AllowSANs("search.internal")
+	assert.NoError(t, auth.Validate(newRequest("10.0.0.1:1234", search, nil)))
+
+	// forwarded certificates are ignored unless we trust the proxy
+	assert.Error(t, auth.Validate(newRequest("10.0.0.1:1234", nil, billing)))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	auth.TrustProxy("", roots, "10.0.0.1", "192.168.0.0/16")
+
+	r = newRequest("10.0.0.1:1234", nil, billing)
+	assert.NoError(t, auth.Validate(r))
+	id, _ = r.Attribute(AttrPeerIdentity)
+	if assert.NotNil(t, id) {
+		assert.True(t, id.(*PeerIdentity).Forwarded)
+	}
+
+	assert.NoError(t, auth.Validate(newRequest("192.168.1.1:1234", nil, billing)))
+	assert.Error(t, auth.Validate(newRequest("10.0.0.2:1234", nil, billing)))
+
+	// forwarded certificates must be signed by our CA
+	assert.Error(t, auth.Validate(newRequest("10.0.0.1:1234", nil, rogue)))
+
+	r = newRequest("10.0.0.1:1234", nil, nil)
+	r.Header.Set(DefaultClientCertHeader, "garbage")
+	assert.Error(t, auth.Validate(r))
+
+	_, err := auth.Handle(httptest.NewRecorder(), newRequest("10.0.0.2:1234", nil, search), mockkHandler)
+	assert.Error(t, err)
+}
@@ -0,0 +1,371 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/dvirsky/go-pylog/logging"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported content encodings
+const (
+	EncodingGzip   = "gzip"
+	EncodingBrotli = "br"
+	EncodingZstd   = "zstd"
+)
+
+// DefaultCompressionMinSize is the size under which responses are sent as they are, since compressing them hardly
+// saves anything
+const DefaultCompressionMinSize = 1024
+
+// DefaultMaxDecompressedSize is the maximum size of a decompressed request body, so a small compressed body can't
+// expand into gigabytes
+const DefaultMaxDecompressedSize = 10 << 20
+
+// errEmptyGzipBody is returned for gzip encoded requests without a body, which is not valid gzip
+var errEmptyGzipBody = errors.New("Empty gzip request body")
+
+// encoders create the compressing writers of each encoding
+var encoders = map[string]func(io.Writer) (io.WriteCloser, error){
+	EncodingGzip: func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, gzip.DefaultCompression)
+	},
+	EncodingBrotli: func(w io.Writer) (io.WriteCloser, error) {
+		return brotli.NewWriterLevel(w, brotli.DefaultCompression), nil
+	},
+	EncodingZstd: func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	},
+}
+
+// Compression compresses responses with the best encoding the client accepts, and decompresses gzip encoded
+// request bodies.
+//
+// It is a server middleware, wrapping the response writer of the entire server, so it compresses rendered responses,
+// static files and hijacked responses alike:
+//
+//	srv.Use(middleware.NewCompression().Handler)
+type Compression struct {
+	minSize         int
+	maxDecompressed int64
+	contentTypes    []string
+	encodings       []string
+}
+
+// NewCompression creates a compression middleware for textual content, preferring brotli, then zstd, then gzip
+func NewCompression() *Compression {
+	return &Compression{
+		minSize:         DefaultCompressionMinSize,
+		maxDecompressed: DefaultMaxDecompressedSize,
+		contentTypes: []string{"text/*", "application/json", "application/javascript", "application/xml",
+			"application/x-yaml", "image/svg+xml"},
+		encodings: []string{EncodingBrotli, EncodingZstd, EncodingGzip},
+	}
+}
+
+// MinSize sets the size in bytes under which responses are not compressed
+func (c *Compression) MinSize(size int) *Compression {
+	c.minSize = size
+	return c
+}
+
+// MaxDecompressedSize sets the maximum size in bytes of decompressed request bodies. Reading beyond it fails
+func (c *Compression) MaxDecompressedSize(size int64) *Compression {
+	c.maxDecompressed = size
+	return c
+}
+
+// ContentTypes sets the content types that are compressed. A type ending with /* matches all its subtypes, e.g. text/*
+func (c *Compression) ContentTypes(types ...string) *Compression {
+	c.contentTypes = types
+	return c
+}
+
+// Encodings sets the encodings the server may use, in order of preference. Clients' preferences come first
+func (c *Compression) Encodings(encodings ...string) *Compression {
+	ret := []string{}
+	for _, enc := range encodings {
+		if _, found := encoders[enc]; !found {
+			logging.Warning("Unsupported content encoding %s", enc)
+			continue
+		}
+		ret = append(ret, enc)
+	}
+	c.encodings = ret
+	return c
+}
+
+// Handler wraps an http handler with compression. Its signature matches vertex.ServerMiddleware
+func (c *Compression) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if err := decompressRequest(w, r, c.maxDecompressed); err == errEmptyGzipBody {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			logging.Warning("Could not decompress request body: %s", err)
+			http.Error(w, "Invalid gzip request body", http.StatusBadRequest)
+			return
+		}
+
+		encoding := c.negotiate(r.Header.Get("Accept-Encoding"))
+
+		cw := &compressWriter{ResponseWriter: w, c: c, encoding: encoding, head: r.Method == "HEAD"}
+		defer func() {
+			if err := cw.Close(); err != nil {
+				logging.Error("Error closing compressed response: %s", err)
+			}
+		}()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// decompressRequest replaces a gzip encoded request body with its decompressed content, limited to maxSize bytes
+func decompressRequest(w http.ResponseWriter, r *http.Request, maxSize int64) error {
+
+	if r.Body == nil || !strings.EqualFold(strings.TrimSpace(r.Header.Get("Content-Encoding")), EncodingGzip) {
+		return nil
+	}
+
+	gz, err := gzip.NewReader(r.Body)
+	if err == io.EOF {
+		return errEmptyGzipBody
+	} else if err != nil {
+		return err
+	}
+
+	r.Body = http.MaxBytesReader(w, struct {
+		io.Reader
+		io.Closer
+	}{gz, r.Body}, maxSize)
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+	return nil
+}
+
+// negotiate picks the encoding for a request's Accept-Encoding header, or an empty string for no compression.
+// The client's highest quality encoding wins, and ties are broken by the server's preference
+func (c *Compression) negotiate(accept string) string {
+
+	if accept == "" {
+		return ""
+	}
+
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		qualities[name] = q
+	}
+
+	ret, best := "", 0.0
+	for _, enc := range c.encodings {
+		q, found := qualities[enc]
+		if !found {
+			// a wildcard matches the encodings the client didn't mention
+			q = qualities["*"]
+		}
+		if q > best {
+			ret, best = enc, q
+		}
+	}
+	return ret
+}
+
+// compressible tells whether a content type should be compressed
+func (c *Compression) compressible(contentType string) bool {
+
+	tp := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, allowed := range c.contentTypes {
+		if strings.HasSuffix(allowed, "/*") {
+			if strings.HasPrefix(tp, strings.TrimSuffix(allowed, "*")) {
+				return true
+			}
+		} else if tp == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter buffers the beginning of a response until it knows whether to compress it - once it has the
+// minimum size, or the response ends
+type compressWriter struct {
+	http.ResponseWriter
+	c        *Compression
+	encoding string
+	head     bool
+
+	code    int
+	buf     []byte
+	decided bool
+	enc     io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	// informational responses precede the actual one
+	if code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.code != 0 || w.decided {
+		return
+	}
+	w.code = code
+
+	// responses without a body are never compressed
+	if !w.hasBody() {
+		w.decide()
+	}
+}
+
+func (w *compressWriter) hasBody() bool {
+	return w.code != http.StatusNoContent && w.code != http.StatusNotModified && !w.head
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+
+	if w.code == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.c.minSize {
+			return len(b), nil
+		}
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// decide writes the response headers, compressed or not, and the buffered content
+func (w *compressWriter) decide() error {
+
+	w.decided = true
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+
+	h := w.Header()
+	if _, found := h["Content-Type"]; !found && len(w.buf) > 0 {
+		// detect the type before compressing, or net/http would detect it from the compressed content
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+
+	// already encoded content and partial content are sent as they are
+	if h.Get("Content-Encoding") == "" && h.Get("Content-Range") == "" && w.code != http.StatusPartialContent &&
+		w.c.compressible(h.Get("Content-Type")) {
+
+		h.Add("Vary", "Accept-Encoding")
+
+		if w.encoding != "" && len(w.buf) >= w.c.minSize && w.hasBody() {
+
+			enc, err := encoders[w.encoding](w.ResponseWriter)
+			if err != nil {
+				logging.Error("Could not create %s encoder: %s", w.encoding, err)
+			} else {
+				w.enc = enc
+				h.Set("Content-Encoding", w.encoding)
+				h.Del("Content-Length")
+				// byte ranges of the uncompressed content are meaningless for the compressed one
+				h.Del("Accept-Ranges")
+			}
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(w.code)
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	buf := w.buf
+	w.buf = nil
+	if w.enc != nil {
+		_, err := w.enc.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// Flush sends what was written so far, even if it is under the minimum size, e.g. for streaming responses
+func (w *compressWriter) Flush() {
+
+	if !w.decided {
+		if err := w.decide(); err != nil {
+			logging.Error("Error writing response: %s", err)
+		}
+	}
+
+	if f, ok := w.enc.(interface {
+		Flush() error
+	}); ok {
+		if err := f.Flush(); err != nil {
+			logging.Error("Error flushing compressed response: %s", err)
+		}
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets handlers take over the connection, e.g. for websockets, if the underlying writer supports it
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		w.decided = true
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("Response writer does not support hijacking")
+}
+
+// Close ends the response, writing the content of responses under the minimum size and closing the encoder
+func (w *compressWriter) Close() error {
+
+	if !w.decided {
+		// nothing was written, net/http sends the default response
+		if w.code == 0 && len(w.buf) == 0 {
+			return nil
+		}
+		if err := w.decide(); err != nil {
+			return err
+		}
+	}
+
+	if w.enc != nil {
+		return w.enc.Close()
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/EverythingMe/vertex"
+)
+
+func TestCompressionNegotiation(t *testing.T) {
+
+	c := NewCompression()
+
+	assert.Equal(t, "", c.negotiate(""))
+	assert.Equal(t, "gzip", c.negotiate("gzip, deflate"))
+	assert.Equal(t, "br", c.negotiate("gzip, deflate, br, zstd"))
+	assert.Equal(t, "gzip", c.negotiate("br;q=0.5, gzip;q=0.8"))
+	assert.Equal(t, "zstd", c.negotiate("br;q=0, *"))
+	assert.Equal(t, "", c.negotiate("identity"))
+	assert.Equal(t, "", c.negotiate("gzip;q=0"))
+
+	c.Encodings("gzip", "deflate")
+	assert.Equal(t, []string{"gzip"}, c.encodings)
+	assert.Equal(t, "gzip", c.negotiate("br, gzip"))
+
+	assert.True(t, c.compressible("application/json; charset=utf-8"))
+	assert.True(t, c.compressible("text/html"))
+	assert.False(t, c.compressible("image/png"))
+	assert.False(t, c.compressible(""))
+}
+
+func TestCompression(t *testing.T) {
+
+	big := strings.Repeat("compress me please ", 200)
+
+	a := &vertex.API{
+		Name:          "compresstung",
+		Version:       "1.0",
+		Renderer:      vertex.JSONRenderer{},
+		AllowInsecure: true,
+		Routes: vertex.Routes{
+			{Path: "/big", Methods: vertex.GET, Handler: vertex.HandlerFunc(func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+				return big, nil
+			})},
+			{Path: "/small", Methods: vertex.GET, Handler: vertex.HandlerFunc(func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+				return "small", nil
+			})},
+			{Path: "/hijacked", Methods: vertex.GET, Handler: vertex.HandlerFunc(func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+				w.Header().Set("Content-Type", "text/plain")
+				io.WriteString(w, big)
+				return nil, vertex.Hijacked
+			})},
+			{Path: "/encoded", Methods: vertex.GET, Handler: vertex.HandlerFunc(func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Content-Encoding", "gzip")
+				io.WriteString(w, big)
+				return nil, vertex.Hijacked
+			})},
+			{Path: "/echo", Methods: vertex.POST, Handler: vertex.HandlerFunc(func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+				b, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					return nil, vertex.InvalidRequestError("Could not read body: %s", err)
+				}
+				return string(b), nil
+			})},
+		},
+	}
+
+	srv := vertex.NewServer(":9949")
+	srv.AddAPI(a)
+	srv.Use(NewCompression().MaxDecompressedSize(int64(len(big))).Handler)
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", a.FullPath(path), nil)
+		r.Header.Set("Accept-Encoding", accept)
+		srv.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		"zstd": func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+	}
+
+	for enc, decode := range decoders {
+		w := get("/big", enc)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, enc, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		assert.Contains(t, w.Header().Get("Content-Type"), "json")
+
+		r, err := decode(w.Body)
+		if assert.NoError(t, err) {
+			b, err := ioutil.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Contains(t, string(b), big)
+		}
+	}
+
+	// small responses are sent as they are
+	w := get("/small", "gzip")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	assert.Contains(t, w.Body.String(), "small")
+
+	// clients that don't accept compression get it plain, but the response still varies
+	w = get("/big", "")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	assert.Contains(t, w.Body.String(), big)
+
+	w = get("/hijacked", "gzip")
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+
+	// already compressed content is not compressed again
+	w = get("/encoded", "br")
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Empty(t, w.Header().Get("Vary"))
+	assert.Equal(t, big, w.Body.String())
+
+	// gzip encoded requests are decompressed
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	io.WriteString(gz, "hello gzip")
+	gz.Close()
+
+	w = httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", a.FullPath("/echo"), &body)
+	r.Header.Set("Content-Encoding", "gzip")
+	srv.Handler().ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "hello gzip")
+
+	post := func(body io.Reader) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", a.FullPath("/echo"), body)
+		r.Header.Set("Content-Encoding", "gzip")
+		srv.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, post(strings.NewReader("not gzip")).Code)
+
+	w = post(strings.NewReader(""))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Empty gzip request body")
+
+	// decompressed bodies are limited in size
+	compress := func(s string) io.Reader {
+		var body bytes.Buffer
+		gz := gzip.NewWriter(&body)
+		io.WriteString(gz, s)
+		gz.Close()
+		return &body
+	}
+
+	w = post(compress(big))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), big)
+
+	w = post(compress(big + "!"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NotContains(t, w.Body.String(), big)
+}
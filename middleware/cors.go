@@ -2,44 +2,153 @@ package middleware
 
 import (
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/EverythingMe/vertex"
 )
 
 type CORS struct {
 	AllowOrigin      string
+	allowedOrigins   []string
 	exposeHeaders    []string
 	allowHeaders     []string
 	allowMethods     []string
 	allowCredentials bool
+	maxAge           time.Duration
 }
 
 //Access-Control-Allow-Origin
 
-// CORS is a middleware that injects Access-Control-Allow-Origin headers
+// CORS is a middleware that injects Access-Control-Allow-Origin headers.
+//
+// It also answers preflight requests, which vertex routes to the CORS middleware of every route path with the OPTIONS
+// method. Preflights are answered with the route's methods, limited to the ones set with AllowMethods
 func (c *CORS) Handle(w http.ResponseWriter, r *vertex.Request, next vertex.HandlerFunc) (interface{}, error) {
-	if c.AllowOrigin != "" {
-		w.Header().Set("Access-Control-Allow-Origin", c.AllowOrigin)
+
+	origin, ok := c.origin(w, r)
+	if !ok {
+		// not an allowed origin - no CORS headers, so the browser blocks the response
+		return next(w, r)
 	}
 
-	if c.allowHeaders != nil && len(c.allowHeaders) > 0 {
-		w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.allowHeaders, ","))
+	if origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if c.allowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if vertex.IsPreflight(r) {
+		c.preflight(w, r)
+		return next(w, r)
 	}
 
 	if c.exposeHeaders != nil && len(c.exposeHeaders) > 0 {
 		w.Header().Set("Access-Control-Expose-Headers", strings.Join(c.exposeHeaders, ","))
 	}
 
+	if c.allowHeaders != nil && len(c.allowHeaders) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.allowHeaders, ","))
+	}
+
 	if c.allowMethods != nil && len(c.allowMethods) > 0 {
 		w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.allowMethods, ","))
 	}
 
-	if c.allowCredentials {
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	return next(w, r)
+}
+
+// HandlePreflight answers preflight requests, which run only the middleware implementing vertex.PreflightHandler
+func (c *CORS) HandlePreflight(w http.ResponseWriter, r *vertex.Request, next vertex.HandlerFunc) (interface{}, error) {
+	return c.Handle(w, r, next)
+}
+
+// origin returns the Access-Control-Allow-Origin value for a request, and false if its origin is not allowed.
+//
+// With an origin allowlist, the request's origin is reflected back, so credentials can be allowed without a wildcard.
+// Since the response then depends on the origin, it varies by it
+func (c *CORS) origin(w http.ResponseWriter, r *vertex.Request) (string, bool) {
+
+	if len(c.allowedOrigins) == 0 {
+		return c.AllowOrigin, true
 	}
 
-	return next(w, r)
+	w.Header().Add("Vary", "Origin")
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return "", false
+	}
+
+	for _, pattern := range c.allowedOrigins {
+		if matchOrigin(pattern, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// matchOrigin matches an origin to an allowed origin pattern, e.g. https://*.example.com, which matches subdomains of
+// example.com at any depth but not example.com itself
+func matchOrigin(pattern, origin string) bool {
+
+	pattern, origin = strings.ToLower(pattern), strings.ToLower(origin)
+
+	i := strings.Index(pattern, "*")
+	if i < 0 {
+		return pattern == origin
+	}
+
+	prefix, suffix := pattern[:i], pattern[i+1:]
+	if len(origin) <= len(prefix)+len(suffix) || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+
+	// the wildcard stands for subdomains only, it can't swallow the scheme or port
+	sub := origin[len(prefix) : len(origin)-len(suffix)]
+	return !strings.ContainsAny(sub, "/:")
+}
+
+// preflight writes the headers of a preflight response
+func (c *CORS) preflight(w http.ResponseWriter, r *vertex.Request) {
+
+	methods := c.allowMethods
+	if v, found := r.Attribute(vertex.AttrAllowedMethods); found {
+		methods = c.filterMethods(v.([]string))
+	}
+	if len(methods) > 0 {
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ","))
+	}
+
+	if len(c.allowHeaders) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.allowHeaders, ","))
+	}
+
+	if c.maxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.maxAge/time.Second)))
+	}
+}
+
+// filterMethods returns the route's methods that are also allowed by the CORS configuration, if it limits them
+func (c *CORS) filterMethods(methods []string) []string {
+
+	if len(c.allowMethods) == 0 {
+		return methods
+	}
+
+	ret := []string{}
+	for _, m := range methods {
+		for _, allowed := range c.allowMethods {
+			if strings.EqualFold(m, allowed) {
+				ret = append(ret, m)
+				break
+			}
+		}
+	}
+	return ret
 }
 
 func NewCORS() *CORS {
@@ -76,3 +185,17 @@ func (c *CORS) AllowMethods(methods ...string) *CORS {
 	c.allowMethods = methods
 	return c
 }
+
+// AllowOrigins limits cross origin requests to a list of origins, reflected back in Access-Control-Allow-Origin.
+// Origins may have a wildcard for subdomains, e.g. https://*.example.com. Unlike a * AllowOrigin, this works with
+// AllowCredentials
+func (c *CORS) AllowOrigins(origins ...string) *CORS {
+	c.allowedOrigins = origins
+	return c
+}
+
+// MaxAge sets how long browsers may cache preflight responses
+func (c *CORS) MaxAge(d time.Duration) *CORS {
+	c.maxAge = d
+	return c
+}
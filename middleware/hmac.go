@@ -0,0 +1,218 @@
+package middleware
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+
+	"github.com/EverythingMe/vertex"
+	"github.com/EverythingMe/vertex/signature"
+	"github.com/EverythingMe/vertex/swagger"
+)
+
+// NonceStore remembers the nonces of signed requests, to protect against replaying them.
+//
+// Seen records a nonce for ttl, and returns true if it was already recorded. Implementations backed by a shared
+// store (e.g. redis SET NX EX) should be used when running more than one server
+type NonceStore interface {
+	Seen(nonce string, ttl time.Duration) bool
+}
+
+// MemoryNonceStore is a simple in-memory nonce store, safe for concurrent use
+type MemoryNonceStore struct {
+	nonces    map[string]time.Time
+	mutex     sync.Mutex
+	lastPurge time.Time
+}
+
+// NewMemoryNonceStore creates a new empty in-memory nonce store
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{
+		nonces:    map[string]time.Time{},
+		lastPurge: time.Now(),
+	}
+}
+
+// Seen records a nonce, and returns true if it was already recorded and has not expired yet
+func (s *MemoryNonceStore) Seen(nonce string, ttl time.Duration) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+
+	// purge expired nonces every once in a while so the store doesn't grow forever
+	if now.Sub(s.lastPurge) > ttl {
+		for k, exp := range s.nonces {
+			if exp.Before(now) {
+				delete(s.nonces, k)
+			}
+		}
+		s.lastPurge = now
+	}
+
+	if exp, found := s.nonces[nonce]; found && exp.After(now) {
+		return true
+	}
+
+	s.nonces[nonce] = now.Add(ttl)
+	return false
+}
+
+// DefaultMaxSkew is the default maximal difference allowed between a signed request's timestamp and our clock
+const DefaultMaxSkew = 5 * time.Minute
+
+// HMACSecurity is a security scheme that verifies HMAC-SHA256 signed requests, as signed by signature.Signer.
+//
+// The signing key id is looked up in a key store, and the key's secret is used to verify the signature.
+// Requests with timestamps outside the clock skew window, or with nonces already seen inside it, are rejected.
+//
+// Like the APIKeyValidator, it sets the key's owner and scopes as the AttrAPIKeyOwner and AttrAPIKeyScopes request attributes.
+// It can also be used as a middleware
+type HMACSecurity struct {
+	store   KeyStore
+	nonces  NonceStore
+	maxSkew time.Duration
+	scopes  []string
+}
+
+// NewHMACSecurity creates a new HMAC security scheme, with secrets from the given key store, an in-memory nonce store
+// and the default clock skew window
+func NewHMACSecurity(store KeyStore) *HMACSecurity {
+	return &HMACSecurity{
+		store:   store,
+		nonces:  NewMemoryNonceStore(),
+		maxSkew: DefaultMaxSkew,
+	}
+}
+
+// MaxSkew sets the maximal allowed difference between a request's timestamp and our clock
+func (h *HMACSecurity) MaxSkew(d time.Duration) *HMACSecurity {
+	h.maxSkew = d
+	return h
+}
+
+// Nonces sets the store used for replay protection
+func (h *HMACSecurity) Nonces(store NonceStore) *HMACSecurity {
+	h.nonces = store
+	return h
+}
+
+// RequireScopes sets the scopes a signing key must have in order to be approved
+func (h *HMACSecurity) RequireScopes(scopes ...string) *HMACSecurity {
+	h.scopes = scopes
+	return h
+}
+
+// canonicalString reconstructs the string the client signed from the request
+func (h *HMACSecurity) canonicalString(r *vertex.Request) (string, error) {
+
+	// we do not use r.Form, since it also contains the path params
+	params := r.URL.Query()
+	bodyHash := signature.BodyHash(nil)
+
+	if signature.IsForm(r.Request) {
+		for k, v := range r.PostForm {
+			params[k] = append(params[k], v...)
+		}
+	} else if r.Body != nil {
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		// put the body back for the handler
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		bodyHash = signature.BodyHash(body)
+	}
+
+	// signature values sent as params are not signed themselves
+	for _, name := range signature.Headers {
+		params.Del(name)
+	}
+
+	return signature.CanonicalString(r.Method, r.URL.EscapedPath(), params, bodyHash,
+		signature.Value(r.Request, signature.HeaderTimestamp), signature.Value(r.Request, signature.HeaderNonce)), nil
+}
+
+// Validate verifies the request's signature, and sets the key attributes on the request
+func (h *HMACSecurity) Validate(r *vertex.Request) error {
+
+	keyId := signature.Value(r.Request, signature.HeaderKeyId)
+	sig := signature.Value(r.Request, signature.HeaderSignature)
+	nonce := signature.Value(r.Request, signature.HeaderNonce)
+	if keyId == "" || sig == "" || nonce == "" {
+		return vertex.UnauthorizedError("request is not signed")
+	}
+
+	key, found := h.store.Get(keyId)
+	if !found || key.Secret == "" {
+		return vertex.UnauthorizedError("invalid signing key '%s'", keyId)
+	}
+
+	if !key.Enabled {
+		return vertex.UnauthorizedError("signing key '%s' is disabled", keyId)
+	}
+
+	if key.Expired() {
+		return vertex.UnauthorizedError("signing key '%s' expired at %s", keyId, key.Expiry)
+	}
+
+	ts, err := signature.ParseTimestamp(signature.Value(r.Request, signature.HeaderTimestamp))
+	if err != nil {
+		return vertex.UnauthorizedError("%s", err)
+	}
+
+	if skew := time.Since(ts); skew > h.maxSkew || skew < -h.maxSkew {
+		return vertex.UnauthorizedError("request timestamp %s is outside the allowed window", ts)
+	}
+
+	canonical, err := h.canonicalString(r)
+	if err != nil {
+		return vertex.UnauthorizedError("could not read request: %s", err)
+	}
+
+	if !signature.Equal(sig, signature.Compute(key.Secret, canonical)) {
+		logging.Debug("Signature mismatch for key %s. Canonical string: %q", keyId, canonical)
+		return vertex.UnauthorizedError("invalid signature")
+	}
+
+	// we only record nonces of valid requests, so forged requests cannot burn nonces of real ones.
+	// The nonce is kept for the entire window the timestamp is valid in
+	if h.nonces.Seen(keyId+":"+nonce, 2*h.maxSkew) {
+		return vertex.UnauthorizedError("replayed request nonce '%s'", nonce)
+	}
+
+	for _, scope := range h.scopes {
+		if !key.HasScope(scope) {
+			return vertex.UnauthorizedError("signing key '%s' is missing scope '%s'", keyId, scope)
+		}
+	}
+
+	r.SetAttribute(AttrAPIKeyOwner, key.Owner)
+	r.SetAttribute(AttrAPIKeyScopes, key.Scopes)
+	return nil
+}
+
+// SecurityDefinition describes the scheme in the API's swagger, so clients and generators know to sign requests
+func (h *HMACSecurity) SecurityDefinition() (string, swagger.SecurityDefinition) {
+	return "hmac", swagger.SecurityDefinition{
+		Type:        "apiKey",
+		Name:        signature.HeaderSignature,
+		In:          "header",
+		Description: "HMAC-SHA256 request signature. See the vertex signature package for the signing scheme",
+		Signature:   signature.Algorithm,
+	}
+}
+
+func (h *HMACSecurity) Handle(w http.ResponseWriter, r *vertex.Request, next vertex.HandlerFunc) (interface{}, error) {
+
+	if err := h.Validate(r); err != nil {
+		return nil, err
+	}
+
+	return next(w, r)
+}
@@ -0,0 +1,251 @@
+package middleware
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+	"gopkg.in/yaml.v2"
+)
+
+// APIKey describes a single API key - who owns it, what it is allowed to do and until when
+type APIKey struct {
+	Key   string `yaml:"key"`
+	Owner string `yaml:"owner"`
+	// The secret used to sign requests with this key, for HMAC request signing
+	Secret  string    `yaml:"secret"`
+	Scopes  []string  `yaml:"scopes"`
+	Expiry  time.Time `yaml:"expiry"`
+	Enabled bool      `yaml:"enabled"`
+}
+
+// Expired returns true if the key has an expiry time and it has passed
+func (k APIKey) Expired() bool {
+	return !k.Expiry.IsZero() && k.Expiry.Before(time.Now())
+}
+
+// HasScope returns true if the key was granted the given scope
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyStore is the source of API keys for the APIKeyValidator.
+//
+// Get returns the key's definition and true if it exists in the store, regardless of it being enabled or expired
+type KeyStore interface {
+	Get(key string) (APIKey, bool)
+}
+
+// MemoryKeyStore is a simple, in-memory key store, safe for concurrent use
+type MemoryKeyStore struct {
+	keys  map[string]APIKey
+	mutex sync.RWMutex
+}
+
+// NewMemoryKeyStore creates a new in-memory store with the given keys
+func NewMemoryKeyStore(keys ...APIKey) *MemoryKeyStore {
+	ret := &MemoryKeyStore{
+		keys: make(map[string]APIKey, len(keys)),
+	}
+	ret.Add(keys...)
+	return ret
+}
+
+// Add adds or replaces keys in the store
+func (s *MemoryKeyStore) Add(keys ...APIKey) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, k := range keys {
+		s.keys[k.Key] = k
+	}
+}
+
+// Remove removes keys from the store
+func (s *MemoryKeyStore) Remove(keys ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, k := range keys {
+		delete(s.keys, k)
+	}
+}
+
+// Get returns a key from the store
+func (s *MemoryKeyStore) Get(key string) (APIKey, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	k, found := s.keys[key]
+	return k, found
+}
+
+// replace atomically replaces all the keys in the store
+func (s *MemoryKeyStore) replace(keys map[string]APIKey) {
+	s.mutex.Lock()
+	s.keys = keys
+	s.mutex.Unlock()
+}
+
+// keyFileEntry is the on-disk representation of a key. Keys in files are enabled unless explicitly disabled
+type keyFileEntry struct {
+	Key     string    `yaml:"key"`
+	Owner   string    `yaml:"owner"`
+	Secret  string    `yaml:"secret"`
+	Scopes  []string  `yaml:"scopes"`
+	Expiry  time.Time `yaml:"expiry"`
+	Enabled *bool     `yaml:"enabled"`
+}
+
+// FileKeyStore is a key store that reads its keys from a YAML file, and reloads it when the file changes
+// or when the process receives a SIGHUP. The file looks like:
+//
+//	keys:
+//	  - key: 01bea5da73af5b
+//	    owner: mobile-client
+//	    scopes: [read, write]
+//	    expiry: 2016-01-01T00:00:00Z
+//	  - key: billing-service
+//	    owner: billing
+//	    secret: 9c1185a5c5e9fc54612808977ee8f548b2258d31
+//	  - key: 7ff3acb1d0e2
+//	    owner: old-client
+//	    enabled: false
+//
+// If a reload fails, the store keeps serving the last valid set of keys. The file is the only source of keys - they
+// cannot be added or removed in code
+type FileKeyStore struct {
+	keys      *MemoryKeyStore
+	path      string
+	modTime   time.Time
+	mutex     sync.Mutex
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewFileKeyStore loads a key file and starts watching it for changes every checkInterval.
+// If checkInterval is 0, the file is only reloaded on SIGHUP
+func NewFileKeyStore(path string, checkInterval time.Duration) (*FileKeyStore, error) {
+
+	ret := &FileKeyStore{
+		keys: NewMemoryKeyStore(),
+		path: path,
+		stop: make(chan struct{}),
+	}
+
+	if err := ret.Reload(); err != nil {
+		return nil, err
+	}
+
+	go ret.watch(checkInterval)
+	return ret, nil
+}
+
+// Reload reads the key file and atomically replaces the keys in the store
+func (s *FileKeyStore) Reload() error {
+
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("Could not stat key file %s: %s", s.path, err)
+	}
+
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("Could not read key file %s: %s", s.path, err)
+	}
+
+	var file struct {
+		Keys []keyFileEntry `yaml:"keys"`
+	}
+	if err := yaml.Unmarshal(b, &file); err != nil {
+		return fmt.Errorf("Could not parse key file %s: %s", s.path, err)
+	}
+
+	keys := make(map[string]APIKey, len(file.Keys))
+	for _, e := range file.Keys {
+		if e.Key == "" {
+			return fmt.Errorf("Empty key in key file %s (owner: '%s')", s.path, e.Owner)
+		}
+		keys[e.Key] = APIKey{
+			Key:     e.Key,
+			Owner:   e.Owner,
+			Secret:  e.Secret,
+			Scopes:  e.Scopes,
+			Expiry:  e.Expiry,
+			Enabled: e.Enabled == nil || *e.Enabled,
+		}
+	}
+
+	s.keys.replace(keys)
+
+	s.mutex.Lock()
+	s.modTime = fi.ModTime()
+	s.mutex.Unlock()
+
+	logging.Info("Loaded %d API keys from %s", len(keys), s.path)
+	return nil
+}
+
+// changed checks whether the key file was modified since we last read it
+func (s *FileKeyStore) changed() bool {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		logging.Warning("Could not stat key file %s: %s", s.path, err)
+		return false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return !fi.ModTime().Equal(s.modTime)
+}
+
+// Get returns a key from the store
+func (s *FileKeyStore) Get(key string) (APIKey, bool) {
+	return s.keys.Get(key)
+}
+
+func (s *FileKeyStore) watch(checkInterval time.Duration) {
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	var tick <-chan time.Time
+	if checkInterval > 0 {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-sighup:
+			logging.Info("Got SIGHUP, reloading key file %s", s.path)
+		case <-tick:
+			if !s.changed() {
+				continue
+			}
+			logging.Info("Key file %s changed, reloading", s.path)
+		}
+
+		if err := s.Reload(); err != nil {
+			logging.Error("Error reloading keys, keeping the old ones: %s", err)
+		}
+	}
+}
+
+// Close stops watching the key file for changes. It is safe to call more than once
+func (s *FileKeyStore) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+}
@@ -1,13 +1,21 @@
 package middleware
 
 import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/EverythingMe/vertex"
+	"github.com/EverythingMe/vertex/signature"
 )
 
 var mockkHandler = vertex.HandlerFunc(func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
@@ -52,3 +60,404 @@ func TestAPIKeyValidator(t *testing.T) {
 	assert.Error(t, check("sdfsdfsd"))
 
 }
+
+func TestAPIKeyStoreValidator(t *testing.T) {
+
+	store := NewMemoryKeyStore(
+		APIKey{Key: "foo", Owner: "fooer", Scopes: []string{"read", "write"}, Enabled: true},
+		APIKey{Key: "bar", Owner: "barer", Scopes: []string{"read"}, Enabled: true},
+		APIKey{Key: "old", Owner: "oldie", Enabled: true, Expiry: time.Now().Add(-time.Hour)},
+		APIKey{Key: "off", Owner: "offer", Enabled: false},
+	)
+
+	v := NewAPIKeyStoreValidator("apiKey", store).RequireScopes("read")
+
+	check := func(k string) (*vertex.Request, error) {
+		hr, _ := http.NewRequest("GET", "/foo", nil)
+		hr.Header.Set(DefaultAPIKeyHeader, k)
+		r := vertex.NewRequest(hr)
+		_, err := v.Handle(httptest.NewRecorder(), r, mockkHandler)
+		return r, err
+	}
+
+	r, err := check("foo")
+	assert.NoError(t, err)
+	owner, _ := r.Attribute(AttrAPIKeyOwner)
+	assert.Equal(t, "fooer", owner)
+	scopes, _ := r.Attribute(AttrAPIKeyScopes)
+	assert.Equal(t, []string{"read", "write"}, scopes)
+
+	_, err = check("bar")
+	assert.NoError(t, err)
+
+	_, err = check("old")
+	assert.Error(t, err)
+	_, err = check("off")
+	assert.Error(t, err)
+	_, err = check("")
+	assert.Error(t, err)
+
+	// errors identify keys without giving them away
+	_, err = check("0123456789abcdef")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "0123***")
+		assert.NotContains(t, err.Error(), "0123456789abcdef")
+	}
+
+	v.RequireScopes("write")
+	_, err = check("bar")
+	assert.Error(t, err)
+
+	// header lookup disabled - we should fall back to the form param
+	v.Header("")
+	_, err = check("foo")
+	assert.Error(t, err)
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+
+	store := NewMemoryKeyStore(
+		APIKey{Key: "reader", Scopes: []string{"read"}, Enabled: true},
+		APIKey{Key: "writer", Scopes: []string{"read", "write"}, Enabled: true},
+	)
+
+	a := &vertex.API{
+		Name:          "apikeyscopes",
+		Version:       "1.0",
+		Renderer:      vertex.JSONRenderer{},
+		AllowInsecure: true,
+		Middleware:    []vertex.Middleware{NewAPIKeyStoreValidator("apiKey", store).RequireScopes("write")},
+		Routes: vertex.Routes{
+			{Path: "/ping", Methods: vertex.GET, Handler: vertex.HandlerFunc(
+				func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) { return "pong", nil })},
+		},
+	}
+
+	srv := vertex.NewServer(":9952")
+	srv.AddAPI(a)
+
+	serve := func(key string) int {
+		r, _ := http.NewRequest("GET", a.FullPath("/ping"), nil)
+		r.Header.Set(DefaultAPIKeyHeader, key)
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("writer"))
+	// a valid key without the required scopes is forbidden, an unknown one is unauthorized
+	assert.Equal(t, http.StatusForbidden, serve("reader"))
+	assert.Equal(t, http.StatusUnauthorized, serve("nope"))
+}
+
+const mockKeyFile = `
+keys:
+  - key: foo
+    owner: fooer
+    secret: s3cr3t
+    scopes: [read]
+  - key: bar
+    owner: barer
+    enabled: false
+`
+
+func TestFileKeyStore(t *testing.T) {
+
+	fp, err := ioutil.TempFile("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fp.Name())
+	fp.WriteString(mockKeyFile)
+	fp.Close()
+
+	store, err := NewFileKeyStore(fp.Name(), 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	k, found := store.Get("foo")
+	assert.True(t, found)
+	assert.True(t, k.Enabled)
+	assert.Equal(t, "fooer", k.Owner)
+	assert.Equal(t, "s3cr3t", k.Secret)
+	assert.True(t, k.HasScope("read"))
+
+	k, found = store.Get("bar")
+	assert.True(t, found)
+	assert.False(t, k.Enabled)
+
+	// rewrite the file and wait for the store to pick it up
+	if err = ioutil.WriteFile(fp.Name(), []byte("keys:\n  - key: baz\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(fp.Name(), time.Now(), time.Now().Add(time.Second))
+
+	time.Sleep(50 * time.Millisecond)
+	_, found = store.Get("foo")
+	assert.False(t, found)
+	_, found = store.Get("baz")
+	assert.True(t, found)
+
+	// closing twice is harmless
+	store.Close()
+
+	// a broken file keeps the old keys
+	assert.NoError(t, ioutil.WriteFile(fp.Name(), []byte("keys: [[[["), 0644))
+	assert.Error(t, store.Reload())
+	_, found = store.Get("baz")
+	assert.True(t, found)
+}
+
+func TestHMACSecurity(t *testing.T) {
+
+	store := NewMemoryKeyStore(
+		APIKey{Key: "billing", Owner: "billing-service", Secret: "s3cr3t", Scopes: []string{"charge"}, Enabled: true},
+		APIKey{Key: "old", Secret: "s3cr3t", Enabled: false},
+	)
+	sec := NewHMACSecurity(store).RequireScopes("charge")
+	signer := signature.NewSigner("billing", "s3cr3t")
+
+	// newRequest builds a signed request, and lets us tamper with it before it is parsed like the server does
+	newRequest := func(method, target, contentType, body string, signer *signature.Signer, tamper func(*http.Request)) *vertex.Request {
+		hr, _ := http.NewRequest(method, target, strings.NewReader(body))
+		if contentType != "" {
+			hr.Header.Set("Content-Type", contentType)
+		}
+		assert.NoError(t, signer.Sign(hr))
+		if tamper != nil {
+			tamper(hr)
+		}
+		hr.ParseForm()
+		return vertex.NewRequest(hr)
+	}
+
+	r := newRequest("GET", "/billing/charge?user=foo&amount=12", "", "", signer, nil)
+	assert.NoError(t, sec.Validate(r))
+	owner, _ := r.Attribute(AttrAPIKeyOwner)
+	assert.Equal(t, "billing-service", owner)
+
+	// replaying the same request fails
+	assert.Error(t, sec.Validate(r))
+
+	// form and json bodies are both signed, and the json body is still readable after validation
+	r = newRequest("POST", "/billing/charge?user=foo", "application/x-www-form-urlencoded", "amount=12", signer, nil)
+	assert.NoError(t, sec.Validate(r))
+
+	r = newRequest("POST", "/billing/charge", "application/json", `{"amount":12}`, signer, nil)
+	assert.NoError(t, sec.Validate(r))
+	b, _ := ioutil.ReadAll(r.Body)
+	assert.Equal(t, `{"amount":12}`, string(b))
+
+	// generated clients send the signature as params, which are not signed themselves
+	asParams := func(r *http.Request) {
+		q := r.URL.Query()
+		for _, name := range signature.Headers {
+			q.Set(name, r.Header.Get(name))
+			r.Header.Del(name)
+		}
+		r.URL.RawQuery = q.Encode()
+	}
+	r = newRequest("GET", "/billing/charge?user=foo&amount=12", "", "", signer, asParams)
+	assert.NoError(t, sec.Validate(r))
+	r = newRequest("POST", "/billing/charge?user=foo", "application/x-www-form-urlencoded", "amount=12", signer, asParams)
+	assert.NoError(t, sec.Validate(r))
+
+	tampered := []func(*http.Request){
+		func(r *http.Request) { r.URL.RawQuery = "user=foo&amount=1200" },
+		func(r *http.Request) { r.URL.Path = "/billing/refund" },
+		func(r *http.Request) { r.Method = "DELETE" },
+		func(r *http.Request) { r.Body = ioutil.NopCloser(strings.NewReader(`{"amount":1200}`)) },
+		func(r *http.Request) { r.Header.Set(signature.HeaderNonce, "other") },
+		func(r *http.Request) { r.Header.Del(signature.HeaderSignature) },
+		func(r *http.Request) {
+			r.Header.Set(signature.HeaderTimestamp, strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+		},
+	}
+	for i, tamper := range tampered {
+		r = newRequest("POST", "/billing/charge?user=foo&amount=12", "application/json", `{"amount":12}`, signer, tamper)
+		assert.Error(t, sec.Validate(r), "tampered request %d", i)
+	}
+
+	// requests outside the skew window are rejected even when properly signed
+	sec.MaxSkew(-time.Second)
+	r = newRequest("GET", "/billing/charge?user=foo", "", "", signer, nil)
+	assert.Error(t, sec.Validate(r))
+	sec.MaxSkew(DefaultMaxSkew)
+
+	// bad secrets, disabled keys and missing scopes are rejected
+	for _, s := range []*signature.Signer{
+		signature.NewSigner("billing", "wat"),
+		signature.NewSigner("old", "s3cr3t"),
+		signature.NewSigner("nope", "s3cr3t"),
+	} {
+		r = newRequest("GET", "/billing/charge", "", "", s, nil)
+		assert.Error(t, sec.Validate(r), s.KeyId)
+	}
+
+	store.Add(APIKey{Key: "billing", Secret: "s3cr3t", Enabled: true})
+	r = newRequest("GET", "/billing/charge", "", "", signer, nil)
+	assert.Error(t, sec.Validate(r))
+
+	_, err := sec.Handle(httptest.NewRecorder(), newRequest("GET", "/billing/charge", "", "", signer, nil), mockkHandler)
+	assert.Error(t, err)
+}
+
+func TestHMACSecurityForms(t *testing.T) {
+
+	store := NewMemoryKeyStore(APIKey{Key: "billing", Secret: "s3cr3t", Enabled: true})
+	signer := signature.NewSigner("billing", "s3cr3t")
+
+	a := &vertex.API{
+		Name:                  "hmacforms",
+		Version:               "1.0",
+		Renderer:              vertex.JSONRenderer{},
+		AllowInsecure:         true,
+		DefaultSecurityScheme: NewHMACSecurity(store),
+		Routes: vertex.Routes{
+			{Path: "/charge", Methods: vertex.POST, Handler: vertex.HandlerFunc(
+				func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) { return r.FormValue("amount"), nil })},
+		},
+	}
+
+	srv := vertex.NewServer(":9953")
+	srv.AddAPI(a)
+
+	post := func(contentType string, body []byte, tamper bool) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("POST", a.FullPath("/charge")+"?user=foo", bytes.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		assert.NoError(t, signer.Sign(r))
+		if tamper {
+			r.Body = ioutil.NopCloser(bytes.NewReader(bytes.Replace(body, []byte("12"), []byte("1200"), 1)))
+		}
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	// form encoded bodies are signed as params, multipart bodies are signed as bodies. Both are parsed into the
+	// request's form before the signature is verified
+	w := post("application/x-www-form-urlencoded", []byte("amount=12"), false)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"12"`, strings.TrimSpace(w.Body.String()))
+	assert.Equal(t, http.StatusUnauthorized, post("application/x-www-form-urlencoded", []byte("amount=12"), true).Code)
+
+	buf := bytes.NewBuffer(nil)
+	mw := multipart.NewWriter(buf)
+	mw.WriteField("amount", "12")
+	mw.Close()
+
+	w = post(mw.FormDataContentType(), buf.Bytes(), false)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"12"`, strings.TrimSpace(w.Body.String()))
+	assert.Equal(t, http.StatusUnauthorized, post(mw.FormDataContentType(), buf.Bytes(), true).Code)
+}
+
+func TestMemoryNonceStore(t *testing.T) {
+
+	store := NewMemoryNonceStore()
+	assert.False(t, store.Seen("foo", time.Minute))
+	assert.True(t, store.Seen("foo", time.Minute))
+	assert.False(t, store.Seen("bar", time.Minute))
+
+	// expired nonces can be used again
+	assert.False(t, store.Seen("baz", -time.Second))
+	assert.False(t, store.Seen("baz", time.Minute))
+}
+
+func TestCORS(t *testing.T) {
+
+	check := func(c *CORS, method, origin string, attrs ...string) http.Header {
+		hr, _ := http.NewRequest(method, "/foo", nil)
+		hr.Header.Set("Origin", origin)
+		if method == "OPTIONS" {
+			hr.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		r := vertex.NewRequest(hr)
+		if attrs != nil {
+			r.SetAttribute(vertex.AttrAllowedMethods, attrs)
+		}
+		w := httptest.NewRecorder()
+		_, err := c.Handle(w, r, mockkHandler)
+		assert.NoError(t, err)
+		return w.Header()
+	}
+
+	// the default wildcard origin doesn't vary by origin
+	h := check(NewCORS(), "GET", "https://foo.com")
+	assert.Equal(t, "*", h.Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, h.Get("Vary"))
+
+	c := NewCORS().AllowOrigins("https://app.example.com", "https://*.example.org").AllowCredentials(true).
+		AllowMethods("GET", "POST", "OPTIONS").MaxAge(10 * time.Minute)
+
+	h = check(c, "GET", "https://app.example.com")
+	assert.Equal(t, "https://app.example.com", h.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", h.Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "Origin", h.Get("Vary"))
+	assert.Empty(t, h.Get("Access-Control-Max-Age"))
+
+	h = check(c, "GET", "https://a.b.example.org")
+	assert.Equal(t, "https://a.b.example.org", h.Get("Access-Control-Allow-Origin"))
+
+	for _, origin := range []string{"https://example.org", "http://a.example.org", "https://evil.com/.example.org",
+		"https://other.example.com", ""} {
+		h = check(c, "GET", origin)
+		assert.Empty(t, h.Get("Access-Control-Allow-Origin"), origin)
+		assert.Empty(t, h.Get("Access-Control-Allow-Credentials"), origin)
+		assert.Equal(t, "Origin", h.Get("Vary"), origin)
+	}
+
+	// preflights get the route's methods that the configuration allows
+	h = check(c, "OPTIONS", "https://app.example.com", "GET", "POST", "DELETE")
+	assert.Equal(t, "https://app.example.com", h.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET,POST", h.Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "600", h.Get("Access-Control-Max-Age"))
+
+	h = check(c, "OPTIONS", "https://app.example.com")
+	assert.Equal(t, "GET,POST,OPTIONS", h.Get("Access-Control-Allow-Methods"))
+
+	h = check(NewCORS(), "OPTIONS", "https://foo.com", "GET")
+	assert.Equal(t, "GET", h.Get("Access-Control-Allow-Methods"))
+}
+
+func TestCORSPreflightWithAuth(t *testing.T) {
+
+	a := &vertex.API{
+		Name:          "corsauth",
+		Version:       "1.0",
+		Renderer:      vertex.JSONRenderer{},
+		AllowInsecure: true,
+		Middleware: []vertex.Middleware{
+			BasicAuth{User: "user", Password: "pass", Realm: "test"},
+			NewCORS().AllowOrigins("https://app.example.com"),
+		},
+		Routes: vertex.Routes{
+			{Path: "/ping", Methods: vertex.GET | vertex.POST, Handler: vertex.HandlerFunc(
+				func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) { return "pong", nil })},
+		},
+	}
+
+	srv := vertex.NewServer(":9951")
+	srv.AddAPI(a)
+
+	serve := func(method string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest(method, a.FullPath("/ping"), nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("Origin", "https://app.example.com")
+		if method == "OPTIONS" {
+			r.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	// browsers send preflights without credentials, so they skip the authentication
+	w := serve("OPTIONS")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	assert.Equal(t, http.StatusUnauthorized, serve("POST").Code)
+}
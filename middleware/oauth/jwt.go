@@ -0,0 +1,91 @@
+package oauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/dvirsky/go-pylog/logging"
+
+	"golang.org/x/oauth2"
+)
+
+// TokenProtocol is an interface for encoding/decoding user tokens. Currently we're using a JWT token encoder/decoder
+type TokenProtocol interface {
+	EncodeToken(*User) (string, error)
+	DecodeToken(string) (*User, error)
+}
+
+// JWTAuthenticator authenticates users from JWT encoded cookies
+type JWTAuthenticator struct {
+	key []byte
+	ttl time.Duration
+}
+
+// DefaultTokenTTL is the default lifetime of JWT user tokens. After it passes, the user's token is
+// refreshed with the provider's refresh token, or the user has to log in again
+const DefaultTokenTTL = time.Hour
+
+func NewJWTAuthenticator(key string) *JWTAuthenticator {
+	return &JWTAuthenticator{
+		key: []byte(key),
+		ttl: DefaultTokenTTL,
+	}
+}
+
+// TTL sets the lifetime of the tokens the authenticator encodes
+func (j *JWTAuthenticator) TTL(ttl time.Duration) *JWTAuthenticator {
+	j.ttl = ttl
+	return j
+}
+
+func (j *JWTAuthenticator) EncodeToken(user *User) (string, error) {
+	token := jwt.New(jwt.SigningMethodHS256)
+	token.Claims["data"] = user
+	token.Claims["exp"] = time.Now().Add(j.ttl).Unix()
+
+	sstr, err := token.SignedString(j.key)
+	if err != nil {
+		logging.Error("Error signing token: %s", err)
+
+	}
+	return sstr, err
+}
+
+// Login just returns a user identified by the access token. In a real-world situation here's where you'd want to
+// talk to your database, or use a UserInfoValidator
+func (j *JWTAuthenticator) Login(token *oauth2.Token) (*User, error) {
+	return &User{Id: token.AccessToken}, nil
+}
+
+func (j *JWTAuthenticator) DecodeToken(data string) (*User, error) {
+	token, err := jwt.Parse(data, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("Unexpected signing method %v", token.Header["alg"])
+		}
+		return j.key, nil
+	})
+
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("Invalid token: %v", err)
+	}
+
+	claim, ok := token.Claims["data"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Invalid token data: %#v", token.Claims["data"])
+	}
+
+	// the claims are decoded as a generic map, so we round-trip them to get a user
+	b, err := json.Marshal(claim)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{}
+	if err := json.Unmarshal(b, user); err != nil || user.Id == "" {
+		return nil, fmt.Errorf("Invalid user in token: %v", err)
+	}
+
+	return user, nil
+}
@@ -1,14 +1,19 @@
 // This middleware package for OAauth is incomplete (although it's working).
-// It needs tests and some more documentation. Any help is welcome...
+// It needs some more documentation. Any help is welcome...
 package oauth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"html/template"
 	"net/http"
 	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/dvirsky/go-pylog/logging"
 
 	"github.com/EverythingMe/vertex"
@@ -16,24 +21,67 @@ import (
 	"golang.org/x/oauth2"
 )
 
-// TokenProtocol is an interface for encoding/decoding user tokens. Currently we're using a JWT token encoder/decoder
-type TokenProtocol interface {
-	EncodeToken(interface{}) (string, error)
-	DecodeToken(string) (interface{}, error)
+// OAuthMiddleware is a middleware that can protect routes and make sure the user is logged in.
+// It uses JWT to encode cookies with the user token.
+//
+// The middleware can log users in with several named providers, each with its own user validator and its own
+// login callback at /login/{provider}. Unauthenticated users are redirected to the provider if there is only one,
+// or get a page to choose a provider from.
+//
+// The login flow is protected against CSRF with a random state bound to a cookie, and uses PKCE (RFC 7636)
+// unless disabled. If the provider issues refresh tokens, expired user tokens are renewed transparently
+type OAuthMiddleware struct {
+	config    *Config
+	tokens    TokenProtocol
+	providers map[string]*provider
+	names     []string
+	cookie    CookieConfig
+	logoutURL string
 }
 
-// UserValidator takes care of logging in users with tokens. Currently uses JWT encoding of the user id, without any DB behind this
-type UserValidator interface {
-	TokenProtocol
-	Login(token *oauth2.Token) (interface{}, error)
+// provider is a single OAuth provider users can log in with
+type provider struct {
+	name      string
+	title     string
+	conf      *oauth2.Config
+	validator UserValidator
+	pkce      bool
 }
 
-// OAuthMiddleware is a middleware that can protect routes and make sure the user is logged in.
-// It uses JWT to encode cookies with the user token
-type OAuthMiddleware struct {
-	conf          *oauth2.Config
-	jwtKey        []byte
-	userValidator UserValidator
+// CookieConfig controls the attributes of the cookies the middleware sets
+type CookieConfig struct {
+	Domain   string `yaml:"domain"`
+	Path     string `yaml:"path"`
+	Secure   bool   `yaml:"secure"`
+	HttpOnly bool   `yaml:"http_only"`
+	// SameSite policy: lax, strict or none
+	SameSite string `yaml:"same_site"`
+	// How long the login and refresh cookies live in the browser
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// DefaultCookieConfig returns the cookie configuration used when none is given - secure, http only,
+// same site lax cookies that live for 24 hours
+func DefaultCookieConfig() CookieConfig {
+	return CookieConfig{
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: "lax",
+		MaxAge:   24 * time.Hour,
+	}
+}
+
+func (c CookieConfig) sameSite() http.SameSite {
+	switch strings.ToLower(c.SameSite) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	case "lax":
+		return http.SameSiteLaxMode
+	}
+	return http.SameSiteDefaultMode
 }
 
 // OAuth2 config. This is copied from the oauth2 library so it can be parsed from yaml with added tags
@@ -54,13 +102,89 @@ type Config struct {
 
 	// Scope specifies optional requested permissions.
 	Scopes []string `yaml:"scopes"`
+
+	// Disable PKCE, for providers that do not support it
+	DisablePKCE bool `yaml:"disable_pkce"`
+
+	// Where to redirect users after logging out. If empty, the logout handler just returns a message
+	LogoutRedirectURL string `yaml:"logout_redirect_url"`
+
+	// Cookie attributes. If not set, DefaultCookieConfig() is used
+	Cookie *CookieConfig `yaml:"cookie"`
+
+	// The provider's display name on the provider choosing page
+	Title string `yaml:"title"`
+
+	// Named provider configs, for APIs that let users log in with more than one provider. e.g.
+	//	apis:
+	//	  myapi:
+	//	    oauth:
+	//	      cookie:
+	//	        domain: example.com
+	//	      providers:
+	//	        google:
+	//	          client_id: ...
+	//	          redirect_url: https://example.com/myapi/1.0/login/google
+	//	        github:
+	//	          client_id: ...
+	//	          redirect_url: https://example.com/myapi/1.0/login/github
+	Providers map[string]*Config `yaml:"providers"`
 }
 
-// NewOAuthMiddleware crea
-func NewOAuthMiddleware(config *Config, validator UserValidator) *OAuthMiddleware {
+// NewOAuthMiddleware creates a new middleware from an OAuth2 config and a token protocol for encoding logged in
+// users. Providers are added to it with Provider or AddProvider
+func NewOAuthMiddleware(config *Config, tokens TokenProtocol) *OAuthMiddleware {
+
+	cookie := DefaultCookieConfig()
+	if config.Cookie != nil {
+		cookie = *config.Cookie
+	}
 
 	return &OAuthMiddleware{
-		userValidator: validator,
+		config:    config,
+		tokens:    tokens,
+		providers: map[string]*provider{},
+		names:     []string{},
+		cookie:    cookie,
+		logoutURL: config.LogoutRedirectURL,
+	}
+}
+
+// Provider adds a provider configured in the middleware config's providers section, with a validator that maps its
+// users. If the config has no providers section, the config itself is used as the provider's config.
+// It panics if the providers section has no config for the provider, so misconfigured APIs fail on startup
+func (o *OAuthMiddleware) Provider(name string, validator UserValidator) *OAuthMiddleware {
+
+	config := o.config
+	if len(o.config.Providers) > 0 {
+		var found bool
+		if config, found = o.config.Providers[name]; !found || config == nil {
+			// a missing provider would only show up when users fail to log in, so we fail right away
+			panic(fmt.Sprintf("No config for OAuth provider %s", name))
+		}
+	}
+
+	return o.AddProvider(name, config, validator)
+}
+
+// AddProvider adds a provider users can log in with, with its own config and a validator that maps its users.
+// Its login callback is at /login/{name}, and should be set as the config's redirect url
+func (o *OAuthMiddleware) AddProvider(name string, config *Config, validator UserValidator) *OAuthMiddleware {
+
+	title := config.Title
+	if title == "" {
+		title = name
+	}
+
+	if _, found := o.providers[name]; !found {
+		o.names = append(o.names, name)
+	}
+
+	o.providers[name] = &provider{
+		name:      name,
+		title:     title,
+		validator: validator,
+		pkce:      !config.DisablePKCE,
 		conf: &oauth2.Config{
 			ClientID:     config.ClientID,
 			ClientSecret: config.ClientSecret,
@@ -72,20 +196,32 @@ func NewOAuthMiddleware(config *Config, validator UserValidator) *OAuthMiddlewar
 			},
 		},
 	}
+	return o
 }
 
 const (
-	tokenKey  = "oauth...token"
-	loginPath = "/login"
-	AttrUser  = "oauth_user"
-	nextUrl   = "next_url"
+	tokenKey    = "oauth...token"
+	refreshKey  = "oauth...refresh"
+	providerKey = "oauth...provider"
+	stateKey    = "oauth...state"
+	verifierKey = "oauth...verifier"
+	loginPath   = "/login"
+	loginRoute  = loginPath + "/{provider}"
+	logoutPath  = "/logout"
+	nextUrl     = "next_url"
+
+	// The request attribute holding the logged in *User
+	AttrUser = "oauth_user"
+
+	// how long a user has to complete the login flow at the provider
+	loginFlowTTL = 10 * time.Minute
 )
 
-func (o *OAuthMiddleware) getToken(r *vertex.Request) (interface{}, error) {
+func (o *OAuthMiddleware) getToken(r *vertex.Request) (*User, error) {
 
 	if cookie, err := r.Cookie(tokenKey); err == nil {
 
-		user, err := o.userValidator.DecodeToken(cookie.Value)
+		user, err := o.tokens.DecodeToken(cookie.Value)
 		if err != nil {
 			return nil, err
 		}
@@ -93,111 +229,248 @@ func (o *OAuthMiddleware) getToken(r *vertex.Request) (interface{}, error) {
 		return user, nil
 
 	}
-	return "", errors.New("Could not get cookie")
+	return nil, errors.New("Could not get cookie")
 
 }
 
-// JWTAuthenticator authenticates users from JWT encoded cookies
-type JWTAuthenticator struct {
-	key []byte
+// randomString generates a url safe random string from n random bytes
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// pkceChallenge derives an S256 PKCE code challenge from a verifier
+func pkceChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
 }
 
-func NewJWTAuthenticator(key string) *JWTAuthenticator {
-	return &JWTAuthenticator{
-		key: []byte(key),
+func (o *OAuthMiddleware) setCookie(w http.ResponseWriter, name, value string, ttl time.Duration) {
+
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     o.cookie.Path,
+		Domain:   o.cookie.Domain,
+		Secure:   o.cookie.Secure,
+		HttpOnly: o.cookie.HttpOnly,
+		SameSite: o.cookie.sameSite(),
+	}
+
+	if ttl < 0 {
+		cookie.MaxAge = -1
+	} else if ttl > 0 {
+		cookie.Expires = time.Now().Add(ttl)
+		cookie.MaxAge = int(ttl.Seconds())
 	}
+	http.SetCookie(w, cookie)
+}
+
+func (o *OAuthMiddleware) clearCookie(w http.ResponseWriter, name string) {
+	o.setCookie(w, name, "", -1)
 }
 
-func (j *JWTAuthenticator) EncodeToken(data interface{}) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
-	token.Claims["data"] = data
+// startLogin saves the login flow state in the user's cookies and redirects the user to the provider
+func (o *OAuthMiddleware) startLogin(w http.ResponseWriter, r *vertex.Request, p *provider) error {
 
-	sstr, err := token.SignedString(j.key)
+	state, err := randomString(32)
 	if err != nil {
-		logging.Error("Error signing token: %s", err)
+		return err
+	}
 
+	o.setCookie(w, stateKey, state, loginFlowTTL)
+
+	opts := []oauth2.AuthCodeOption{oauth2.AccessTypeOnline}
+	if p.pkce {
+		verifier, err := randomString(32)
+		if err != nil {
+			return err
+		}
+		o.setCookie(w, verifierKey, verifier, loginFlowTTL)
+		opts = append(opts,
+			oauth2.SetAuthURLParam("code_challenge", pkceChallenge(verifier)),
+			oauth2.SetAuthURLParam("code_challenge_method", "S256"))
 	}
-	return sstr, err
+
+	url := p.conf.AuthCodeURL(state, opts...)
+	http.Redirect(w, r.Request, url, http.StatusFound)
+	return nil
 }
 
-// Login just returns the access token. In a real-world situation here's where you'd want to
-// talk to your database
-func (j *JWTAuthenticator) Login(token *oauth2.Token) (interface{}, error) {
-	return token.AccessToken, nil
+var chooserTemplate = template.Must(template.New("chooser").Parse(`<!DOCTYPE html>
+<html><head><title>Log In</title></head>
+<body><h1>Log in with:</h1><ul>
+{{ range . }}<li><a href="{{ .conf.RedirectURL }}">{{ .title }}</a></li>
+{{ end }}</ul></body></html>
+`))
+
+// redirect sends unauthenticated users to log in - directly to the provider if we have just one, or to a
+// page that lets them choose one
+func (o *OAuthMiddleware) redirect(w http.ResponseWriter, r *vertex.Request) error {
+
+	//save the current url for laterz
+	o.setCookie(w, nextUrl, r.RequestURI, loginFlowTTL)
+
+	switch len(o.names) {
+	case 0:
+		return errors.New("No OAuth providers configured")
+	case 1:
+		return o.startLogin(w, r, o.providers[o.names[0]])
+	}
+
+	providers := make([]map[string]interface{}, 0, len(o.names))
+	for _, name := range o.names {
+		p := o.providers[name]
+		providers = append(providers, map[string]interface{}{"title": p.title, "conf": p.conf})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusUnauthorized)
+	return chooserTemplate.Execute(w, providers)
 }
 
-func (j *JWTAuthenticator) DecodeToken(data string) (interface{}, error) {
-	token, err := jwt.Parse(data, func(token *jwt.Token) (interface{}, error) {
-		return j.key, nil
-	})
+// checkState validates the state returned by the provider against the one we saved in the user's cookie
+func (o *OAuthMiddleware) checkState(r *vertex.Request) error {
 
-	if err == nil && token.Valid {
-		return token.Claims["data"].(string), nil
+	cookie, err := r.Cookie(stateKey)
+	if err != nil || cookie.Value == "" {
+		return errors.New("missing state cookie")
+	}
 
-	} else {
-		return "", logging.Errorf("Invalid token '%s' (%#v)! %s", data, token, err)
+	state := r.FormValue("state")
+	if subtle.ConstantTimeCompare([]byte(state), []byte(cookie.Value)) != 1 {
+		return errors.New("state mismatch")
 	}
+	return nil
 }
 
-func (o *OAuthMiddleware) setCookie(w http.ResponseWriter, data string, domain string) {
+// login logs the user in with the provider's token and sets the user and refresh token cookies
+func (o *OAuthMiddleware) login(w http.ResponseWriter, p *provider, tok *oauth2.Token) (*User, error) {
 
-	cookie := &http.Cookie{
-		Name:    tokenKey,
-		Value:   data,
-		Path:    "/",
-		Domain:  domain,
-		Expires: time.Now().Add(time.Hour * 24),
+	user, err := p.validator.Login(tok)
+	if err != nil {
+		return nil, vertex.UnauthorizedError("Could not validate user for login: %s", err)
 	}
-	http.SetCookie(w, cookie)
+	user.Provider = p.name
+
+	enc, err := o.tokens.EncodeToken(user)
+	if err != nil {
+		return nil, vertex.UnauthorizedError("Could not validate encode user token: %s", err)
+	}
+
+	o.setCookie(w, tokenKey, enc, o.cookie.MaxAge)
+	if tok.RefreshToken != "" {
+		o.setCookie(w, refreshKey, tok.RefreshToken, o.cookie.MaxAge)
+		o.setCookie(w, providerKey, p.name, o.cookie.MaxAge)
+	}
+
+	return user, nil
 }
 
-func (o *OAuthMiddleware) redirect(w http.ResponseWriter, r *vertex.Request) {
+// refresh tries to renew the user's token with the refresh token saved in the user's cookie
+func (o *OAuthMiddleware) refresh(w http.ResponseWriter, r *vertex.Request) (*User, error) {
 
-	//save the current url for laterz
-	http.SetCookie(w, &http.Cookie{
-		Name:  nextUrl,
-		Value: r.RequestURI,
-		Path:  "/",
-	})
+	cookie, err := r.Cookie(refreshKey)
+	if err != nil || cookie.Value == "" {
+		return nil, errors.New("no refresh token")
+	}
+
+	var p *provider
+	if pc, err := r.Cookie(providerKey); err == nil {
+		p = o.providers[pc.Value]
+	}
+	if p == nil {
+		return nil, errors.New("no provider for refresh token")
+	}
 
-	url := o.conf.AuthCodeURL("mystate", oauth2.AccessTypeOnline)
-	http.Redirect(w, r.Request, url, 302)
+	tok, err := p.conf.TokenSource(r.Context(), &oauth2.Token{RefreshToken: cookie.Value}).Token()
+	if err != nil {
+		o.clearCookie(w, refreshKey)
+		return nil, fmt.Errorf("Could not refresh token: %s", err)
+	}
 
+	// some providers do not rotate refresh tokens, so we keep the one we have
+	if tok.RefreshToken == "" {
+		tok.RefreshToken = cookie.Value
+	}
+
+	return o.login(w, p, tok)
 }
 
-func (o *OAuthMiddleware) LoginHandler() vertex.Route {
+// callback handles the provider's redirect back to us after the user logged in there
+func (o *OAuthMiddleware) callback(w http.ResponseWriter, r *vertex.Request, p *provider) (interface{}, error) {
 
-	handler := func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
-		code := r.FormValue("code")
-		logging.Info("Got code: %s", code)
+	if err := o.checkState(r); err != nil {
+		logging.Warning("Invalid OAuth state: %s", err)
+		return nil, vertex.UnauthorizedError("Invalid login state: %s", err)
+	}
+	o.clearCookie(w, stateKey)
 
-		tok, err := o.conf.Exchange(oauth2.NoContext, code)
-		if err != nil {
-			return nil, vertex.UnauthorizedError("Could not log you in: %s", err)
-		}
+	if e := r.FormValue("error"); e != "" {
+		return nil, vertex.UnauthorizedError("Login failed at %s: %s", p.name, e)
+	}
 
-		user, err := o.userValidator.Login(tok)
-		if err != nil {
-			return nil, vertex.UnauthorizedError("Could not validate user for login: %s", err)
-		}
+	code := r.FormValue("code")
+	logging.Debug("Got code: %s", code)
 
-		enc, err := o.userValidator.EncodeToken(user)
-		if err != nil {
-			return nil, vertex.UnauthorizedError("Could not validate encode user token: %s", err)
+	var opts []oauth2.AuthCodeOption
+	if p.pkce {
+		cookie, err := r.Cookie(verifierKey)
+		if err != nil || cookie.Value == "" {
+			return nil, vertex.UnauthorizedError("Missing PKCE verifier")
 		}
+		opts = append(opts, oauth2.SetAuthURLParam("code_verifier", cookie.Value))
+		o.clearCookie(w, verifierKey)
+	}
+
+	tok, err := p.conf.Exchange(r.Context(), code, opts...)
+	if err != nil {
+		return nil, vertex.UnauthorizedError("Could not log you in: %s", err)
+	}
+
+	if _, err := o.login(w, p, tok); err != nil {
+		return nil, err
+	}
+
+	if cook, err := r.Cookie(nextUrl); err == nil && cook != nil && cook.Value != "" {
+		o.clearCookie(w, nextUrl)
+		logging.Info("Found nextUrl from before auth denied. Redirecting to %s", cook.Value)
+		http.Redirect(w, r.Request, cook.Value, http.StatusTemporaryRedirect)
+		return nil, vertex.Hijacked
+	}
+
+	return "Success Logging In", nil
+}
+
+// LoginHandler returns the login route of all the providers, at /login/{provider}. Without a code from the provider
+// it starts the login flow, and with one it completes it. It panics if no providers were added
+func (o *OAuthMiddleware) LoginHandler() vertex.Route {
+
+	if len(o.providers) == 0 {
+		panic("No OAuth providers configured")
+	}
+
+	handler := func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
 
-		o.setCookie(w, enc, r.Host)
+		p, found := o.providers[r.FormValue("provider")]
+		if !found {
+			return nil, vertex.InvalidParamError("Unknown login provider '%s'", r.FormValue("provider"))
+		}
 
-		if cook, err := r.Cookie(nextUrl); err == nil && cook != nil && cook.Value != "" {
-			logging.Info("Found nextUrl from before auth denied. Redirecting to %s", cook.Value)
-			http.Redirect(w, r.Request, cook.Value, http.StatusTemporaryRedirect)
+		if r.FormValue("code") == "" && r.FormValue("error") == "" {
+			if err := o.startLogin(w, r, p); err != nil {
+				return nil, vertex.NewError(err)
+			}
 			return nil, vertex.Hijacked
 		}
 
-		return "Success Logging In", nil
+		return o.callback(w, r, p)
 	}
 	return vertex.Route{
-		Path:        loginPath,
+		Path:        loginRoute,
 		Description: "OAuth Login",
 		Handler:     vertex.HandlerFunc(handler),
 		Methods:     vertex.GET,
@@ -205,15 +478,51 @@ func (o *OAuthMiddleware) LoginHandler() vertex.Route {
 
 }
 
+// LogoutHandler returns a route that logs the user out by clearing all the middleware's cookies
+func (o *OAuthMiddleware) LogoutHandler() vertex.Route {
+
+	handler := func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+
+		for _, name := range []string{tokenKey, refreshKey, providerKey, stateKey, verifierKey, nextUrl} {
+			o.clearCookie(w, name)
+		}
+
+		if o.logoutURL != "" {
+			http.Redirect(w, r.Request, o.logoutURL, http.StatusFound)
+			return nil, vertex.Hijacked
+		}
+
+		return "Logged Out", nil
+	}
+
+	return vertex.Route{
+		Path:        logoutPath,
+		Description: "OAuth Logout",
+		Handler:     vertex.HandlerFunc(handler),
+		Methods:     vertex.GET | vertex.POST,
+	}
+}
+
 func (o *OAuthMiddleware) Handle(w http.ResponseWriter, r *vertex.Request, next vertex.HandlerFunc) (interface{}, error) {
 
-	if strings.HasSuffix(r.URL.Path, loginPath) {
+	// only the login and logout routes themselves are open. We match the route and not the url, so protected routes
+	// that merely look like them, e.g. /admin/login/foo, still need a user
+	if route, _ := r.Attribute(vertex.AttrRoutePath); route == loginRoute || route == logoutPath {
 		return next(w, r)
 	}
+
 	user, err := o.getToken(r)
 	if err != nil {
-		o.redirect(w, r)
-		return nil, vertex.Hijacked
+
+		if user, err = o.refresh(w, r); err != nil {
+			logging.Debug("Could not authenticate request: %s", err)
+
+			if err := o.redirect(w, r); err != nil {
+				return nil, vertex.NewError(err)
+			}
+			return nil, vertex.Hijacked
+		}
+		logging.Info("Refreshed user token")
 
 	}
 
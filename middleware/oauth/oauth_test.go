@@ -0,0 +1,374 @@
+package oauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/EverythingMe/vertex"
+
+	"golang.org/x/oauth2"
+)
+
+// stubProvider is a minimal local OAuth2 provider that issues codes, checks PKCE and refreshes tokens
+type stubProvider struct {
+	*httptest.Server
+	challenges map[string]string
+	refreshes  int
+	mutex      sync.Mutex
+}
+
+func newStubProvider() *stubProvider {
+	p := &stubProvider{challenges: map[string]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/user", p.user)
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+// authorize simulates the user approving the login at the provider, returning the code
+func (p *stubProvider) authorize(authURL string) (code, state string) {
+	u, _ := url.Parse(authURL)
+	code = "code-" + u.Query().Get("state")
+
+	p.mutex.Lock()
+	p.challenges[code] = u.Query().Get("code_challenge")
+	p.mutex.Unlock()
+
+	return code, u.Query().Get("state")
+}
+
+func (p *stubProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		challenge, found := p.challenges[r.FormValue("code")]
+		if !found || challenge != pkceChallenge(r.FormValue("code_verifier")) {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		delete(p.challenges, r.FormValue("code"))
+	case "refresh_token":
+		if r.FormValue("refresh_token") != "refresh-me" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		p.refreshes++
+	default:
+		http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  "user-token",
+		"refresh_token": "refresh-me",
+		"token_type":    "bearer",
+		"expires_in":    3600,
+	})
+}
+
+func (p *stubProvider) user(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer user-token" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"id": 1234, "login": "octocat", "email": "octo@example.com"}`))
+}
+
+func newRequest(target string, cookies []*http.Cookie) *vertex.Request {
+	hr := httptest.NewRequest("GET", target, nil)
+	for _, c := range cookies {
+		if c.MaxAge >= 0 {
+			hr.AddCookie(c)
+		}
+	}
+	return vertex.NewRequest(hr)
+}
+
+var protected = vertex.HandlerFunc(func(w http.ResponseWriter, r *vertex.Request) (interface{}, error) {
+	user, _ := RequestUser(r)
+	return user, nil
+})
+
+func TestLoginFlow(t *testing.T) {
+
+	provider := newStubProvider()
+	defer provider.Close()
+
+	auth := NewJWTAuthenticator("s3cr3t")
+	mw := NewOAuthMiddleware(&Config{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		AuthURL:      provider.URL + "/auth",
+		TokenURL:     provider.URL + "/token",
+		RedirectURL:  "http://example.com/api/login/stub",
+	}, auth).Provider("stub", auth)
+
+	// unauthenticated requests get redirected to the provider
+	w := httptest.NewRecorder()
+	_, err := mw.Handle(w, newRequest("/api/foo", nil), protected)
+	assert.Equal(t, vertex.Hijacked, err)
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	flowCookies := w.Result().Cookies()
+	for _, c := range flowCookies {
+		assert.True(t, c.Secure, c.Name)
+		assert.True(t, c.HttpOnly, c.Name)
+	}
+
+	code, state := provider.authorize(w.Header().Get("Location"))
+	assert.NotEmpty(t, state)
+	assert.NotEqual(t, "mystate", state)
+
+	login := mw.LoginHandler().Handler
+
+	// a forged state is rejected
+	w = httptest.NewRecorder()
+	_, err = login.Handle(w, newRequest("/api/login/stub?provider=stub&code="+code+"&state=forged", flowCookies))
+	assert.Error(t, err)
+
+	// the real state logs us in and redirects back to where we were
+	w = httptest.NewRecorder()
+	_, err = login.Handle(w, newRequest("/api/login/stub?provider=stub&code="+code+"&state="+state, flowCookies))
+	assert.Equal(t, vertex.Hijacked, err)
+	assert.Equal(t, "/api/foo", w.Header().Get("Location"))
+
+	sessionCookies := w.Result().Cookies()
+	w = httptest.NewRecorder()
+	user, err := mw.Handle(w, newRequest("/api/foo", sessionCookies), protected)
+	assert.NoError(t, err)
+	assert.Equal(t, &User{Provider: "stub", Id: "user-token"}, user)
+
+	// the code cannot be reused, and without the PKCE verifier the exchange fails
+	w = httptest.NewRecorder()
+	_, err = login.Handle(w, newRequest("/api/login/stub?provider=stub&code="+code+"&state="+state, flowCookies[:2]))
+	assert.Error(t, err)
+
+	// logging out clears the cookies
+	w = httptest.NewRecorder()
+	_, err = mw.LogoutHandler().Handler.Handle(w, newRequest("/api/logout", sessionCookies))
+	assert.NoError(t, err)
+	for _, c := range w.Result().Cookies() {
+		assert.Equal(t, -1, c.MaxAge, c.Name)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+
+	provider := newStubProvider()
+	defer provider.Close()
+
+	expired := NewJWTAuthenticator("s3cr3t").TTL(-time.Minute)
+	tok, err := expired.EncodeToken(&User{Provider: "stub", Id: "old-user-token"})
+	assert.NoError(t, err)
+
+	_, err = expired.DecodeToken(tok)
+	assert.Error(t, err)
+
+	auth := NewJWTAuthenticator("s3cr3t")
+	mw := NewOAuthMiddleware(&Config{
+		ClientID: "client",
+		AuthURL:  provider.URL + "/auth",
+		TokenURL: provider.URL + "/token",
+	}, auth).Provider("stub", auth)
+
+	cookies := []*http.Cookie{
+		{Name: tokenKey, Value: tok},
+		{Name: refreshKey, Value: "refresh-me"},
+		{Name: providerKey, Value: "stub"},
+	}
+
+	w := httptest.NewRecorder()
+	user, err := mw.Handle(w, newRequest("/api/foo", cookies), protected)
+	assert.NoError(t, err)
+	assert.Equal(t, &User{Provider: "stub", Id: "user-token"}, user)
+	assert.Equal(t, 1, provider.refreshes)
+
+	// a bad refresh token sends us back to the provider
+	cookies[1].Value = "wat"
+	w = httptest.NewRecorder()
+	_, err = mw.Handle(w, newRequest("/api/foo", cookies), protected)
+	assert.Equal(t, vertex.Hijacked, err)
+	assert.Equal(t, http.StatusFound, w.Code)
+}
+
+func TestMultipleProviders(t *testing.T) {
+
+	provider := newStubProvider()
+	defer provider.Close()
+
+	auth := NewJWTAuthenticator("s3cr3t")
+	mw := NewOAuthMiddleware(&Config{
+		Providers: map[string]*Config{
+			"stub": {
+				ClientID:    "client",
+				AuthURL:     provider.URL + "/auth",
+				TokenURL:    provider.URL + "/token",
+				RedirectURL: "http://example.com/api/login/stub",
+				Title:       "Stub Login",
+			},
+			"github": {
+				ClientID:    "client",
+				AuthURL:     provider.URL + "/auth",
+				TokenURL:    provider.URL + "/token",
+				RedirectURL: "http://example.com/api/login/github",
+			},
+		},
+	}, auth).
+		Provider("stub", auth).
+		Provider("github", NewUserInfoValidator(provider.URL+"/user", GitHubMapper))
+
+	// unconfigured providers fail right away
+	assert.Panics(t, func() { mw.Provider("missing", auth) })
+	assert.Panics(t, func() { NewOAuthMiddleware(&Config{}, auth).LoginHandler() })
+
+	assert.Equal(t, []string{"stub", "github"}, mw.names)
+
+	// with more than one provider, unauthenticated users get to choose
+	w := httptest.NewRecorder()
+	_, err := mw.Handle(w, newRequest("/api/foo", nil), protected)
+	assert.Equal(t, vertex.Hijacked, err)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), `href="http://example.com/api/login/stub">Stub Login<`)
+	assert.Contains(t, w.Body.String(), `href="http://example.com/api/login/github">github<`)
+
+	login := mw.LoginHandler().Handler
+
+	// choosing a provider starts its flow
+	w = httptest.NewRecorder()
+	_, err = login.Handle(w, newRequest("/api/login/github?provider=github", nil))
+	assert.Equal(t, vertex.Hijacked, err)
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	flowCookies := w.Result().Cookies()
+	code, state := provider.authorize(w.Header().Get("Location"))
+
+	w = httptest.NewRecorder()
+	_, err = login.Handle(w, newRequest("/api/login/github?provider=github&code="+code+"&state="+state, flowCookies))
+	assert.NoError(t, err)
+
+	// the user is mapped by the provider's validator, and remembers which provider it came from
+	sessionCookies := w.Result().Cookies()
+	user, err := mw.Handle(httptest.NewRecorder(), newRequest("/api/foo", sessionCookies), protected)
+	assert.NoError(t, err)
+	if assert.NotNil(t, user) {
+		assert.Equal(t, "github", user.(*User).Provider)
+		assert.Equal(t, "octocat", user.(*User).Name)
+	}
+
+	_, err = login.Handle(httptest.NewRecorder(), newRequest("/api/login/nope?provider=nope", nil))
+	assert.Error(t, err)
+}
+
+func TestOpenRoutes(t *testing.T) {
+
+	auth := NewJWTAuthenticator("s3cr3t")
+	mw := NewOAuthMiddleware(&Config{
+		ClientID:    "client",
+		AuthURL:     "http://example.com/auth",
+		TokenURL:    "http://example.com/token",
+		RedirectURL: "http://example.com/oauthtung/1.0/login/stub",
+	}, auth).Provider("stub", auth)
+
+	a := &vertex.API{
+		Name:          "oauthtung",
+		Version:       "1.0",
+		Renderer:      vertex.JSONRenderer{},
+		AllowInsecure: true,
+		Middleware:    []vertex.Middleware{mw},
+		Routes: vertex.Routes{
+			mw.LoginHandler(),
+			mw.LogoutHandler(),
+			{Path: "/admin/login/{id}/delete", Methods: vertex.GET, Handler: protected},
+			{Path: "/admin/logout", Methods: vertex.GET, Handler: protected},
+		},
+	}
+
+	srv := vertex.NewServer(":9950")
+	srv.AddAPI(a)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", a.FullPath(path), nil)
+		srv.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	// the middleware saves the url to get back to before redirecting to the provider
+	redirected := func(w *httptest.ResponseRecorder) bool {
+		for _, c := range w.Result().Cookies() {
+			if c.Name == nextUrl && c.MaxAge >= 0 {
+				return w.Code == http.StatusFound
+			}
+		}
+		return false
+	}
+
+	// protected routes that look like the login routes still need a user
+	assert.True(t, redirected(serve("/admin/login/x/delete")))
+	assert.True(t, redirected(serve("/admin/logout")))
+
+	assert.Equal(t, http.StatusOK, serve("/logout").Code)
+
+	// the login route starts the flow itself
+	w := serve("/login/stub")
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.False(t, redirected(w))
+	assert.Equal(t, http.StatusBadRequest, serve("/login/nope").Code)
+}
+
+func TestUserInfoValidator(t *testing.T) {
+
+	provider := newStubProvider()
+	defer provider.Close()
+
+	user, err := NewUserInfoValidator(provider.URL+"/user", GitHubMapper).Login(&oauth2.Token{AccessToken: "user-token"})
+	assert.NoError(t, err)
+	assert.Equal(t, &User{Id: "1234", Name: "octocat", Email: "octo@example.com", Extra: map[string]string{"login": "octocat"}}, user)
+
+	_, err = NewUserInfoValidator(provider.URL+"/user", GitHubMapper).Login(&oauth2.Token{AccessToken: "wat"})
+	assert.Error(t, err)
+
+	_, err = OpenIDMapper(map[string]interface{}{"name": "no subject"})
+	assert.Error(t, err)
+
+	user, err = OpenIDMapper(map[string]interface{}{"sub": "abc", "email": "a@b.c"})
+	assert.NoError(t, err)
+	assert.Equal(t, &User{Id: "abc", Email: "a@b.c"}, user)
+}
+
+func TestDecodeToken(t *testing.T) {
+
+	auth := NewJWTAuthenticator("s3cr3t")
+
+	// users without an id are rejected
+	tok, err := auth.EncodeToken(&User{Name: "nobody"})
+	assert.NoError(t, err)
+	_, err = auth.DecodeToken(tok)
+	assert.Error(t, err)
+
+	tok, err = auth.EncodeToken(&User{Provider: "stub", Id: "123", Extra: map[string]string{"foo": "bar"}})
+	assert.NoError(t, err)
+	user, err := auth.DecodeToken(tok)
+	assert.NoError(t, err)
+	assert.Equal(t, &User{Provider: "stub", Id: "123", Extra: map[string]string{"foo": "bar"}}, user)
+
+	_, err = NewJWTAuthenticator("other key").DecodeToken(tok)
+	assert.Error(t, err)
+
+	_, err = auth.DecodeToken("garbage")
+	assert.Error(t, err)
+}
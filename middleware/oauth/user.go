@@ -0,0 +1,133 @@
+package oauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/EverythingMe/vertex"
+
+	"golang.org/x/oauth2"
+)
+
+// User is a logged in user, as mapped from the provider it logged in with.
+//
+// The middleware sets it as the AttrUser attribute of authenticated requests
+type User struct {
+	// The name of the provider the user logged in with
+	Provider string `json:"provider"`
+	// The user's id at the provider
+	Id    string `json:"id"`
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+	// Any extra provider specific data the validator wants to keep in the user's token
+	Extra map[string]string `json:"extra,omitempty"`
+}
+
+// RequestUser returns the user of an authenticated request, if the OAuth middleware has authenticated it
+func RequestUser(r *vertex.Request) (*User, bool) {
+	v, found := r.Attribute(AttrUser)
+	if !found {
+		return nil, false
+	}
+	u, ok := v.(*User)
+	return u, ok
+}
+
+// UserValidator logs in users of a specific provider, mapping the provider's token to a User
+type UserValidator interface {
+	Login(token *oauth2.Token) (*User, error)
+}
+
+// UserValidatorFunc is an adapter that allows funcs to act as user validators
+type UserValidatorFunc func(token *oauth2.Token) (*User, error)
+
+// Login calls the underlying func
+func (f UserValidatorFunc) Login(token *oauth2.Token) (*User, error) {
+	return f(token)
+}
+
+// UserMapper maps a provider's user info profile to a User
+type UserMapper func(profile map[string]interface{}) (*User, error)
+
+// UserInfoValidator logs users in by fetching their profile from the provider's user info endpoint
+// with the access token, and mapping it to a user with a provider specific mapper
+type UserInfoValidator struct {
+	url    string
+	mapper UserMapper
+}
+
+// NewUserInfoValidator creates a validator that fetches user profiles from url and maps them with mapper
+func NewUserInfoValidator(url string, mapper UserMapper) *UserInfoValidator {
+	return &UserInfoValidator{
+		url:    url,
+		mapper: mapper,
+	}
+}
+
+// Login fetches the user's profile and maps it to a user
+func (v *UserInfoValidator) Login(token *oauth2.Token) (*User, error) {
+
+	client := oauth2.NewClient(oauth2.NoContext, oauth2.StaticTokenSource(token))
+	res, err := client.Get(v.url)
+	if err != nil {
+		return nil, fmt.Errorf("Could not get user info: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Could not get user info: %s", res.Status)
+	}
+
+	profile := map[string]interface{}{}
+	if err := json.NewDecoder(res.Body).Decode(&profile); err != nil {
+		return nil, fmt.Errorf("Could not decode user info: %s", err)
+	}
+
+	return v.mapper(profile)
+}
+
+func profileString(profile map[string]interface{}, key string) string {
+	switch v := profile[key].(type) {
+	case string:
+		return v
+	case float64:
+		return fmt.Sprintf("%.0f", v)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// OpenIDMapper maps standard OpenID Connect user info claims (sub, name, email), as returned by Google and
+// most internal identity providers
+func OpenIDMapper(profile map[string]interface{}) (*User, error) {
+	u := &User{
+		Id:    profileString(profile, "sub"),
+		Name:  profileString(profile, "name"),
+		Email: profileString(profile, "email"),
+	}
+	if u.Id == "" {
+		return nil, errors.New("user info has no subject")
+	}
+	return u, nil
+}
+
+// GitHubMapper maps GitHub style user profiles, with a numeric id and a login name
+func GitHubMapper(profile map[string]interface{}) (*User, error) {
+	u := &User{
+		Id:    profileString(profile, "id"),
+		Name:  profileString(profile, "name"),
+		Email: profileString(profile, "email"),
+		Extra: map[string]string{"login": profileString(profile, "login")},
+	}
+	if u.Id == "" {
+		return nil, errors.New("user info has no id")
+	}
+	if u.Name == "" {
+		u.Name = u.Extra["login"]
+	}
+	return u, nil
+}
@@ -0,0 +1,80 @@
+package vertex
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/EverythingMe/vertex/openapi"
+	"github.com/EverythingMe/vertex/swagger"
+)
+
+// securityScheme converts a swagger security definition to an OpenAPI security scheme
+func securityScheme(def swagger.SecurityDefinition) openapi.SecurityScheme {
+
+	ret := openapi.SecurityScheme{
+		Type:        def.Type,
+		Description: def.Description,
+		Name:        def.Name,
+		In:          def.In,
+		Signature:   def.Signature,
+	}
+
+	// swagger's basic auth is an http scheme in OpenAPI 3
+	if def.Type == "basic" {
+		ret.Type = "http"
+		ret.Scheme = "basic"
+	}
+	return ret
+}
+
+// ToOpenAPI converts an API definition into an OpenAPI 3.1 document for serialization
+func (a API) ToOpenAPI(serverUrl string) *openapi.Document {
+
+	ret := openapi.NewDocument(a.Title, a.Doc, a.Version)
+
+	schemes := []string{"https"}
+	if a.AllowInsecure {
+		schemes = []string{"http", "https"}
+	}
+	for _, scheme := range schemes {
+		ret.Servers = append(ret.Servers, openapi.Server{URL: fmt.Sprintf("%s://%s%s", scheme, serverUrl, a.FullPath(""))})
+	}
+
+	// describe the default security scheme if it knows how
+	if sec, ok := a.DefaultSecurityScheme.(SwaggerSecurityScheme); ok {
+		name, def := sec.SecurityDefinition()
+		ret.Components.SecuritySchemes[name] = securityScheme(def)
+		ret.Security = []map[string][]string{{name: {}}}
+	}
+
+	names, descriptions := a.tags()
+	for _, name := range names {
+		ret.Tags = append(ret.Tags, openapi.Tag{Name: strings.Title(name), Description: descriptions[name]})
+	}
+
+	contentTypes := a.Renderer.ContentTypes()
+
+	for _, route := range a.Routes {
+		// only the methods the router registers. PUT is not one of them, and its flag is GET|POST
+		for _, m := range []struct {
+			name string
+			flag MethodFlag
+		}{{"GET", GET}, {"POST", POST}} {
+			if route.Methods&m.flag == m.flag {
+				op := route.requestInfo.ToOpenAPI(ret, m.name, contentTypes)
+				op.Deprecated = a.isDeprecated(route)
+				ret.AddOperation(route.Path, m.name, op)
+			}
+		}
+	}
+
+	return ret
+}
+
+// openAPIHandler handles the OpenAPI description request for the API
+func (a *API) openAPIHandler() MiddlewareFunc {
+	return MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+		return a.ToOpenAPI(r.Host), nil
+	})
+}
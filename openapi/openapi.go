@@ -0,0 +1,274 @@
+// Package openapi models OpenAPI 3.1 documents, and generates their schemas from Go types.
+//
+// Unlike the swagger package, schemas of named types are put in the document's components and referenced with $ref,
+// so each type is described once
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/alecthomas/jsonschema"
+)
+
+// Version is the OpenAPI version of the documents we create
+const Version = "3.1.0"
+
+// Content types of request bodies
+const (
+	JSON      = "application/json"
+	Form      = "application/x-www-form-urlencoded"
+	Multipart = "multipart/form-data"
+)
+
+// SchemaRefPrefix is the prefix of references to schemas in the document's components
+const SchemaRefPrefix = "#/components/schemas/"
+
+// ParameterRefPrefix is the prefix of references to parameters in the document's components
+const ParameterRefPrefix = "#/components/parameters/"
+
+// Info describes the meta-info of the API
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Server is a base url the API is served on
+type Server struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+// Schema is a JSON Schema (2020-12) object, as used by OpenAPI 3.1
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Default              interface{}        `json:"default,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	PatternProperties    map[string]*Schema `json:"patternProperties,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            int                `json:"minLength,omitempty"`
+	MaxLength            int                `json:"maxLength,omitempty"`
+	MinItems             int                `json:"minItems,omitempty"`
+	MaxItems             int                `json:"maxItems,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+}
+
+// Parameter describes a single query, path, header or cookie param
+type Parameter struct {
+	Ref         string  `json:"$ref,omitempty"`
+	Name        string  `json:"name,omitempty"`
+	In          string  `json:"in,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+// MediaType describes the content of a request or response body in a specific content type
+type MediaType struct {
+	Schema  *Schema     `json:"schema,omitempty"`
+	Example interface{} `json:"example,omitempty"`
+}
+
+// Header describes a header of a response
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+// RequestBody describes the body of a request
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+// Response describes a single response of an operation
+type Response struct {
+	Description string               `json:"description"`
+	Headers     map[string]Header    `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// Operation describes a single method of a path
+type Operation struct {
+	OperationID string              `json:"operationId,omitempty"`
+	Summary     string              `json:"summary,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+	Deprecated  bool                `json:"deprecated,omitempty"`
+}
+
+// PathItem maps the methods of a path, in lower case, to their operations
+type PathItem map[string]*Operation
+
+// SecurityScheme describes a security scheme requests to the API must satisfy
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	Name        string `json:"name,omitempty"`
+	In          string `json:"in,omitempty"`
+	Scheme      string `json:"scheme,omitempty"`
+	// The request signing algorithm, for schemes that require clients to sign requests
+	Signature string `json:"x-vertex-signature,omitempty"`
+}
+
+// Tag describes a tag operations are grouped by
+type Tag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// Components holds the reusable definitions of the document
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas,omitempty"`
+	Parameters      map[string]Parameter      `json:"parameters,omitempty"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// Document is an OpenAPI 3.1 document describing an API
+type Document struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       Info                  `json:"info"`
+	Servers    []Server              `json:"servers,omitempty"`
+	Paths      map[string]PathItem   `json:"paths"`
+	Components Components            `json:"components"`
+	Security   []map[string][]string `json:"security,omitempty"`
+	Tags       []Tag                 `json:"tags,omitempty"`
+}
+
+// NewDocument creates an empty document
+func NewDocument(title, description, version string) *Document {
+	return &Document{
+		OpenAPI: Version,
+		Info: Info{
+			Title:       title,
+			Description: description,
+			Version:     version,
+		},
+		Paths: make(map[string]PathItem),
+		Components: Components{
+			Schemas:         make(map[string]*Schema),
+			Parameters:      make(map[string]Parameter),
+			SecuritySchemes: make(map[string]SecurityScheme),
+		},
+	}
+}
+
+// AddOperation adds an operation to a path in the document
+func (d *Document) AddOperation(path, method string, op *Operation) {
+	item, found := d.Paths[path]
+	if !found {
+		item = PathItem{}
+		d.Paths[path] = item
+	}
+	item[strings.ToLower(method)] = op
+}
+
+// SchemaOf generates the schema of a Go value's type. Structs are added to the document's components and
+// referenced, so the returned schema is usually a $ref
+func (d *Document) SchemaOf(v interface{}) *Schema {
+	return d.SchemaOfType(reflect.TypeOf(v))
+}
+
+// SchemaOfType generates the schema of a Go type. See SchemaOf
+func (d *Document) SchemaOfType(t reflect.Type) *Schema {
+
+	s := jsonschema.ReflectFromType(t)
+	for name, def := range s.Definitions {
+		d.Components.Schemas[name] = fromJSONSchema(def)
+	}
+	return fromJSONSchema(s.Type)
+}
+
+// fromJSONSchema converts a reflected draft-04 schema, pointing its references to the document's components
+func fromJSONSchema(t *jsonschema.Type) *Schema {
+
+	if t == nil {
+		return nil
+	}
+
+	if t.Ref != "" {
+		return &Schema{Ref: SchemaRefPrefix + t.Ref[strings.LastIndex(t.Ref, "/")+1:]}
+	}
+
+	ret := &Schema{
+		Type:        t.Type,
+		Format:      t.Format,
+		Title:       t.Title,
+		Description: t.Description,
+		Default:     t.Default,
+		Enum:        t.Enum,
+		Items:       fromJSONSchema(t.Items),
+		Required:    t.Required,
+		MinLength:   t.MinLength,
+		MaxLength:   t.MaxLength,
+		MinItems:    t.MinItems,
+		MaxItems:    t.MaxItems,
+		Pattern:     t.Pattern,
+		AllOf:       fromJSONSchemas(t.AllOf),
+		AnyOf:       fromJSONSchemas(t.AnyOf),
+		OneOf:       fromJSONSchemas(t.OneOf),
+	}
+
+	// draft-04 can't tell a missing bound from a zero one
+	if t.Minimum != 0 {
+		min := float64(t.Minimum)
+		ret.Minimum = &min
+	}
+	if t.Maximum != 0 {
+		max := float64(t.Maximum)
+		ret.Maximum = &max
+	}
+
+	if len(t.Properties) > 0 {
+		ret.Properties = make(map[string]*Schema, len(t.Properties))
+		for k, v := range t.Properties {
+			ret.Properties[k] = fromJSONSchema(v)
+		}
+	}
+
+	if len(t.PatternProperties) > 0 {
+		ret.PatternProperties = make(map[string]*Schema, len(t.PatternProperties))
+		for k, v := range t.PatternProperties {
+			ret.PatternProperties[k] = fromJSONSchema(v)
+		}
+	}
+
+	if len(t.AdditionalProperties) > 0 {
+		var additional interface{}
+		if err := json.Unmarshal(t.AdditionalProperties, &additional); err == nil {
+			ret.AdditionalProperties = additional
+		}
+	}
+
+	return ret
+}
+
+func fromJSONSchemas(ts []*jsonschema.Type) []*Schema {
+	if len(ts) == 0 {
+		return nil
+	}
+
+	ret := make([]*Schema, 0, len(ts))
+	for _, t := range ts {
+		ret = append(ret, fromJSONSchema(t))
+	}
+	return ret
+}
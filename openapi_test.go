@@ -0,0 +1,118 @@
+package vertex_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/EverythingMe/vertex"
+	"github.com/EverythingMe/vertex/middleware"
+	"github.com/EverythingMe/vertex/openapi"
+)
+
+type openAPIUser struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestOpenAPI(t *testing.T) {
+
+	a := &vertex.API{
+		Name:          "testung",
+		Version:       "1.0",
+		Doc:           "Our fancy testung API",
+		Title:         "Testung API!",
+		Middleware:    middleware.DefaultMiddleware,
+		Renderer:      vertex.JSONRenderer{},
+		AllowInsecure: true,
+		Routes: vertex.Routes{
+			{
+				Path:        "/user/{id}",
+				Description: "Get User Info by id or name",
+				Handler:     UserHandler{},
+				Methods:     vertex.GET,
+				Returns:     openAPIUser{},
+			},
+			{
+				Path:        "/user/{id}/rename",
+				Description: "Rename a user",
+				Handler:     UserHandler{},
+				Methods:     vertex.POST,
+				Returns:     openAPIUser{},
+			},
+			{
+				Path:        "/user/{id}/friends",
+				Description: "Get or set a user's friends",
+				Handler:     UserHandler{},
+				Methods:     vertex.GET | vertex.POST,
+				Returns:     openAPIUser{},
+			},
+		},
+	}
+
+	srv := vertex.NewServer(":9947")
+	srv.AddAPI(a)
+
+	s := httptest.NewServer(srv.Handler())
+	defer s.Close()
+
+	res, err := http.Get(fmt.Sprintf("%s%s", s.URL, a.FullPath("/openapi.json")))
+	if err != nil {
+		t.Fatalf("Could not get openapi document: %s", err)
+	}
+	defer res.Body.Close()
+
+	var doc openapi.Document
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		t.Fatalf("Could not decode openapi document: %s", err)
+	}
+
+	if doc.OpenAPI != openapi.Version {
+		t.Errorf("Wrong openapi version: %s", doc.OpenAPI)
+	}
+
+	if len(doc.Servers) != 2 || doc.Servers[0].URL != fmt.Sprintf("http://%s%s", s.Listener.Addr(), a.FullPath("")) {
+		t.Errorf("Wrong servers: %#v", doc.Servers)
+	}
+
+	get := doc.Paths["/user/{id}"]["get"]
+	if get == nil {
+		t.Fatalf("GET /user/{id} not described: %#v", doc.Paths)
+	}
+
+	if len(get.Parameters) == 0 || get.Parameters[0].In != "path" || !get.Parameters[0].Required {
+		t.Errorf("Wrong path param: %#v", get.Parameters)
+	}
+
+	// the returned type is a reference to the components
+	schema := get.Responses["200"].Content[a.Renderer.ContentTypes()[0]].Schema
+	if schema == nil || schema.Ref != openapi.SchemaRefPrefix+"openAPIUser" {
+		t.Errorf("Response schema is not a component reference: %#v", schema)
+	}
+	if def := doc.Components.Schemas["openAPIUser"]; def == nil || def.Properties["name"] == nil {
+		t.Errorf("Response schema not in components: %#v", doc.Components.Schemas)
+	}
+
+	// query params of POST requests are a form body
+	post := doc.Paths["/user/{id}/rename"]["post"]
+	if post == nil || post.RequestBody == nil {
+		t.Fatalf("POST /user/{id}/rename has no request body: %#v", post)
+	}
+	for _, ct := range []string{openapi.Form, openapi.Multipart} {
+		body := post.RequestBody.Content[ct].Schema
+		if body == nil || body.Properties["name"] == nil || body.Properties["name"].MaxLength != 100 {
+			t.Errorf("Wrong %s body: %#v", ct, body)
+		}
+	}
+
+	// only the methods the router serves are described
+	friends := doc.Paths["/user/{id}/friends"]
+	if friends["get"] == nil || friends["post"] == nil {
+		t.Errorf("GET|POST route is missing methods: %#v", friends)
+	}
+	if len(friends) != 2 {
+		t.Errorf("GET|POST route has other methods: %#v", friends)
+	}
+}
@@ -0,0 +1,79 @@
+package vertex
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// AttrAllowedMethods is the request attribute holding the methods ([]string) allowed on the path of a preflight
+// request, so CORS middleware can answer with the route's methods
+const AttrAllowedMethods = "allowed_methods"
+
+// IsPreflight tells whether a request is a CORS preflight request sent by a browser before the actual request
+func IsPreflight(r *Request) bool {
+	return r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// PreflightHandler is implemented by middleware that answers CORS preflight requests, e.g. middleware.CORS.
+//
+// Browsers send preflights without credentials, so preflights run only the API and route middleware implementing
+// PreflightHandler, and not the rest of the middleware or the security scheme, which may reject them
+type PreflightHandler interface {
+	HandlePreflight(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error)
+}
+
+// preflightMiddleware returns the preflight handlers of the API and the routes of a path, in the order they run on
+// requests. Handlers shared by several routes, e.g. the same CORS middleware, run once
+func (a *API) preflightMiddleware(routes []Route) []Middleware {
+
+	mws := append([]Middleware{}, a.Middleware...)
+	for _, route := range routes {
+		mws = append(mws, route.Middleware...)
+	}
+
+	ret := []Middleware{}
+	seen := map[interface{}]bool{}
+	for _, mw := range mws {
+		ph, ok := mw.(PreflightHandler)
+		if !ok {
+			continue
+		}
+
+		if reflect.TypeOf(mw).Comparable() {
+			if seen[mw] {
+				continue
+			}
+			seen[mw] = true
+		}
+		ret = append(ret, MiddlewareFunc(ph.HandlePreflight))
+	}
+	return ret
+}
+
+// preflightHandler returns the OPTIONS handler of a route path, shared by the routes on it. It runs the preflight
+// handlers of the API and the routes (see PreflightHandler), so CORS middleware can add its headers.
+// The response is an empty 204 with the path's methods in the Allow header
+func (a *API) preflightHandler(routes []Route, methods []string) func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	allow := strings.Join(append(append([]string{}, methods...), "OPTIONS"), ", ")
+
+	optionsMW := MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+		w.Header().Set("Allow", allow)
+		w.WriteHeader(http.StatusNoContent)
+		return nil, Hijacked
+	})
+
+	// set the allowed methods before any middleware runs
+	methodsMW := MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+		r.SetAttribute(AttrAllowedMethods, methods)
+		return next(w, r)
+	})
+
+	chain := buildChain(append([]Middleware{methodsMW}, a.preflightMiddleware(routes)...)...)
+	chain.append(optionsMW)
+
+	return a.middlewareHandler(chain, nil, routes[0].Renderer)
+}
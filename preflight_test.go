@@ -0,0 +1,95 @@
+package vertex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// preflightMockMW is a middleware handling preflights, like CORS middleware
+type preflightMockMW string
+
+func (m preflightMockMW) Handle(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+	return makeMockMW(string(m)).Handle(w, r, next)
+}
+
+func (m preflightMockMW) HandlePreflight(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+	return m.Handle(w, r, next)
+}
+
+func TestPreflight(t *testing.T) {
+
+	// authentication middleware rejecting requests without credentials
+	auth := MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+		return nil, UnauthorizedError("no credentials")
+	})
+
+	a := &API{
+		Name:                  "preflighttung",
+		Version:               "1.0",
+		Renderer:              JSONRenderer{},
+		AllowInsecure:         true,
+		DefaultSecurityScheme: denySecurity,
+		Middleware:            []Middleware{auth, preflightMockMW("api")},
+		Routes: Routes{
+			{Path: "/user/{id}", Methods: GET, Handler: versionHandler("get"),
+				Middleware: []Middleware{preflightMockMW("get"), makeMockMW("plain")}},
+			{Path: "/user/{id}", Methods: POST, Handler: versionHandler("update"),
+				Middleware: []Middleware{preflightMockMW("post"), preflightMockMW("get")}},
+			{Path: "/ping", Methods: GET, Handler: versionHandler("ping")},
+		},
+	}
+
+	srv := NewServer(":9948")
+	srv.AddAPI(a)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("OPTIONS", a.FullPath(path), nil)
+		r.Header.Set("Origin", "https://example.com")
+		r.Header.Set("Access-Control-Request-Method", "POST")
+		srv.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	// preflights skip the security scheme and the authentication middleware, and run only the preflight handlers
+	// of all the routes on the path, once each
+	w := serve("/user/1")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "GET, POST, OPTIONS", w.Header().Get("Allow"))
+	assert.Equal(t, []string{"api", "get", "post"}, w.Header()["X-Middleware-Message"])
+	assert.Empty(t, w.Body.String())
+
+	w = serve("/ping")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "GET, OPTIONS", w.Header().Get("Allow"))
+
+	w = serve("/nothere")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// the methods are available to the middleware
+	var methods interface{}
+	a.Middleware = []Middleware{preflightFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+		assert.True(t, IsPreflight(r))
+		methods, _ = r.Attribute(AttrAllowedMethods)
+		return next(w, r)
+	})}
+	w = httptest.NewRecorder()
+	r, _ := http.NewRequest("OPTIONS", "/ping", nil)
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	a.preflightHandler(a.Routes[2:], []string{"GET"})(w, r, nil)
+	assert.Equal(t, []string{"GET"}, methods)
+}
+
+// preflightFunc is a middleware func handling preflights. Funcs are not comparable, so they are never deduplicated
+type preflightFunc MiddlewareFunc
+
+func (f preflightFunc) Handle(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+	return f(w, r, next)
+}
+
+func (f preflightFunc) HandlePreflight(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+	return f(w, r, next)
+}
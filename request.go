@@ -1,7 +1,10 @@
 package vertex
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
@@ -149,8 +152,45 @@ func (r *Request) parseSecure() {
 	}
 }
 
-// NewRequest wraps a new http request with a vertex request
+// maxBufferedFormSize is the largest form body that is kept readable after the request's form is parsed
+const maxBufferedFormSize = 10 << 20
+
+// bufferForm reads the body of a form request before it is parsed into the request's form, and returns a func that puts
+// the body back once it is parsed. This lets middleware that need the raw body, e.g. request signature verification,
+// read it after the form was parsed. Bodies larger than maxBufferedFormSize are not put back
+func bufferForm(r *http.Request) (restore func()) {
+
+	restore = func() {}
+
+	ct := r.Header.Get("Content-Type")
+	if r.Body == nil || !(strings.HasPrefix(ct, "application/x-www-form-urlencoded") ||
+		strings.HasPrefix(ct, "multipart/form-data")) {
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBufferedFormSize+1))
+
+	// the form parser gets the entire body either way
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+
+	if err != nil || len(body) > maxBufferedFormSize {
+		return
+	}
+
+	return func() {
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+}
+
+// NewRequest wraps a new http request with a vertex request. Form bodies are still readable after the form is parsed
 func NewRequest(r *http.Request) *Request {
+
+	restore := bufferForm(r)
+	defer restore()
+
 	req := &Request{
 		Request:    r,
 		StartTime:  time.Now(),
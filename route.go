@@ -12,6 +12,24 @@ import (
 // A routing map for an API
 type Routes []Route
 
+// Response describes one of the responses a route can return, for its documentation and generated clients
+type Response struct {
+	// The HTTP status code, or 0 for the default response of the codes not described
+	Code        int
+	Description string
+	// An instance of the response body's type, if it has one
+	Returns interface{}
+	// The headers of the response, mapped to their descriptions, e.g. {"Location": "The new user's URL"}
+	Headers map[string]string
+	// Examples of the response body, by content type
+	Examples map[string]interface{}
+}
+
+// AttrRoutePath is the request attribute holding the path of the route a request matched, relative to the API root
+// and with its {param} placeholders, e.g. /user/{id}. Middleware can use it to tell routes apart regardless of the
+// API's root and version
+const AttrRoutePath = "route_path"
+
 // Route represents a single route (path) in the API and its handler and optional extra middleware
 type Route struct {
 	Path        string
@@ -21,9 +39,22 @@ type Route struct {
 	Security    SecurityScheme
 	Middleware  []Middleware
 	Test        Tester
-	Returns     interface{}
-	Renderer    Renderer
-	requestInfo schema.RequestInfo
+	// Returns is a shorthand for describing the body of the 200 response
+	Returns interface{}
+	// Responses describes the other responses of the route, e.g. 201 with a Location header or 404 with an error body
+	Responses []Response
+	// Deprecated routes are still served, but marked as deprecated in the docs and generated clients, and their
+	// responses carry a Deprecation header
+	Deprecated bool
+	// Since is the first version of a versioned API the route is served in. Empty means all the versions
+	Since string
+	// Until is the last version of a versioned API the route is served in. Empty means all the versions
+	Until string
+	// Tag groups the route in the API's docs. Empty means the first segment of the path. See Routes.Tag
+	Tag            string
+	Renderer       Renderer
+	tagDescription string
+	requestInfo    schema.RequestInfo
 }
 
 func (r *Route) parseInfo(path string) error {
@@ -52,6 +83,14 @@ func (r *Route) parseInfo(path string) error {
 
 	}
 
+	for _, resp := range r.Responses {
+		ri.Responses = append(ri.Responses, schema.ResponseInfo(resp))
+	}
+
+	if r.Tag != "" {
+		ri.Group = r.Tag
+	}
+
 	r.requestInfo = ri
 	return nil
 
@@ -2,10 +2,12 @@ package schema
 
 import (
 	"fmt"
+	"net/http"
 	"reflect"
 	"strconv"
 	"strings"
 
+	"github.com/EverythingMe/vertex/openapi"
 	"github.com/EverythingMe/vertex/swagger"
 
 	"github.com/alecthomas/jsonschema"
@@ -26,6 +28,8 @@ const (
 	PatternTag    = "pattern"
 	InTag         = "in"
 	GlobalTag     = "global"
+	// InjectTag marks fields holding dependencies of the handler, e.g. DB pools, and not request params
+	InjectTag = "inject"
 )
 
 // ParamInfo represents metadata about a requests parameter
@@ -175,6 +179,64 @@ func newParamInfo(field reflect.StructField) ParamInfo {
 	return ret
 }
 
+// ResponseInfo describes one of the responses of a request, by its status code
+type ResponseInfo struct {
+	// The HTTP status code, or 0 for the default response
+	Code        int
+	Description string
+	Returns     interface{}
+	// The response's headers, mapped to their descriptions
+	Headers map[string]string
+	// Examples of the response body, by content type
+	Examples map[string]interface{}
+}
+
+// StatusCode returns the response's status code as swagger keys it, e.g. "404" or "default"
+func (r ResponseInfo) StatusCode() string {
+	if r.Code == 0 {
+		return "default"
+	}
+	return strconv.Itoa(r.Code)
+}
+
+// description returns the response's description, defaulting to its body type or the status text
+func (r ResponseInfo) description() string {
+	switch {
+	case r.Description != "":
+		return r.Description
+	case r.Returns != nil:
+		return reflect.TypeOf(r.Returns).String()
+	case r.Code != 0:
+		return http.StatusText(r.Code)
+	}
+	return ""
+}
+
+// ToOpenAPI converts the response info into an OpenAPI response, with the body in each of the given content types.
+// The body's schema is added to the document's components
+func (r ResponseInfo) ToOpenAPI(doc *openapi.Document, contentTypes []string) openapi.Response {
+
+	ret := openapi.Response{Description: r.description()}
+
+	if r.Returns != nil {
+		schema := doc.SchemaOf(r.Returns)
+
+		ret.Content = make(map[string]openapi.MediaType, len(contentTypes))
+		for _, ct := range contentTypes {
+			ret.Content[ct] = openapi.MediaType{Schema: schema, Example: r.Examples[ct]}
+		}
+	}
+
+	if len(r.Headers) > 0 {
+		ret.Headers = make(map[string]openapi.Header, len(r.Headers))
+		for name, desc := range r.Headers {
+			ret.Headers[name] = openapi.Header{Description: desc, Schema: &openapi.Schema{Type: string(swagger.String)}}
+		}
+	}
+
+	return ret
+}
+
 // RequestInfo represents a single request's descriptor
 type RequestInfo struct {
 	Path        string
@@ -182,6 +244,42 @@ type RequestInfo struct {
 	Group       string
 	Returns     interface{}
 	Params      []ParamInfo
+	Responses   []ResponseInfo
+}
+
+// AllResponses returns the responses of the request. Returns is a shorthand for the 200 response, so it is added as
+// one unless a 200 response is described explicitly
+func (r RequestInfo) AllResponses() []ResponseInfo {
+
+	for _, resp := range r.Responses {
+		if resp.Code == http.StatusOK {
+			return r.Responses
+		}
+	}
+
+	return append([]ResponseInfo{{Code: http.StatusOK, Returns: r.Returns}}, r.Responses...)
+}
+
+// ToSwagger converts the response info into a swagger response
+func (r ResponseInfo) ToSwagger() swagger.Response {
+
+	ret := swagger.Response{
+		Description: r.description(),
+		Examples:    r.Examples,
+	}
+
+	if r.Returns != nil {
+		ret.Schema = swagger.Schema(jsonschema.Reflect(r.Returns))
+	}
+
+	if len(r.Headers) > 0 {
+		ret.Headers = make(map[string]swagger.Header, len(r.Headers))
+		for name, desc := range r.Headers {
+			ret.Headers[name] = swagger.Header{Description: desc, Type: swagger.String}
+		}
+	}
+
+	return ret
 }
 
 func (r RequestInfo) ToSwagger() swagger.Method {
@@ -201,23 +299,18 @@ func (r RequestInfo) ToSwagger() swagger.Method {
 		}
 	}
 
-	if r.Returns != nil {
-
-		s := jsonschema.Reflect(r.Returns)
-
-		ret.Responses["default"] = swagger.Response{
-			Description: reflect.TypeOf(r.Returns).String(),
-			Schema:      swagger.Schema(s),
-		}
-
-	} else {
-		ret.Responses["default"] = swagger.Response{"", jsonschema.Reflect("")}
-
+	for _, resp := range r.AllResponses() {
+		ret.Responses[resp.StatusCode()] = resp.ToSwagger()
 	}
 
 	return ret
 }
 
+// IsInjected tells whether a handler field holds a dependency injected into the handler, rather than a request param
+func IsInjected(field reflect.StructField) bool {
+	return boolTag(field, InjectTag, false)
+}
+
 // recrusively describe a struct's field using our custom struct tags.
 // This is recursive to allow embedding
 func extractParams(T reflect.Type) (ret []ParamInfo) {
@@ -227,7 +320,7 @@ func extractParams(T reflect.Type) (ret []ParamInfo) {
 	for i := 0; i < T.NumField(); i++ {
 
 		field := T.FieldByIndex([]int{i})
-		if field.Name == "_" {
+		if field.Name == "_" || IsInjected(field) {
 			continue
 		}
 
@@ -277,7 +370,7 @@ func NewRequestInfo(T reflect.Type, pth string, description string, returnValue
 	for i := 0; i < T.NumField(); i++ {
 
 		field := T.FieldByIndex([]int{i})
-		if field.Name == "_" {
+		if field.Name == "_" || IsInjected(field) {
 			continue
 		}
 
@@ -319,3 +412,137 @@ func (p ParamInfo) ToSwagger() swagger.Param {
 	ret.Type, ret.Items = swagger.TypeOf(p.Type, swagger.String)
 	return ret
 }
+
+// openAPISchema describes the param's value as a JSON schema
+func (p ParamInfo) openAPISchema() *openapi.Schema {
+
+	tp, items := swagger.TypeOf(p.Type, swagger.String)
+
+	ret := &openapi.Schema{
+		Type:      string(tp),
+		Format:    p.Format,
+		MinLength: p.MinLength,
+		MaxLength: p.MaxLength,
+		Pattern:   p.Pattern,
+	}
+
+	if items != "" {
+		ret.Items = &openapi.Schema{Type: string(items)}
+	}
+	if p.HasDefault {
+		ret.Default = p.Default
+	}
+	if p.HasMin {
+		min := p.Min
+		ret.Minimum = &min
+	}
+	if p.HasMax {
+		max := p.Max
+		ret.Maximum = &max
+	}
+	for _, o := range p.Options {
+		ret.Enum = append(ret.Enum, o)
+	}
+
+	return ret
+}
+
+// ToOpenAPI converts the paramInfo into an OpenAPI parameter
+func (p ParamInfo) ToOpenAPI() openapi.Parameter {
+	return openapi.Parameter{
+		Name:        p.Name,
+		In:          p.In,
+		Description: p.Description,
+		// path params are always required
+		Required: p.Required || p.In == "path",
+		Schema:   p.openAPISchema(),
+	}
+}
+
+// ToOpenAPI converts the request info into an OpenAPI operation of the given method.
+//
+// Path and header params are described as parameters. Params in the body are described as a JSON request body, and
+// query params of POST and PUT requests are described as form and multipart bodies, since we read them from both.
+// Response schemas are added to the document's components. Global params are added to the components too, and
+// referenced by the operation
+func (r RequestInfo) ToOpenAPI(doc *openapi.Document, method string, contentTypes []string) *openapi.Operation {
+
+	ret := &openapi.Operation{
+		Description: r.Description,
+		Responses:   map[string]openapi.Response{},
+		Tags:        []string{strings.Title(r.Group)},
+	}
+
+	hasBody := method == "POST" || method == "PUT"
+
+	form := &openapi.Schema{Type: "object", Properties: map[string]*openapi.Schema{}}
+	var bodyParams []ParamInfo
+
+	for _, p := range r.Params {
+		if p.Hidden {
+			continue
+		}
+
+		switch {
+		case p.In == "body":
+			bodyParams = append(bodyParams, p)
+
+		case hasBody && p.In == "query" && !p.Global:
+			s := p.openAPISchema()
+			s.Description = p.Description
+			form.Properties[p.Name] = s
+			if p.Required {
+				form.Required = append(form.Required, p.Name)
+			}
+
+		case p.Global:
+			doc.Components.Parameters[p.Name] = p.ToOpenAPI()
+			ret.Parameters = append(ret.Parameters, openapi.Parameter{Ref: openapi.ParameterRefPrefix + p.Name})
+
+		default:
+			ret.Parameters = append(ret.Parameters, p.ToOpenAPI())
+		}
+	}
+
+	switch {
+	case len(bodyParams) == 1:
+		// a single body param is the entire body
+		ret.RequestBody = &openapi.RequestBody{
+			Description: bodyParams[0].Description,
+			Required:    bodyParams[0].Required,
+			Content:     map[string]openapi.MediaType{openapi.JSON: {Schema: doc.SchemaOfType(bodyParams[0].Type)}},
+		}
+	case len(bodyParams) > 1:
+		// several body params are the properties of a body object
+		body := &openapi.Schema{Type: "object", Properties: map[string]*openapi.Schema{}}
+		for _, p := range bodyParams {
+			body.Properties[p.Name] = doc.SchemaOfType(p.Type)
+			if p.Required {
+				body.Required = append(body.Required, p.Name)
+			}
+		}
+		ret.RequestBody = &openapi.RequestBody{
+			Required: len(body.Required) > 0,
+			Content:  map[string]openapi.MediaType{openapi.JSON: {Schema: body}},
+		}
+	case len(form.Properties) > 0:
+		ret.RequestBody = &openapi.RequestBody{
+			Required: len(form.Required) > 0,
+			Content: map[string]openapi.MediaType{
+				openapi.Form:      {Schema: form},
+				openapi.Multipart: {Schema: form},
+			},
+		}
+	}
+
+	for _, resp := range r.AllResponses() {
+		ret.Responses[resp.StatusCode()] = resp.ToOpenAPI(doc, contentTypes)
+	}
+
+	// errors are rendered like any other response, so there is always some error response
+	if _, found := ret.Responses["default"]; !found {
+		ret.Responses["default"] = openapi.Response{Description: "Error"}
+	}
+
+	return ret
+}
@@ -122,3 +122,49 @@ func TestRequestInfo(t *testing.T) {
 
 	}
 }
+
+type mockUser struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestResponses(t *testing.T) {
+
+	ri, err := NewRequestInfo(reflect.TypeOf(MockHandler{}), "/user", "create a user", mockUser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Returns alone is the 200 response
+	sw := ri.ToSwagger()
+	if len(sw.Responses) != 1 || sw.Responses["200"].Schema == nil || sw.Responses["200"].Description != "schema.mockUser" {
+		t.Errorf("Wrong responses from Returns: %#v", sw.Responses)
+	}
+
+	ri.Responses = []ResponseInfo{
+		{Code: 201, Returns: mockUser{}, Headers: map[string]string{"Location": "The new user's url"},
+			Examples: map[string]interface{}{"text/json": mockUser{Id: "1", Name: "foo"}}},
+		{Code: 404, Description: "No such user"},
+		{Code: 409},
+	}
+
+	sw = ri.ToSwagger()
+	if len(sw.Responses) != 4 {
+		t.Fatalf("Wrong responses: %#v", sw.Responses)
+	}
+	if r := sw.Responses["201"]; r.Schema == nil || r.Headers["Location"].Type != swagger.String || r.Examples["text/json"] == nil {
+		t.Errorf("Wrong 201 response: %#v", r)
+	}
+	if r := sw.Responses["404"]; r.Schema != nil || r.Description != "No such user" {
+		t.Errorf("Wrong 404 response: %#v", r)
+	}
+	if r := sw.Responses["409"]; r.Description != "Conflict" {
+		t.Errorf("Wrong 409 response: %#v", r)
+	}
+
+	// an explicit 200 response replaces Returns
+	ri.Responses = []ResponseInfo{{Code: 200, Description: "the user"}}
+	if rs := ri.AllResponses(); len(rs) != 1 || rs[0].Description != "the user" {
+		t.Errorf("Wrong responses with explicit 200: %#v", rs)
+	}
+}
@@ -1,26 +1,47 @@
 package vertex
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
-	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dvirsky/go-pylog/logging"
-	"github.com/hydrogen18/stoppableListener"
 	"github.com/julienschmidt/httprouter"
 )
 
 // Server represents a multi-API http server with a single router
 type Server struct {
-	addr     string
-	apis     []*API
-	router   *httprouter.Router
-	listener net.Listener
-	wg       sync.WaitGroup
+	addr   string
+	apis   []*API
+	router *httprouter.Router
+	srv    *http.Server
+	wg     sync.WaitGroup
+	mutex  sync.Mutex
+	ready  int32
+
+	certs    *certReloader
+	redirect *http.Server
+
+	health      *healthMonitor
+	healthMutex sync.Mutex
+
+	admin           *http.Server
+	adminMiddleware []Middleware
+
+	extraListeners []listenerSpec
+	listeners      []net.Listener
+
+	middleware              []ServerMiddleware
+	panicHandler            func(http.ResponseWriter, *http.Request, interface{})
+	notFoundHandler         http.Handler
+	methodNotAllowedHandler http.Handler
+
+	versioned []versionedAPI
 }
 
 type builderFunc func() *API
@@ -35,6 +56,11 @@ var apiBuilders = map[string]builderFunc{}
 //
 // Optionally, you can pass a pointer to a config struct, or nil if you don't need to. This way, we can read the config struct's values
 // from a unified config file BEFORE we call the builder, so the builder can use values in the config struct.
+//
+// NOTE: the config struct is never updated after startup. If the config file is reloaded (see ReloadConfigs), the
+// reloaded values are in a new struct returned by APIConfig(name), and the struct passed here keeps the startup values.
+// Code that should follow config reloads must call APIConfig(name) whenever it needs a value, instead of keeping the
+// struct or the values read by the builder.
 func Register(name string, builder func() *API, config interface{}) {
 	//logging.Info("Adding api builder %s", name)
 	apiBuilders[name] = builderFunc(builder)
@@ -46,29 +72,41 @@ func Register(name string, builder func() *API, config interface{}) {
 
 // NewServer creates a new blank server to add APIs to
 func NewServer(addr string) *Server {
-	return &Server{
+	s := &Server{
 		addr:   addr,
 		apis:   make([]*API, 0),
 		router: httprouter.New(),
 	}
+
+	s.router.PanicHandler = s.handlePanic
+	s.router.NotFound = http.HandlerFunc(s.handleNotFound)
+	s.router.MethodNotAllowed = http.HandlerFunc(s.handleMethodNotAllowed)
+	s.registerHealth()
+	return s
 }
 
-// AddAPI adds an API to the server manually. It's preferred to use Register in an init() function
+// AddAPI adds an API to the server manually. It's preferred to use Register in an init() function.
+// APIs with Versions are added in each of their versions
 func (s *Server) AddAPI(a *API) {
-	a.configure(s.router)
-
-	s.router.PanicHandler = func(w http.ResponseWriter, r *http.Request, v interface{}) {
-
-		code, msg := httpError(NewErrorf("Unhandled panic: %s\n%s", v, string(debug.Stack())))
-		http.Error(w, msg, code)
+	if len(a.Versions) > 0 {
+		s.addVersionedAPI(a)
+		return
 	}
 
+	a.configure(s.router)
 	s.apis = append(s.apis, a)
 }
 
-// Handler returns the underlying router, mainly for testing
+// Handler returns the server's router, wrapped with the server middleware
 func (s *Server) Handler() http.Handler {
-	return s.router
+
+	var ret http.Handler = s.router
+
+	// the first middleware added is the outermost
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		ret = s.middleware[i](ret)
+	}
+	return ret
 }
 
 // InitAPIs initializes and adds all the APIs registered from API builders
@@ -78,50 +116,248 @@ func (s *Server) InitAPIs() {
 	}
 }
 
-// Run runs the server if it has any APIs registered on it
+// Run runs the server if it has any APIs registered on it.
+//
+// The server listens on its address, on the addresses added with AddListener, and on the listeners in the config.
+// Before listening, Run calls the OnStart hooks of all the APIs. It blocks until the server is shut down
 func (s *Server) Run() (err error) {
 
 	if len(s.apis) == 0 {
 		return errors.New("No APIs defined for server")
 	}
 
+	if err = s.checkDefaultCredentials(); err != nil {
+		return err
+	}
+
 	// Server the console swagger UI
-	s.router.ServeFiles("/console/*filepath", http.Dir(Config.Server.ConsoleFilesPath))
+	s.router.ServeFiles("/console/*filepath", consoleFiles())
+
+	tlsConfig, certs, err := newTLSConfig(Config.Server)
+	if err != nil {
+		return err
+	}
+
+	if err = s.start(); err != nil {
+		return err
+	}
 
-	// Start a stoppable listener
-	var l net.Listener
+	// run the health checks once before we start, so probes have results right away
+	s.startHealth()
 
-	if l, err = net.Listen("tcp", s.addr); err != nil {
-		return fmt.Errorf("Could not listen in server: %s", err)
+	listeners, err := s.listenAll(tlsConfig)
+	if err != nil {
+		s.stopAPIs(len(s.apis))
+		s.stopHealth()
+		return err
 	}
 
-	if s.listener, err = stoppableListener.New(l); err != nil {
-		return fmt.Errorf("Could not start stoppable listener in server: %s", err)
+	closeAll := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+
+	if Config.Server.AdminAddr != "" {
+		if err = s.runAdmin(Config.Server.AdminAddr); err != nil {
+			closeAll()
+			s.stopAPIs(len(s.apis))
+			s.stopHealth()
+			return err
+		}
 	}
 
-	logging.Info("Starting server on %s", s.listener.Addr().String())
+	if certs != nil {
+		s.certs = certs
+		go certs.watch(time.Duration(Config.Server.TLSReloadInterval) * time.Second)
+	}
 
-	s.wg.Add(1)
-	defer func() {
-		s.wg.Done()
-		// don't return an error on server stopped
-		if err == stoppableListener.StoppedError {
-			err = nil
+	if tlsConfig != nil {
+		if Config.Server.HTTPRedirectAddr != "" {
+			if err = s.runRedirect(Config.Server.HTTPRedirectAddr); err != nil {
+				closeAll()
+				s.stopAPIs(len(s.apis))
+				s.stopHealth()
+				return err
+			}
 		}
-	}()
+	}
 
-	srv := http.Server{
-		Handler:      s.router,
+	s.mutex.Lock()
+	s.listeners = listeners
+	s.srv = &http.Server{
+		Handler:      s.Handler(),
 		ReadTimeout:  time.Duration(Config.Server.ClientTimeout) * time.Second,
 		WriteTimeout: time.Duration(Config.Server.ClientTimeout) * time.Second, // maximum duration before timing out write of the response
+		TLSConfig:    tlsConfig,
+		ConnContext:  markLocalConn,
+	}
+	srv := s.srv
+	s.wg.Add(1)
+	s.mutex.Unlock()
+
+	defer s.wg.Done()
+
+	logging.Info("Starting server on %d listeners", len(listeners))
+	atomic.StoreInt32(&s.ready, 1)
+
+	// serve all the listeners. If one of them fails, we shut the server down rather than keep serving on some of the
+	// addresses, and return the error
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			errs <- srv.Serve(l)
+		}(l)
+	}
+
+	for range listeners {
+		serveErr := <-errs
+		if serveErr == http.ErrServerClosed || err != nil {
+			continue
+		}
+
+		err = serveErr
+		logging.Error("Error serving requests, shutting down: %s", err)
+		// Shutdown waits for Run to return, so it can't block it
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(Config.Server.ShutdownTimeout)*time.Second)
+			defer cancel()
+			if err := s.Shutdown(ctx); err != nil {
+				logging.Error("Error shutting down server: %s", err)
+			}
+		}()
 	}
-	return srv.Serve(s.listener)
+	return err
 
 }
 
-// Stop waits up to a second and closes the server
-func (s *Server) Stop() {
+// start calls the OnStart hooks of all the APIs. If one of them fails, the APIs already started are stopped
+func (s *Server) start() error {
+
+	for i, a := range s.apis {
+		if a.OnStart == nil {
+			continue
+		}
+
+		logging.Info("Starting API %s", a.Name)
+		if err := a.OnStart(); err != nil {
+			s.stopAPIs(i)
+			return fmt.Errorf("Could not start API %s: %s", a.Name, err)
+		}
+	}
+	return nil
+}
+
+// stopAPIs calls the OnStop hooks of the first n APIs, in reverse order of starting them
+func (s *Server) stopAPIs(n int) error {
+
+	var ret error
+	for i := n - 1; i >= 0; i-- {
+		a := s.apis[i]
+		if a.OnStop == nil {
+			continue
+		}
+
+		logging.Info("Stopping API %s", a.Name)
+		if err := a.OnStop(); err != nil {
+			logging.Error("Error stopping API %s: %s", a.Name, err)
+			if ret == nil {
+				ret = fmt.Errorf("Could not stop API %s: %s", a.Name, err)
+			}
+		}
+	}
+	return ret
+}
+
+// runRedirect starts a plain HTTP listener that redirects all requests to the HTTPS server
+func (s *Server) runRedirect(addr string) error {
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("Could not listen for HTTP redirects: %s", err)
+	}
+
+	logging.Info("Redirecting HTTP requests on %s to HTTPS", l.Addr().String())
+	s.redirect = &http.Server{
+		Handler:      httpsRedirectHandler(Config.Server.HTTPSPort),
+		ReadTimeout:  time.Duration(Config.Server.ClientTimeout) * time.Second,
+		WriteTimeout: time.Duration(Config.Server.ClientTimeout) * time.Second,
+	}
+
+	go func() {
+		if err := s.redirect.Serve(l); err != nil && err != http.ErrServerClosed {
+			logging.Error("HTTP redirect server stopped: %s", err)
+		}
+	}()
+	return nil
+}
+
+// Ready returns true if the server is running and accepting requests, and false before it started or once it
+// started shutting down
+func (s *Server) Ready() bool {
+	return atomic.LoadInt32(&s.ready) == 1
+}
+
+// Shutdown gracefully stops the server. It first marks the server as not ready, and waits for the configured
+// shutdown delay so load balancers stop sending it new requests. It then stops listening and waits for in-flight
+// requests to finish, until ctx is done. Finally it calls the OnStop hooks of all the APIs.
+//
+// If ctx expires before all requests are done, the remaining connections are closed and ctx's error is returned
+func (s *Server) Shutdown(ctx context.Context) error {
+
+	s.mutex.Lock()
+	srv := s.srv
+	s.srv = nil
+	s.mutex.Unlock()
+
+	if srv == nil {
+		return errors.New("Server is not running")
+	}
+
+	logging.Info("Shutting down server")
+	atomic.StoreInt32(&s.ready, 0)
+
+	if delay := time.Duration(Config.Server.ShutdownDelay) * time.Second; delay > 0 {
+		logging.Info("Waiting %s for load balancers to notice we're not ready", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+
+	if s.redirect != nil {
+		s.redirect.Shutdown(ctx)
+	}
+	if s.admin != nil {
+		s.admin.Shutdown(ctx)
+	}
+	if s.certs != nil {
+		s.certs.Close()
+	}
 
-	s.listener.(*stoppableListener.StoppableListener).Stop()
+	err := srv.Shutdown(ctx)
+	s.stopHealth()
+	if err != nil {
+		logging.Warning("Could not drain all connections: %s", err)
+		srv.Close()
+	}
 	s.wg.Wait()
+
+	if stopErr := s.stopAPIs(len(s.apis)); err == nil {
+		err = stopErr
+	}
+
+	logging.Info("Server stopped")
+	return err
+}
+
+// Stop waits up to a second for in-flight requests and closes the server
+func (s *Server) Stop() {
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		logging.Error("Error stopping server: %s", err)
+	}
 }
@@ -0,0 +1,107 @@
+package vertex
+
+import (
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// ServerMiddleware wraps the entire server, including the console, swagger, test and health endpoints, and requests
+// that do not match any route. Unlike API middleware, it works on plain http handlers, e.g. for access logs or
+// compression
+type ServerMiddleware func(http.Handler) http.Handler
+
+// Use adds middleware wrapping the entire server. The first middleware added is the outermost one
+func (s *Server) Use(mw ...ServerMiddleware) *Server {
+	s.middleware = append(s.middleware, mw...)
+	return s
+}
+
+// PanicHandler sets the handler of panics in request handlers. By default, panics are logged and rendered as
+// internal errors by the renderer of the API the request was for
+func (s *Server) PanicHandler(h func(w http.ResponseWriter, r *http.Request, v interface{})) *Server {
+	s.panicHandler = h
+	return s
+}
+
+// NotFoundHandler sets the handler of requests that do not match any route. By default, they are rendered as
+// not found errors by the renderer of the API whose root the path falls under
+func (s *Server) NotFoundHandler(h http.Handler) *Server {
+	s.notFoundHandler = h
+	return s
+}
+
+// MethodNotAllowedHandler sets the handler of requests to a route that does not support their method.
+// By default, they are rendered by the renderer of the API whose root the path falls under
+func (s *Server) MethodNotAllowedHandler(h http.Handler) *Server {
+	s.methodNotAllowedHandler = h
+	return s
+}
+
+// apiFor returns the API with the longest root the path falls under, or nil if there is none
+func (s *Server) apiFor(path string) *API {
+
+	var ret *API
+	for _, a := range s.apis {
+		root := strings.TrimSuffix(a.root(), "/")
+		if path != root && !strings.HasPrefix(path, root+"/") {
+			continue
+		}
+		if ret == nil || len(root) > len(strings.TrimSuffix(ret.root(), "/")) {
+			ret = a
+		}
+	}
+	return ret
+}
+
+// renderError renders an error for a request that no route handled, with the renderer of the API the path falls
+// under. Requests outside any API get a plain text error
+func (s *Server) renderError(w http.ResponseWriter, r *http.Request, err error) {
+
+	if a := s.apiFor(r.URL.Path); a != nil && a.Renderer != nil {
+		if rerr := a.Renderer.Render(nil, err, w, NewRequest(r)); rerr != nil {
+			logging.Error("Error rendering response: %s", rerr)
+		}
+		return
+	}
+
+	code, msg := httpError(err)
+	http.Error(w, msg, code)
+}
+
+func (s *Server) handlePanic(w http.ResponseWriter, r *http.Request, v interface{}) {
+
+	if s.panicHandler != nil {
+		s.panicHandler(w, r, v)
+		return
+	}
+
+	s.renderError(w, r, NewErrorf("Unhandled panic: %s\n%s", v, string(debug.Stack())))
+}
+
+func (s *Server) handleNotFound(w http.ResponseWriter, r *http.Request) {
+
+	// unversioned paths of versioned APIs don't match any route, so they end up here
+	if s.serveVersioned(w, r) {
+		return
+	}
+
+	if s.notFoundHandler != nil {
+		s.notFoundHandler.ServeHTTP(w, r)
+		return
+	}
+
+	s.renderError(w, r, NotFoundError("No route for %s", r.URL.Path))
+}
+
+func (s *Server) handleMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+
+	if s.methodNotAllowedHandler != nil {
+		s.methodNotAllowedHandler.ServeHTTP(w, r)
+		return
+	}
+
+	s.renderError(w, r, MethodNotAllowedError("Method %s not allowed for %s", r.Method, r.URL.Path))
+}
@@ -0,0 +1,245 @@
+package vertex
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdown(t *testing.T) {
+
+	var events []string
+	release := make(chan struct{})
+
+	api := &API{
+		Name:          "lifecycle",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		OnStart:       func() error { events = append(events, "start"); return nil },
+		OnStop:        func() error { events = append(events, "stop"); return nil },
+		Routes: Routes{
+			{
+				Path:        "/slow",
+				Description: "a slow request",
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					<-release
+					return "done", nil
+				}),
+				Methods: GET,
+			},
+		},
+	}
+
+	s := NewServer("127.0.0.1:9935")
+	s.AddAPI(api)
+	assert.False(t, s.Ready())
+
+	done := make(chan error)
+	go func() {
+		done <- s.Run()
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	assert.True(t, s.Ready())
+	assert.Equal(t, []string{"start"}, events)
+
+	// start a request and shut down while it is in flight
+	resp := make(chan string)
+	go func() {
+		res, err := http.Get("http://127.0.0.1:9935/lifecycle/1.0/slow")
+		if err != nil {
+			resp <- err.Error()
+			return
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		resp <- string(b)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- s.Shutdown(context.Background())
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// readiness fails and new connections are refused while we drain
+	assert.False(t, s.Ready())
+	_, err := http.Get("http://127.0.0.1:9935/lifecycle/1.0/slow")
+	assert.Error(t, err)
+
+	close(release)
+	assert.Contains(t, <-resp, "done")
+	assert.NoError(t, <-shutdown)
+	assert.NoError(t, <-done)
+	assert.Equal(t, []string{"start", "stop"}, events)
+
+	assert.Error(t, s.Shutdown(context.Background()))
+}
+
+func TestShutdownTimeout(t *testing.T) {
+
+	release := make(chan struct{})
+	defer close(release)
+
+	s := NewServer("127.0.0.1:9936")
+	s.AddAPI(&API{
+		Name:          "stuck",
+		Version:       "1.0",
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{
+				Path:        "/stuck",
+				Description: "a stuck request",
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					<-release
+					return nil, nil
+				}),
+				Methods: GET,
+			},
+		},
+	})
+
+	go s.Run()
+	time.Sleep(100 * time.Millisecond)
+	go http.Get("http://127.0.0.1:9936/stuck/1.0/stuck")
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Shutdown(ctx))
+}
+
+func TestStartFailure(t *testing.T) {
+
+	var stopped []string
+	s := NewServer("127.0.0.1:9937")
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		s.AddAPI(&API{
+			Name:     name,
+			Version:  "1.0",
+			Renderer: JSONRenderer{},
+			OnStart: func() error {
+				if name == "c" {
+					return errors.New("no db for you")
+				}
+				return nil
+			},
+			OnStop: func() error { stopped = append(stopped, name); return nil },
+		})
+	}
+
+	// a failing start hook stops the APIs that already started, in reverse order
+	assert.Error(t, s.Run())
+	assert.Equal(t, []string{"b", "a"}, stopped)
+	assert.False(t, s.Ready())
+}
+
+func TestServerMiddleware(t *testing.T) {
+
+	var rendered []error
+	renderer := RenderFunc(func(v interface{}, e error, w http.ResponseWriter, r *Request) error {
+		rendered = append(rendered, e)
+		code, msg := httpError(e)
+		w.Header().Set("X-Rendered-By", "api")
+		http.Error(w, msg, code)
+		return nil
+	}, "text/plain")
+
+	api := &API{
+		Name:          "handlers",
+		Version:       "1.0",
+		Renderer:      renderer,
+		AllowInsecure: true,
+		Routes: Routes{
+			{
+				Path:        "/panic",
+				Description: "a panicking handler",
+				Handler: HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+					panic("oh no")
+				}),
+				Methods: GET,
+			},
+		},
+	}
+
+	s := NewServer("127.0.0.1:0")
+	s.AddAPI(api)
+
+	var calls []string
+	trace := func(name string) ServerMiddleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	s.Use(trace("outer"), trace("inner"))
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, path, nil)
+		s.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	// server middleware wraps everything, including unmatched requests
+	w := do("GET", "/handlers/1.0/nope")
+	assert.Equal(t, []string{"outer", "inner"}, calls)
+
+	// errors under an API's root are rendered by the API's renderer
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "api", w.Header().Get("X-Rendered-By"))
+
+	w = do("POST", "/handlers/1.0/panic")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "api", w.Header().Get("X-Rendered-By"))
+
+	w = do("GET", "/handlers/1.0/panic")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "api", w.Header().Get("X-Rendered-By"))
+	assert.Len(t, rendered, 3)
+
+	// outside of any API we render plain errors
+	w = do("GET", "/handlers/2.0/nope")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "", w.Header().Get("X-Rendered-By"))
+
+	// the handlers can be replaced
+	s.NotFoundHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})).MethodNotAllowedHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+	})).PanicHandler(func(w http.ResponseWriter, r *http.Request, v interface{}) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	assert.Equal(t, http.StatusTeapot, do("GET", "/handlers/1.0/nope").Code)
+	assert.Equal(t, http.StatusConflict, do("POST", "/handlers/1.0/panic").Code)
+	assert.Equal(t, http.StatusBadGateway, do("GET", "/handlers/1.0/panic").Code)
+}
+
+func TestAPIFor(t *testing.T) {
+
+	s := NewServer("127.0.0.1:0")
+	outer := &API{Name: "outer", Root: "/api"}
+	inner := &API{Name: "inner", Root: "/api/v2"}
+	s.apis = []*API{outer, inner}
+
+	assert.Equal(t, outer, s.apiFor("/api"))
+	assert.Equal(t, outer, s.apiFor("/api/v1/foo"))
+	assert.Equal(t, inner, s.apiFor("/api/v2/foo"))
+	assert.Equal(t, outer, s.apiFor("/api/v22"))
+	assert.Nil(t, s.apiFor("/apis"))
+	assert.Nil(t, s.apiFor("/"))
+}
@@ -0,0 +1,165 @@
+// Package signature implements HMAC-SHA256 request signing for server-to-server calls.
+//
+// A signed request carries the caller's key id, a timestamp, a random nonce and a signature in its headers.
+// The signature is computed over a canonical string of the request:
+//
+//	METHOD
+//	/path/of/the/request
+//	sorted=url&encoded=params
+//	hex(sha256(body))
+//	timestamp
+//	nonce
+//
+// The params are the query params, plus the body params of form encoded requests. Form encoded bodies are
+// covered by the params, so their body hash is the hash of an empty body. The signature values may be sent as params
+// instead of headers, and are then left out of the signed params.
+//
+// The package is used both by the server side security scheme in vertex/middleware, and by clients and tests
+// that need to sign requests
+package signature
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The headers carrying the signature data
+const (
+	HeaderKeyId     = "X-Vertex-Key"
+	HeaderTimestamp = "X-Vertex-Timestamp"
+	HeaderNonce     = "X-Vertex-Nonce"
+	HeaderSignature = "X-Vertex-Signature"
+)
+
+// Headers are the names of all the signature headers. Clients that cannot set request headers, e.g. generated
+// clients, may send them as query or form params of the same names. They are not part of the signed params
+var Headers = []string{HeaderKeyId, HeaderTimestamp, HeaderNonce, HeaderSignature}
+
+// Value returns one of the signature values of a request - from its header, or from the param of the same name.
+// The request's form must already be parsed
+func Value(r *http.Request, name string) string {
+	if v := r.Header.Get(name); v != "" {
+		return v
+	}
+	return r.Form.Get(name)
+}
+
+// Algorithm is the name of the signing algorithm, as advertised in the API's swagger security definitions
+const Algorithm = "hmac-sha256"
+
+const formContentType = "application/x-www-form-urlencoded"
+
+// IsForm returns true if the request body is form encoded, and thus signed as params and not as a body
+func IsForm(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), formContentType)
+}
+
+// BodyHash returns the hex encoded sha256 of a request body
+func BodyHash(body []byte) string {
+	h := sha256.Sum256(body)
+	return hex.EncodeToString(h[:])
+}
+
+// CanonicalString builds the string we sign for a request
+func CanonicalString(method, path string, params url.Values, bodyHash, timestamp, nonce string) string {
+	if path == "" {
+		path = "/"
+	}
+	return strings.Join([]string{strings.ToUpper(method), path, params.Encode(), bodyHash, timestamp, nonce}, "\n")
+}
+
+// Compute returns the hex encoded HMAC-SHA256 of a canonical string with a secret
+func Compute(secret, canonical string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(canonical))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Equal compares two signatures in constant time
+func Equal(a, b string) bool {
+	return hmac.Equal([]byte(a), []byte(b))
+}
+
+// ParseTimestamp parses a timestamp header (unix seconds)
+func ParseTimestamp(ts string) (time.Time, error) {
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp '%s'", ts)
+	}
+	return time.Unix(secs, 0), nil
+}
+
+// Signer signs outgoing requests with a key id and its secret
+type Signer struct {
+	KeyId  string
+	Secret string
+}
+
+// NewSigner creates a new signer for a key id and its secret
+func NewSigner(keyId, secret string) *Signer {
+	return &Signer{
+		KeyId:  keyId,
+		Secret: secret,
+	}
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Sign sets the signature headers on a request. Sign should be called after the request's body and content
+// type are set. The body is read and replaced, so the request can still be sent
+func (s *Signer) Sign(r *http.Request) error {
+
+	params := r.URL.Query()
+	bodyHash := BodyHash(nil)
+
+	if r.Body != nil {
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return fmt.Errorf("Could not read request body: %s", err)
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		if IsForm(r) {
+			form, err := url.ParseQuery(string(body))
+			if err != nil {
+				return fmt.Errorf("Could not parse form body: %s", err)
+			}
+			for k, v := range form {
+				params[k] = append(params[k], v...)
+			}
+		} else {
+			bodyHash = BodyHash(body)
+		}
+	}
+
+	nonce, err := newNonce()
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	canonical := CanonicalString(r.Method, r.URL.EscapedPath(), params, bodyHash, timestamp, nonce)
+
+	r.Header.Set(HeaderKeyId, s.KeyId)
+	r.Header.Set(HeaderTimestamp, timestamp)
+	r.Header.Set(HeaderNonce, nonce)
+	r.Header.Set(HeaderSignature, Compute(s.Secret, canonical))
+	return nil
+}
@@ -0,0 +1,43 @@
+package signature
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSign(t *testing.T) {
+
+	r, _ := http.NewRequest("POST", "http://example.com/api/1.0/foo?b=2&a=1", strings.NewReader("c=3&a=0"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	s := NewSigner("key", "secret")
+	assert.NoError(t, s.Sign(r))
+
+	// the body can still be read
+	b, _ := ioutil.ReadAll(r.Body)
+	assert.Equal(t, "c=3&a=0", string(b))
+
+	assert.Equal(t, "key", r.Header.Get(HeaderKeyId))
+	assert.NotEmpty(t, r.Header.Get(HeaderNonce))
+
+	_, err := ParseTimestamp(r.Header.Get(HeaderTimestamp))
+	assert.NoError(t, err)
+
+	canonical := CanonicalString("POST", "/api/1.0/foo", url.Values{"a": {"1", "0"}, "b": {"2"}, "c": {"3"}},
+		BodyHash(nil), r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderNonce))
+	assert.True(t, Equal(Compute("secret", canonical), r.Header.Get(HeaderSignature)))
+	assert.False(t, Equal(Compute("other", canonical), r.Header.Get(HeaderSignature)))
+
+	// nonces are unique per request
+	r2, _ := http.NewRequest("GET", "http://example.com/api/1.0/foo", nil)
+	assert.NoError(t, s.Sign(r2))
+	assert.NotEqual(t, r.Header.Get(HeaderNonce), r2.Header.Get(HeaderNonce))
+
+	_, err = ParseTimestamp("yesterday")
+	assert.Error(t, err)
+}
@@ -0,0 +1,367 @@
+package swagger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/jsonschema"
+)
+
+// DefinitionRefPrefix is the prefix of references to the API's schema definitions
+const DefinitionRefPrefix = "#/definitions/"
+
+// ParamRefPrefix is the prefix of references to the API's global params
+const ParamRefPrefix = "#/parameters/"
+
+// Change is a single difference between two versions of an API description
+type Change struct {
+	// The operation or definition that changed, e.g. "GET /user/{id}"
+	Location string
+	Message  string
+	// Breaking changes may break existing clients of the API
+	Breaking bool
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s", c.Location, c.Message)
+}
+
+// Changes is a list of changes between two versions of an API description
+type Changes []Change
+
+// Breaking returns only the changes that may break existing clients
+func (c Changes) Breaking() Changes {
+	ret := Changes{}
+	for _, ch := range c {
+		if ch.Breaking {
+			ret = append(ret, ch)
+		}
+	}
+	return ret
+}
+
+// NonBreaking returns only the changes that are safe for existing clients, e.g. new paths or optional params
+func (c Changes) NonBreaking() Changes {
+	ret := Changes{}
+	for _, ch := range c {
+		if !ch.Breaking {
+			ret = append(ret, ch)
+		}
+	}
+	return ret
+}
+
+// differ accumulates the changes between two API descriptions
+type differ struct {
+	old, new *API
+	changes  Changes
+}
+
+func (d *differ) add(breaking bool, location, msg string, args ...interface{}) {
+	d.changes = append(d.changes, Change{Location: location, Message: fmt.Sprintf(msg, args...), Breaking: breaking})
+}
+
+// Diff compares two versions of an API description, and returns the changes from the old one to the new one.
+//
+// Removed paths and methods, new required params, narrowed enums and ranges, changed types and removed response fields
+// are breaking. Additions are not
+func Diff(old, new *API) Changes {
+
+	d := &differ{old: old, new: new}
+
+	if old.Basepath != new.Basepath {
+		d.add(true, "API", "base path changed from %s to %s", old.Basepath, new.Basepath)
+	}
+
+	for _, p := range sortedPaths(old.Paths, new.Paths) {
+		oldPath, inOld := old.Paths[p]
+		newPath, inNew := new.Paths[p]
+
+		switch {
+		case !inNew:
+			d.add(true, p, "path removed")
+			continue
+		case !inOld:
+			d.add(false, p, "path added")
+			continue
+		}
+
+		for _, m := range []string{"get", "post", "put", "delete"} {
+			oldMethod, inOld := oldPath[m]
+			newMethod, inNew := newPath[m]
+			loc := fmt.Sprintf("%s %s", strings.ToUpper(m), p)
+
+			switch {
+			case inOld && !inNew:
+				d.add(true, loc, "method removed")
+			case !inOld && inNew:
+				d.add(false, loc, "method added")
+			case inOld && inNew:
+				if !oldMethod.Deprecated && newMethod.Deprecated {
+					d.add(false, loc, "method deprecated")
+				}
+				d.diffParams(loc, oldMethod.Parameters, newMethod.Parameters)
+				d.diffResponses(loc, oldMethod.Responses, newMethod.Responses)
+			}
+		}
+	}
+
+	return d.changes
+}
+
+func sortedPaths(a, b map[string]Path) []string {
+	ret := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		ret = append(ret, k)
+	}
+	for k := range b {
+		if _, found := a[k]; !found {
+			ret = append(ret, k)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// resolveParams resolves references to global params, and maps the params by their location and name
+func resolveParams(api *API, params []Param) map[string]Param {
+	ret := make(map[string]Param, len(params))
+	for _, p := range params {
+		if p.Ref != "" {
+			p = api.Parameters[strings.TrimPrefix(p.Ref, ParamRefPrefix)]
+		}
+		ret[p.In+":"+p.Name] = p
+	}
+	return ret
+}
+
+func (d *differ) diffParams(loc string, oldParams, newParams []Param) {
+
+	olds := resolveParams(d.old, oldParams)
+	news := resolveParams(d.new, newParams)
+
+	keys := make([]string, 0, len(olds)+len(news))
+	for k := range olds {
+		keys = append(keys, k)
+	}
+	for k := range news {
+		if _, found := olds[k]; !found {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		o, inOld := olds[k]
+		n, inNew := news[k]
+
+		switch {
+		case !inNew:
+			// unknown params are ignored, so clients still sending it are fine
+			d.add(false, loc, "param %s removed", o.Name)
+		case !inOld:
+			if n.Required {
+				d.add(true, loc, "required param %s added", n.Name)
+			} else {
+				d.add(false, loc, "optional param %s added", n.Name)
+			}
+		default:
+			d.diffParam(loc, o, n)
+		}
+	}
+}
+
+func (d *differ) diffParam(loc string, o, n Param) {
+
+	name := n.Name
+
+	if !o.Required && n.Required {
+		d.add(true, loc, "param %s is now required", name)
+	} else if o.Required && !n.Required {
+		d.add(false, loc, "param %s is now optional", name)
+	}
+
+	if o.Type != n.Type || o.Items != n.Items || o.Format != n.Format {
+		d.add(true, loc, "param %s changed type from %s to %s", name, typeString(o.Type, o.Items, o.Format),
+			typeString(n.Type, n.Items, n.Format))
+	}
+
+	if len(n.Enum) > 0 {
+		if removed := missing(o.Enum, n.Enum); len(o.Enum) == 0 || len(removed) > 0 {
+			d.add(true, loc, "param %s accepts fewer values: %s", name, strings.Join(n.Enum, ", "))
+		} else if added := missing(n.Enum, o.Enum); len(added) > 0 {
+			d.add(false, loc, "param %s accepts new values: %s", name, strings.Join(added, ", "))
+		}
+	} else if len(o.Enum) > 0 {
+		d.add(false, loc, "param %s accepts any value", name)
+	}
+
+	// bounds are omitted from the json when they are zero, so a zero bound is no bound
+	oHasMin, nHasMin := o.HasMin || o.Min != 0, n.HasMin || n.Min != 0
+	oHasMax, nHasMax := o.HasMax || o.Max != 0, n.HasMax || n.Max != 0
+
+	if nHasMin && (!oHasMin || n.Min > o.Min) {
+		d.add(true, loc, "param %s minimum raised to %v", name, n.Min)
+	} else if oHasMin && (!nHasMin || n.Min < o.Min) {
+		d.add(false, loc, "param %s minimum lowered", name)
+	}
+
+	if nHasMax && (!oHasMax || n.Max < o.Max) {
+		d.add(true, loc, "param %s maximum lowered to %v", name, n.Max)
+	} else if oHasMax && (!nHasMax || n.Max > o.Max) {
+		d.add(false, loc, "param %s maximum raised", name)
+	}
+
+	if n.MinLength > o.MinLength {
+		d.add(true, loc, "param %s minimum length raised to %d", name, n.MinLength)
+	}
+	if n.MaxLength != 0 && (o.MaxLength == 0 || n.MaxLength < o.MaxLength) {
+		d.add(true, loc, "param %s maximum length lowered to %d", name, n.MaxLength)
+	}
+
+	if n.Pattern != o.Pattern && n.Pattern != "" {
+		d.add(true, loc, "param %s pattern changed to %s", name, n.Pattern)
+	}
+}
+
+func typeString(tp, items Type, format string) string {
+	ret := string(tp)
+	if items != "" {
+		ret += " of " + string(items)
+	}
+	if format != "" {
+		ret += " (" + format + ")"
+	}
+	return ret
+}
+
+// missing returns the values of a that are not in b
+func missing(a, b []string) []string {
+	ret := []string{}
+	for _, v := range a {
+		found := false
+		for _, v2 := range b {
+			if v == v2 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+func (d *differ) diffResponses(loc string, olds, news map[string]Response) {
+
+	codes := make([]string, 0, len(olds))
+	for code := range olds {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	for _, code := range codes {
+		o := olds[code]
+		n, found := news[code]
+		if !found {
+			d.add(true, loc, "response %s removed", code)
+			continue
+		}
+
+		var ot, nt *jsonschema.Type
+		if o.Schema != nil {
+			ot = o.Schema.Type
+		}
+		if n.Schema != nil {
+			nt = n.Schema.Type
+		}
+		d.diffSchema(fmt.Sprintf("%s response %s", loc, code), "", ot, nt, map[string]bool{})
+	}
+}
+
+// resolve follows a reference to the API's definitions
+func resolve(api *API, t *jsonschema.Type) *jsonschema.Type {
+	if t == nil || t.Ref == "" {
+		return t
+	}
+
+	if def, found := api.Definitions[strings.TrimPrefix(t.Ref, DefinitionRefPrefix)]; found && def != nil {
+		return def.Type
+	}
+	return nil
+}
+
+func refOf(t *jsonschema.Type) string {
+	if t == nil {
+		return ""
+	}
+	return t.Ref
+}
+
+// diffSchema compares the schemas of a response, or a field of it. Removed fields and changed types are breaking,
+// since clients read them
+func (d *differ) diffSchema(loc, field string, o, n *jsonschema.Type, seen map[string]bool) {
+
+	// don't recurse forever into recursive types. A pair of definitions is compared once, at the first field using it,
+	// since the field path keeps growing through a recursive type
+	if o != nil && o.Ref != "" {
+		key := o.Ref + " " + refOf(n)
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+	}
+
+	o, n = resolve(d.old, o), resolve(d.new, n)
+
+	name := field
+	if name == "" {
+		name = "body"
+	}
+
+	switch {
+	case o == nil:
+		return
+	case n == nil:
+		d.add(true, loc, "%s removed", name)
+		return
+	case o.Type != n.Type:
+		d.add(true, loc, "%s changed type from %s to %s", name, o.Type, n.Type)
+		return
+	}
+
+	if o.Items != nil || n.Items != nil {
+		d.diffSchema(loc, name+"[]", o.Items, n.Items, seen)
+	}
+
+	props := make([]string, 0, len(o.Properties)+len(n.Properties))
+	for k := range o.Properties {
+		props = append(props, k)
+	}
+	for k := range n.Properties {
+		if _, found := o.Properties[k]; !found {
+			props = append(props, k)
+		}
+	}
+	sort.Strings(props)
+
+	for _, k := range props {
+		path := k
+		if field != "" {
+			path = field + "." + k
+		}
+
+		op, inOld := o.Properties[k]
+		np, inNew := n.Properties[k]
+		switch {
+		case !inNew:
+			d.add(true, loc, "field %s removed", path)
+		case !inOld:
+			d.add(false, loc, "field %s added", path)
+		default:
+			d.diffSchema(loc, path, op, np, seen)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package swagger
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/jsonschema"
+)
+
+func diffAPI(params []Param, user *jsonschema.Type) *API {
+	a := NewAPI("localhost", "test", "", "1.0", "/test/1.0", []string{"https"})
+	a.Definitions["User"] = Schema(&jsonschema.Schema{Type: user})
+	a.Parameters["auth"] = Param{Name: "auth", In: "query", Type: String}
+
+	a.AddPath("/user/{id}")["get"] = Method{
+		Parameters: append([]Param{{Ref: ParamRefPrefix + "auth"}}, params...),
+		Responses: map[string]Response{
+			"200": {Schema: Schema(&jsonschema.Schema{Type: &jsonschema.Type{Ref: DefinitionRefPrefix + "User"}})},
+		},
+	}
+	a.AddPath("/ping")["get"] = Method{Responses: map[string]Response{}}
+	return a
+}
+
+func changeStrings(cs Changes) []string {
+	ret := []string{}
+	for _, c := range cs {
+		ret = append(ret, c.String())
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func TestDiff(t *testing.T) {
+
+	old := diffAPI([]Param{
+		{Name: "id", In: "path", Type: String, Required: true},
+		{Name: "kind", In: "query", Type: String, Enum: []string{"a", "b"}},
+		{Name: "limit", In: "query", Type: Integer, Max: 100},
+		{Name: "legacy", In: "query", Type: String},
+	}, &jsonschema.Type{Type: "object", Properties: map[string]*jsonschema.Type{
+		"id":   {Type: "string"},
+		"name": {Type: "string"},
+		"age":  {Type: "integer"},
+	}})
+
+	new := diffAPI([]Param{
+		{Name: "id", In: "path", Type: Integer, Required: true},
+		{Name: "kind", In: "query", Type: String, Enum: []string{"a"}},
+		{Name: "limit", In: "query", Type: Integer, Max: 50},
+		{Name: "token", In: "query", Type: String, Required: true},
+		{Name: "verbose", In: "query", Type: Boolean},
+	}, &jsonschema.Type{Type: "object", Properties: map[string]*jsonschema.Type{
+		"id":    {Type: "string"},
+		"age":   {Type: "string"},
+		"email": {Type: "string"},
+	}})
+	delete(new.Paths, "/ping")
+	new.AddPath("/pong")["get"] = Method{}
+
+	changes := Diff(old, new)
+
+	expected := []string{
+		"/ping: path removed",
+		"GET /user/{id} response 200: age changed type from integer to string",
+		"GET /user/{id} response 200: field name removed",
+		"GET /user/{id}: param id changed type from string to integer",
+		"GET /user/{id}: param kind accepts fewer values: a",
+		"GET /user/{id}: param limit maximum lowered to 50",
+		"GET /user/{id}: required param token added",
+	}
+	if got := changeStrings(changes.Breaking()); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Wrong breaking changes:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+
+	expected = []string{
+		"/pong: path added",
+		"GET /user/{id} response 200: field email added",
+		"GET /user/{id}: optional param verbose added",
+		"GET /user/{id}: param legacy removed",
+	}
+	if got := changeStrings(changes.NonBreaking()); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Wrong non-breaking changes:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+
+	if changes := Diff(old, old); len(changes) != 0 {
+		t.Errorf("Changes found between identical descriptions: %v", changes)
+	}
+}
+
+func TestLoad(t *testing.T) {
+
+	a, err := Load(strings.NewReader("swagger: \"2.0\"\nbasePath: /test/1.0\nparameters:\n  auth:\n    name: auth\n    in: query\n    maximum: 10\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Basepath != "/test/1.0" || a.Parameters["auth"].Max != 10 {
+		t.Errorf("Wrong description loaded from yaml: %#v", a)
+	}
+
+	a, err = Load(strings.NewReader(`{"swagger":"2.0","basePath":"/test/1.0"}`))
+	if err != nil || a.Basepath != "/test/1.0" {
+		t.Errorf("Wrong description loaded from json: %#v %v", a, err)
+	}
+
+	if _, err := Load(strings.NewReader("{")); err == nil {
+		t.Error("Broken json loaded")
+	}
+}
+
+func TestDiffRecursive(t *testing.T) {
+
+	// type Node struct { Children []Node }
+	node := func(idType string, extra ...string) *jsonschema.Type {
+		ret := &jsonschema.Type{Type: "object", Properties: map[string]*jsonschema.Type{
+			"id":       {Type: idType},
+			"children": {Type: "array", Items: &jsonschema.Type{Ref: DefinitionRefPrefix + "User"}},
+		}}
+		for _, name := range extra {
+			ret.Properties[name] = &jsonschema.Type{Type: "string"}
+		}
+		return ret
+	}
+
+	old := diffAPI(nil, node("string"))
+	new := diffAPI(nil, node("integer", "name"))
+
+	changes := Diff(old, new)
+
+	expected := []string{"GET /user/{id} response 200: id changed type from string to integer"}
+	if got := changeStrings(changes.Breaking()); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Wrong breaking changes:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+
+	expected = []string{"GET /user/{id} response 200: field name added"}
+	if got := changeStrings(changes.NonBreaking()); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Wrong non-breaking changes:\n%s\nexpected:\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+
+	if changes := Diff(old, old); len(changes) != 0 {
+		t.Errorf("Changes found between identical descriptions: %v", changes)
+	}
+}
@@ -0,0 +1,54 @@
+package swagger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Load reads an API description, in either JSON or YAML
+func Load(r io.Reader) (*API, error) {
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is valid YAML, but decoding it directly keeps numbers and nulls exactly as they are
+	if trimmed := bytes.TrimSpace(b); len(trimmed) == 0 || trimmed[0] != '{' {
+		var v interface{}
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return nil, fmt.Errorf("Invalid swagger description: %s", err)
+		}
+		if b, err = json.Marshal(jsonValue(v)); err != nil {
+			return nil, fmt.Errorf("Invalid swagger description: %s", err)
+		}
+	}
+
+	ret := &API{}
+	if err := json.Unmarshal(b, ret); err != nil {
+		return nil, fmt.Errorf("Invalid swagger description: %s", err)
+	}
+	return ret, nil
+}
+
+// jsonValue converts the generic maps yaml decodes to, so they can be encoded as JSON
+func jsonValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		ret := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			ret[fmt.Sprint(k)] = jsonValue(val)
+		}
+		return ret
+	case []interface{}:
+		for i, val := range t {
+			t[i] = jsonValue(val)
+		}
+	}
+	return v
+}
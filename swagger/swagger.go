@@ -89,10 +89,20 @@ type Param struct {
 // Schema is a generic jsonschema definition - TBD how we want to represent it
 type Schema *jsonschema.Schema
 
+// Header describes a header of a response
+type Header struct {
+	Description string `json:"description,omitempty"`
+	Type        Type   `json:"type"`
+	Format      string `json:"format,omitempty"`
+}
+
 // Response describes a response schema
 type Response struct {
-	Description string `json:"description"`
-	Schema      Schema `json:"schema"`
+	Description string            `json:"description"`
+	Schema      Schema            `json:"schema,omitempty"`
+	Headers     map[string]Header `json:"headers,omitempty"`
+	// Examples of the response body, by content type
+	Examples map[string]interface{} `json:"examples,omitempty"`
 }
 
 // Method describes an API method
@@ -103,10 +113,27 @@ type Method struct {
 	Parameters  []Param             `json:"parameters,omitempty"`
 	Responses   map[string]Response `json:"responses"`
 	Tags        []string            `json:"tags",omitempty`
+	Deprecated  bool                `json:"deprecated,omitempty"`
 }
 
 type Path map[string]Method
 
+// Tag describes a tag operations are grouped by
+type Tag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// SecurityDefinition describes a security scheme requests to the API must satisfy
+type SecurityDefinition struct {
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+	Name        string `json:"name,omitempty"`
+	In          string `json:"in,omitempty"`
+	// The request signing algorithm, for schemes that require clients to sign requests
+	Signature string `json:"x-vertex-signature,omitempty"`
+}
+
 // API describes the base of the API
 type API struct {
 	SwaggerVersion string            `json:"swagger"`
@@ -119,6 +146,10 @@ type API struct {
 	Paths          map[string]Path   `json:"paths"`
 	Definitions    map[string]Schema `json:"definitions,omitempty"`
 	Parameters     map[string]Param  `json:"parameters,omitempty"`
+	Tags           []Tag             `json:"tags,omitempty"`
+
+	SecurityDefinitions map[string]SecurityDefinition `json:"securityDefinitions,omitempty"`
+	Security            []map[string][]string         `json:"security,omitempty"`
 }
 
 func NewAPI(host, title, description, version, basePath string, schemes []string) *API {
@@ -135,6 +166,8 @@ func NewAPI(host, title, description, version, basePath string, schemes []string
 		Schemes:        schemes,
 		Definitions:    make(map[string]Schema),
 		Parameters:     make(map[string]Param),
+
+		SecurityDefinitions: make(map[string]SecurityDefinition),
 	}
 }
 
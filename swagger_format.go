@@ -0,0 +1,135 @@
+package vertex
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Formats of the swagger description
+const (
+	SwaggerJSON = "json"
+	SwaggerYAML = "yaml"
+)
+
+// jsonToYAML converts a JSON document to YAML, keeping the order of object keys so the result diffs cleanly
+func jsonToYAML(b []byte) ([]byte, error) {
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	v, err := decodeOrdered(dec)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(v)
+}
+
+// decodeOrdered decodes the next JSON value, with objects decoded to yaml.MapSlice rather than maps
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			ret := yaml.MapSlice{}
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				val, err := decodeOrdered(dec)
+				if err != nil {
+					return nil, err
+				}
+				ret = append(ret, yaml.MapItem{Key: key, Value: val})
+			}
+			// consume the closing brace
+			_, err := dec.Token()
+			return ret, err
+
+		case '[':
+			ret := []interface{}{}
+			for dec.More() {
+				val, err := decodeOrdered(dec)
+				if err != nil {
+					return nil, err
+				}
+				ret = append(ret, val)
+			}
+			_, err := dec.Token()
+			return ret, err
+		}
+
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i, nil
+		}
+		return t.Float64()
+	}
+
+	return tok, nil
+}
+
+// WriteSwagger writes the swagger description of an API in the given format - json or yaml
+func WriteSwagger(w io.Writer, a *API, host, format string) error {
+
+	b, err := json.MarshalIndent(a.ToSwagger(host), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case SwaggerJSON, "":
+		b = append(b, '\n')
+	case SwaggerYAML:
+		if b, err = jsonToYAML(b); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Invalid swagger format '%s'", format)
+	}
+
+	_, err = w.Write(b)
+	return err
+}
+
+// DumpSwagger writes the swagger description of a registered API without serving it, e.g. to generate clients or
+// diff the API in a build. Versioned APIs are described in their latest version
+func DumpSwagger(apiName, host, format string, out io.Writer) error {
+
+	builder, ok := apiBuilders[apiName]
+	if !ok {
+		return fmt.Errorf("API %s not found", apiName)
+	}
+
+	a := builder()
+	if versions := a.sortedVersions(); len(versions) > 0 {
+		a = a.ForVersion(versions[len(versions)-1])
+	}
+	a.parseRoutes()
+
+	return WriteSwagger(out, a, host, format)
+}
+
+// swaggerYAML renders the swagger description of the API as YAML, for requests with format=yaml
+func (a *API) swaggerYAML(w http.ResponseWriter, r *Request) error {
+
+	var buf bytes.Buffer
+	if err := WriteSwagger(&buf, a, r.Host, SwaggerYAML); err != nil {
+		return NewError(err)
+	}
+
+	w.Header().Set("Content-Type", "application/x-yaml; charset=utf-8")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,100 @@
+package vertex
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestJSONToYAML(t *testing.T) {
+
+	b, err := jsonToYAML([]byte(`{"swagger":"2.0","info":{"title":"x","version":"1.0"},"schemes":["http","https"],"i":3,"f":1.5,"t":true,"z":null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "swagger: \"2.0\"\ninfo:\n  title: x\n  version: \"1.0\"\nschemes:\n- http\n- https\ni: 3\nf: 1.5\nt: true\nz: null\n"
+	if string(b) != expected {
+		t.Errorf("Wrong yaml:\n%s\nexpected:\n%s", b, expected)
+	}
+
+	if _, err := jsonToYAML([]byte(`{"a":`)); err == nil {
+		t.Error("Broken json converted")
+	}
+}
+
+func TestDumpSwagger(t *testing.T) {
+
+	apiBuilders["dumpung"] = func() *API { return mockAPI }
+	defer delete(apiBuilders, "dumpung")
+
+	var buf bytes.Buffer
+	if err := DumpSwagger("dumpung", "example.com", SwaggerJSON, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var desc map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &desc); err != nil {
+		t.Fatalf("Invalid json: %s", err)
+	}
+	if desc["host"] != "example.com" || len(desc["paths"].(map[string]interface{})) != len(mockAPI.Routes) {
+		t.Errorf("Wrong description: %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := DumpSwagger("dumpung", "example.com", SwaggerYAML, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var ydesc map[string]interface{}
+	if err := yaml.Unmarshal(buf.Bytes(), &ydesc); err != nil {
+		t.Fatalf("Invalid yaml: %s", err)
+	}
+	if ydesc["host"] != "example.com" {
+		t.Errorf("Wrong yaml description: %s", buf.String())
+	}
+
+	if err := DumpSwagger("dumpung", "example.com", "xml", &buf); err == nil {
+		t.Error("Invalid format accepted")
+	}
+	if err := DumpSwagger("nosuchapi", "example.com", SwaggerJSON, &buf); err == nil {
+		t.Error("Unknown API dumped")
+	}
+}
+
+func TestSwaggerFormat(t *testing.T) {
+
+	srv := NewServer(":9947")
+	srv.AddAPI(mockAPI)
+
+	s := httptest.NewServer(srv.Handler())
+	defer s.Close()
+
+	u := fmt.Sprintf("%s%s", s.URL, mockAPI.FullPath("/swagger"))
+
+	res, err := http.Get(u + "?format=yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "application/x-yaml") || !strings.HasPrefix(string(b), "swagger: \"2.0\"") {
+		t.Errorf("Wrong yaml response %s: %s", res.Header.Get("Content-Type"), b)
+	}
+
+	res, err = http.Get(u + "?format=xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Invalid format got status %d", res.StatusCode)
+	}
+}
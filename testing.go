@@ -113,7 +113,8 @@ func (t *TestContext) FormatUrl(pathParams Params) string {
 	return u
 }
 
-// NewRequest creates a new http request to the route we are testing now, with optional values for post/get, and optional path params
+// NewRequest creates a new http request to the route we are testing now, with optional values for post/get, and optional path params.
+// If the API has a request signer, the request is signed with it
 func (t *TestContext) NewRequest(method string, values url.Values, pathParams Params) (*http.Request, error) {
 
 	var body io.Reader
@@ -134,6 +135,10 @@ func (t *TestContext) NewRequest(method string, values url.Values, pathParams Pa
 	if err == nil && body != nil {
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	}
+
+	if err == nil && t.api.RequestSigner != nil {
+		err = t.api.RequestSigner.Sign(req)
+	}
 	return req, err
 }
 
@@ -438,3 +443,17 @@ func RunCLITest(apiName, serverAddr, category, format string, out io.Writer) boo
 	tr := newTestRunner(out, a, serverAddr, category, format)
 	return tr.Run()
 }
+
+// testAuth protects the /test endpoints of APIs without TestMiddleware with basic auth, using the credentials of the
+// auth config section. Requests from the local machine do not need credentials if trust_local_connections is set.
+//
+// The default credentials are never accepted, unless allow_default_credentials is set
+var testAuth = MiddlewareFunc(func(w http.ResponseWriter, r *Request, next HandlerFunc) (interface{}, error) {
+
+	if !localTrusted(r) {
+		if err := checkAuthConfig(w, r, "vertex tests"); err != nil {
+			return nil, err
+		}
+	}
+	return next(w, r)
+})
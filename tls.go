@@ -0,0 +1,309 @@
+package vertex
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// clientAuthTypes maps the tls_client_auth config values to client auth policies
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+// tlsVersions maps the tls_min_version config values to TLS versions
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// LoadCertPool reads a PEM bundle of CA certificates into a cert pool
+func LoadCertPool(path string) (*x509.CertPool, error) {
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read CA bundle %s: %s", path, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("No certificates found in CA bundle %s", path)
+	}
+	return pool, nil
+}
+
+// cipherSuites resolves cipher suite names to their ids
+func cipherSuites(names []string) ([]uint16, error) {
+
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := map[string]uint16{}
+	for _, cs := range tls.CipherSuites() {
+		known[cs.Name] = cs.ID
+	}
+
+	ret := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, found := known[name]
+		if !found {
+			return nil, fmt.Errorf("Unknown or insecure cipher suite '%s'", name)
+		}
+		ret = append(ret, id)
+	}
+	return ret, nil
+}
+
+// certReloader serves the server's certificates by SNI, and reloads them when their files change or when the process
+// receives a SIGHUP. Since certificates are picked per handshake, reloading does not affect open connections
+type certReloader struct {
+	files    []tlsCertConfig
+	certs    []*tls.Certificate
+	modTimes []certModTime
+	mutex    sync.RWMutex
+	stop     chan struct{}
+}
+
+// certModTime is the modification times of a certificate file and its key file
+type certModTime struct {
+	cert, key time.Time
+}
+
+// statCert returns the modification times of a certificate's files
+func statCert(f tlsCertConfig) (certModTime, error) {
+
+	cert, err := os.Stat(f.Cert)
+	if err != nil {
+		return certModTime{}, fmt.Errorf("Could not stat TLS certificate: %s", err)
+	}
+
+	key, err := os.Stat(f.Key)
+	if err != nil {
+		return certModTime{}, fmt.Errorf("Could not stat TLS key: %s", err)
+	}
+
+	return certModTime{cert: cert.ModTime(), key: key.ModTime()}, nil
+}
+
+// newCertReloader loads the certificate files. The first certificate is the default one, used when the client
+// does not send SNI or no certificate matches it
+func newCertReloader(files []tlsCertConfig) (*certReloader, error) {
+
+	ret := &certReloader{
+		files: files,
+		stop:  make(chan struct{}),
+	}
+
+	if err := ret.Reload(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Reload reads all the certificate files, and replaces the served certificates only if all of them are valid
+func (c *certReloader) Reload() error {
+
+	certs := make([]*tls.Certificate, 0, len(c.files))
+	modTimes := make([]certModTime, 0, len(c.files))
+
+	for _, f := range c.files {
+
+		modTime, err := statCert(f)
+		if err != nil {
+			return err
+		}
+
+		cert, err := tls.LoadX509KeyPair(f.Cert, f.Key)
+		if err != nil {
+			return fmt.Errorf("Could not load TLS certificate %s: %s", f.Cert, err)
+		}
+
+		if cert.Leaf == nil {
+			if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				return fmt.Errorf("Could not parse TLS certificate %s: %s", f.Cert, err)
+			}
+		}
+
+		certs = append(certs, &cert)
+		modTimes = append(modTimes, modTime)
+	}
+
+	c.mutex.Lock()
+	c.certs = certs
+	c.modTimes = modTimes
+	c.mutex.Unlock()
+
+	logging.Info("Loaded %d TLS certificates", len(certs))
+	return nil
+}
+
+// changed checks whether any of the certificate or key files was modified since we last read them
+func (c *certReloader) changed() bool {
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	for i, f := range c.files {
+		modTime, err := statCert(f)
+		if err != nil {
+			logging.Warning("%s", err)
+			continue
+		}
+		if !modTime.cert.Equal(c.modTimes[i].cert) || !modTime.key.Equal(c.modTimes[i].key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *certReloader) watch(checkInterval time.Duration) {
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	var tick <-chan time.Time
+	if checkInterval > 0 {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-sighup:
+			logging.Info("Got SIGHUP, reloading TLS certificates")
+		case <-tick:
+			if !c.changed() {
+				continue
+			}
+			logging.Info("TLS certificates changed, reloading")
+		}
+
+		if err := c.Reload(); err != nil {
+			logging.Error("Error reloading TLS certificates, keeping the old ones: %s", err)
+		}
+	}
+}
+
+// Close stops watching the certificate files
+func (c *certReloader) Close() {
+	close(c.stop)
+}
+
+// GetCertificate picks the certificate matching the client's SNI, falling back to the default certificate
+func (c *certReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if hello.ServerName != "" && len(c.certs) > 1 {
+		for _, cert := range c.certs {
+			if cert.Leaf.VerifyHostname(hello.ServerName) == nil && hello.SupportsCertificate(cert) == nil {
+				return cert, nil
+			}
+		}
+	}
+
+	return c.certs[0], nil
+}
+
+// newTLSConfig creates the server's TLS config from the server config section, with a reloader serving its
+// certificates. If no certificate is configured, it returns nil and the server serves plain HTTP
+func newTLSConfig(conf serverConfig) (*tls.Config, *certReloader, error) {
+
+	if conf.TLSCert == "" && conf.TLSKey == "" {
+		if conf.TLSClientCA != "" || len(conf.TLSCertificates) > 0 {
+			return nil, nil, fmt.Errorf("TLS is configured without a default server certificate")
+		}
+		return nil, nil, nil
+	}
+
+	minVersion := uint16(tls.VersionTLS12)
+	if conf.TLSMinVersion != "" {
+		var found bool
+		if minVersion, found = tlsVersions[conf.TLSMinVersion]; !found {
+			return nil, nil, fmt.Errorf("Invalid TLS version '%s'", conf.TLSMinVersion)
+		}
+	}
+
+	ciphers, err := cipherSuites(conf.TLSCipherSuites)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ret := &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: ciphers,
+		// enable HTTP/2
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+
+	policy := strings.ToLower(conf.TLSClientAuth)
+	if policy == "" && conf.TLSClientCA != "" {
+		policy = "verify_if_given"
+	}
+
+	if policy != "" {
+		var found bool
+		if ret.ClientAuth, found = clientAuthTypes[policy]; !found {
+			return nil, nil, fmt.Errorf("Invalid client auth policy '%s'", conf.TLSClientAuth)
+		}
+	}
+
+	if conf.TLSClientCA != "" {
+		if ret.ClientCAs, err = LoadCertPool(conf.TLSClientCA); err != nil {
+			return nil, nil, err
+		}
+	} else if ret.ClientAuth >= tls.VerifyClientCertIfGiven {
+		return nil, nil, fmt.Errorf("Client auth policy '%s' requires a client CA", policy)
+	}
+
+	reloader, err := newCertReloader(append([]tlsCertConfig{{Cert: conf.TLSCert, Key: conf.TLSKey}}, conf.TLSCertificates...))
+	if err != nil {
+		return nil, nil, err
+	}
+	ret.GetCertificate = reloader.GetCertificate
+
+	logging.Info("TLS enabled with certificate %s, min version %s, client auth policy: '%s'", conf.TLSCert, conf.TLSMinVersion, policy)
+	return ret, reloader, nil
+}
+
+// httpsRedirectHandler redirects plain HTTP requests to the same url on the request's host and the given HTTPS port.
+// The port is the one clients reach, and not the one we listen on, which may be behind a load balancer or a socket
+func httpsRedirectHandler(httpsPort int) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if httpsPort != 0 && httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
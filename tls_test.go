@@ -0,0 +1,195 @@
+package vertex
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestCert writes a self signed certificate and its key to dir, and returns their paths
+func writeTestCert(t *testing.T, dir, name string, hosts ...string) (certFile, keyFile string) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              hosts,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = path.Join(dir, name+".crt"), path.Join(dir, name+".key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return
+}
+
+func TestTLSConfig(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "vertex-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert, key := writeTestCert(t, dir, "server", "localhost")
+	ca, _ := writeTestCert(t, dir, "ca")
+
+	// no certificate - no TLS
+	conf, certs, err := newTLSConfig(serverConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, conf)
+	assert.Nil(t, certs)
+
+	conf, certs, err = newTLSConfig(serverConfig{TLSCert: cert, TLSKey: key})
+	assert.NoError(t, err)
+	if assert.NotNil(t, conf) {
+		assert.Equal(t, tls.NoClientCert, conf.ClientAuth)
+		assert.Equal(t, uint16(tls.VersionTLS12), conf.MinVersion)
+		assert.Contains(t, conf.NextProtos, "h2")
+		assert.NotNil(t, conf.GetCertificate)
+		certs.Close()
+	}
+
+	conf, certs, err = newTLSConfig(serverConfig{TLSCert: cert, TLSKey: key, TLSMinVersion: "1.3",
+		TLSCipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}})
+	assert.NoError(t, err)
+	if assert.NotNil(t, conf) {
+		assert.Equal(t, uint16(tls.VersionTLS13), conf.MinVersion)
+		assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, conf.CipherSuites)
+		certs.Close()
+	}
+
+	// a client CA defaults to verifying certificates if given
+	conf, certs, err = newTLSConfig(serverConfig{TLSCert: cert, TLSKey: key, TLSClientCA: ca})
+	assert.NoError(t, err)
+	if assert.NotNil(t, conf) {
+		assert.Equal(t, tls.VerifyClientCertIfGiven, conf.ClientAuth)
+		assert.NotNil(t, conf.ClientCAs)
+		certs.Close()
+	}
+
+	conf, certs, err = newTLSConfig(serverConfig{TLSCert: cert, TLSKey: key, TLSClientCA: ca, TLSClientAuth: "require_and_verify"})
+	assert.NoError(t, err)
+	if assert.NotNil(t, conf) {
+		assert.Equal(t, tls.RequireAndVerifyClientCert, conf.ClientAuth)
+		certs.Close()
+	}
+
+	for _, bad := range []serverConfig{
+		{TLSCert: cert, TLSKey: key, TLSClientAuth: "require_and_verify"},
+		{TLSCert: cert, TLSKey: key, TLSClientCA: ca, TLSClientAuth: "sometimes"},
+		{TLSCert: cert, TLSKey: key, TLSClientCA: key},
+		{TLSCert: cert, TLSKey: key, TLSMinVersion: "0.9"},
+		{TLSCert: cert, TLSKey: key, TLSCipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{TLSCert: cert, TLSKey: key, TLSCertificates: []tlsCertConfig{{Cert: cert, Key: cert}}},
+		{TLSCert: cert, TLSKey: cert},
+		{TLSClientCA: ca},
+	} {
+		_, _, err = newTLSConfig(bad)
+		assert.Error(t, err, "%#v", bad)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "vertex-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert, key := writeTestCert(t, dir, "default", "example.com")
+	apiCert, apiKey := writeTestCert(t, dir, "api", "api.example.org")
+
+	certs, err := newCertReloader([]tlsCertConfig{{cert, key}, {apiCert, apiKey}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hello := func(name string) string {
+		c, err := certs.GetCertificate(&tls.ClientHelloInfo{
+			ServerName:        name,
+			SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+			SupportedVersions: []uint16{tls.VersionTLS13},
+		})
+		assert.NoError(t, err)
+		return c.Leaf.Subject.CommonName
+	}
+
+	// certificates are picked by SNI, with a fallback to the default one
+	assert.Equal(t, "default", hello("example.com"))
+	assert.Equal(t, "api", hello("api.example.org"))
+	assert.Equal(t, "default", hello("other.example.net"))
+	assert.Equal(t, "default", hello(""))
+
+	// replacing a certificate file is picked up on reload
+	assert.False(t, certs.changed())
+	time.Sleep(10 * time.Millisecond)
+	newCert, newKey := writeTestCert(t, dir, "api", "api.example.org", "new.example.org")
+	assert.Equal(t, apiCert, newCert)
+	assert.Equal(t, apiKey, newKey)
+	assert.True(t, certs.changed())
+
+	assert.NoError(t, certs.Reload())
+	assert.Equal(t, "api", hello("new.example.org"))
+
+	// so is a rotation that writes the key file last
+	assert.False(t, certs.changed())
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(apiKey, later, later))
+	assert.True(t, certs.changed())
+	assert.NoError(t, certs.Reload())
+	assert.False(t, certs.changed())
+
+	// a broken certificate does not replace the working ones
+	ioutil.WriteFile(apiCert, []byte("garbage"), 0600)
+	assert.Error(t, certs.Reload())
+	assert.Equal(t, "api", hello("new.example.org"))
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+
+	for port, expected := range map[int]string{
+		443:  "https://example.com/foo/bar?baz=1",
+		9944: "https://example.com:9944/foo/bar?baz=1",
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "http://example.com:80/foo/bar?baz=1", nil)
+		httpsRedirectHandler(port).ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusMovedPermanently, w.Code)
+		assert.Equal(t, expected, w.Header().Get("Location"))
+	}
+}
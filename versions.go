@@ -0,0 +1,256 @@
+package vertex
+
+import (
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dvirsky/go-pylog/logging"
+)
+
+// AcceptVersionHeader selects the version of an API for requests to its unversioned root, e.g. /myapi/users
+const AcceptVersionHeader = "Accept-Version"
+
+// versionRe matches the versions we can order - dotted numbers, e.g. 1, 1.2 or 2.0.1
+var versionRe = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+
+// validVersion tells whether a version is made of dotted numbers, so it can be ordered and negotiated
+func validVersion(version string) bool {
+	return versionRe.MatchString(version)
+}
+
+// compareVersions compares dotted versions part by part, numerically where both parts are numbers.
+// It returns -1 if a < b, 0 if they are equal and 1 if a > b. Versions are validated with validVersion when their API is
+// added, so the string comparison of non numeric parts is only a fallback
+func compareVersions(a, b string) int {
+
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		// missing parts are zero, so 1.0 == 1
+		ap, bp := "0", "0"
+		if i < len(as) {
+			ap = as[i]
+		}
+		if i < len(bs) {
+			bp = bs[i]
+		}
+
+		an, aerr := strconv.Atoi(ap)
+		bn, berr := strconv.Atoi(bp)
+
+		switch {
+		case aerr == nil && berr == nil && an != bn:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case (aerr != nil || berr != nil) && ap != bp:
+			if ap < bp {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// servedIn tells whether a route is served in a version of its API, according to its Since and Until versions
+func (r Route) servedIn(version string) bool {
+	if r.Since != "" && compareVersions(version, r.Since) < 0 {
+		return false
+	}
+	if r.Until != "" && compareVersions(version, r.Until) > 0 {
+		return false
+	}
+	return true
+}
+
+// sortedVersions returns the API's versions, oldest first
+func (a *API) sortedVersions() []string {
+	ret := append([]string{}, a.Versions...)
+	sort.Slice(ret, func(i, j int) bool { return compareVersions(ret[i], ret[j]) < 0 })
+	return ret
+}
+
+// unversionedRoot is the root of a versioned API without the version, e.g. /myapi
+func (a *API) unversionedRoot() string {
+	if a.Root != "" {
+		return a.Root
+	}
+	return "/" + a.Name
+}
+
+// ForVersion returns the API as it is served in one of its versions, with only the routes served in that version,
+// and the version's sunset date from Sunsets.
+//
+// The lifecycle hooks and health checks belong to the API and not to its versions, so they are kept only in the
+// latest version, and are called once
+func (a *API) ForVersion(version string) *API {
+
+	ret := *a
+	ret.Version = version
+	ret.Versions = nil
+	ret.Sunset = a.Sunsets[version]
+	ret.Sunsets = nil
+	ret.Root = ""
+	if a.Root != "" {
+		ret.Root = path.Join(a.Root, version)
+	}
+
+	ret.Routes = make(Routes, 0, len(a.Routes))
+	for _, route := range a.Routes {
+		if route.servedIn(version) {
+			ret.Routes = append(ret.Routes, route)
+		}
+	}
+
+	if versions := a.sortedVersions(); version != versions[len(versions)-1] {
+		ret.OnStart = nil
+		ret.OnStop = nil
+		ret.HealthChecks = nil
+	}
+
+	return &ret
+}
+
+// versionedAPI is a versioned API mounted on the server, for negotiating versions on its unversioned root
+type versionedAPI struct {
+	root     string
+	versions []string
+}
+
+// negotiate picks the version for a request to the unversioned root - the one in the Accept-Version header, or the
+// latest one. A partial version picks the latest version it prefixes, e.g. 1 picks 1.2 over 1.1.
+//
+// It fails with a bad request error if the header is not a valid version, and with a not acceptable error if no
+// version matches it
+func (v versionedAPI) negotiate(accept string) (string, error) {
+
+	latest := v.versions[len(v.versions)-1]
+	if accept == "" {
+		return latest, nil
+	}
+
+	if !validVersion(accept) {
+		return "", InvalidRequestError("Invalid %s '%s'", AcceptVersionHeader, accept)
+	}
+
+	for i := len(v.versions) - 1; i >= 0; i-- {
+		if ver := v.versions[i]; compareVersions(ver, accept) == 0 || strings.HasPrefix(ver, accept+".") {
+			return ver, nil
+		}
+	}
+	return "", NotAcceptableError("No version %s for %s", accept, v.root)
+}
+
+// checkVersions validates the versions of a versioned API and of its routes, and returns the valid versions of the API.
+// Invalid versions can't be ordered, so they are logged and skipped
+func (a *API) checkVersions() []string {
+
+	ret := make([]string, 0, len(a.Versions))
+	seen := map[string]bool{}
+	for _, version := range a.Versions {
+		switch {
+		case !validVersion(version):
+			logging.Error("API %s has an invalid version '%s'. Versions must be dotted numbers, e.g. 1.2", a.Name, version)
+		case seen[version]:
+			logging.Error("API %s has the version %s more than once", a.Name, version)
+		default:
+			seen[version] = true
+			ret = append(ret, version)
+		}
+	}
+
+	for _, route := range a.Routes {
+		for _, version := range []string{route.Since, route.Until} {
+			if version != "" && !validVersion(version) {
+				logging.Error("Route %s of API %s has an invalid version '%s'. Versions must be dotted numbers, e.g. 1.2",
+					route.Path, a.Name, version)
+			}
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return compareVersions(ret[i], ret[j]) < 0 })
+	return ret
+}
+
+// addVersionedAPI mounts every valid version of a versioned API
+func (s *Server) addVersionedAPI(a *API) {
+
+	if !a.Sunset.IsZero() {
+		logging.Warning("API %s has versions, so its Sunset is ignored. Set the sunset of each version in Sunsets", a.Name)
+	}
+
+	versions := a.checkVersions()
+	if len(versions) == 0 {
+		logging.Error("API %s has no valid versions, and will not be served", a.Name)
+		return
+	}
+
+	// mount a copy with only the valid versions, so the latest version is picked from them
+	valid := *a
+	valid.Versions = versions
+	for _, version := range versions {
+		s.AddAPI(valid.ForVersion(version))
+	}
+
+	s.versioned = append(s.versioned, versionedAPI{root: a.unversionedRoot(), versions: versions})
+}
+
+// routed tells whether the router answers a request with anything but a 404 - a route for the method, a route for
+// another method (405), or a route for the path with or without a trailing slash (a redirect)
+func (s *Server) routed(method, path string) bool {
+
+	if handle, _, tsr := s.router.Lookup(method, path); handle != nil || (tsr && s.router.RedirectTrailingSlash) {
+		return true
+	}
+
+	if s.router.HandleMethodNotAllowed {
+		for _, m := range []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"} {
+			if handle, _, _ := s.router.Lookup(m, path); m != method && handle != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serveVersioned serves requests to the unversioned root of a versioned API with the version the client accepts.
+// It returns false if the request is not under any unversioned root
+func (s *Server) serveVersioned(w http.ResponseWriter, r *http.Request) bool {
+
+	for _, v := range s.versioned {
+		if r.URL.Path != v.root && !strings.HasPrefix(r.URL.Path, v.root+"/") {
+			continue
+		}
+
+		version, err := v.negotiate(r.Header.Get(AcceptVersionHeader))
+		if err != nil {
+			s.renderError(w, r, err)
+			return true
+		}
+
+		// if the version has no such route either, it's a plain 404
+		versioned := path.Join(v.root, version, strings.TrimPrefix(r.URL.Path, v.root))
+		if !s.routed(r.Method, versioned) {
+			return false
+		}
+
+		w.Header().Add("Vary", AcceptVersionHeader)
+
+		r2 := new(http.Request)
+		*r2 = *r
+		u := *r.URL
+		u.Path = versioned
+		u.RawPath = ""
+		r2.URL = &u
+
+		s.router.ServeHTTP(w, r2)
+		return true
+	}
+
+	return false
+}
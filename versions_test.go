@@ -0,0 +1,172 @@
+package vertex
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareVersions(t *testing.T) {
+
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0", "1.0", 0},
+		{"1", "1.0", 0},
+		{"1.0", "1.1", -1},
+		{"1.10", "1.9", 1},
+		{"2.0", "1.10", 1},
+		{"1.0-beta", "1.0-alpha", 1},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, compareVersions(test.a, test.b), "%s <=> %s", test.a, test.b)
+	}
+}
+
+func versionHandler(v string) HandlerFunc {
+	return HandlerFunc(func(w http.ResponseWriter, r *Request) (interface{}, error) {
+		return v, nil
+	})
+}
+
+func TestVersionedAPI(t *testing.T) {
+
+	started := 0
+	sunset := time.Now().Add(-time.Hour)
+	a := &API{
+		Name:          "versiontung",
+		Versions:      []string{"1.1", "1.0", "2.0"},
+		Sunsets:       map[string]time.Time{"1.0": sunset},
+		EnforceSunset: true,
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		OnStart: func() error {
+			started++
+			return nil
+		},
+		Routes: Routes{
+			{Path: "/all", Methods: GET, Handler: versionHandler("all")},
+			{Path: "/old", Methods: GET, Handler: versionHandler("old"), Until: "1.1"},
+			{Path: "/new", Methods: GET, Handler: versionHandler("new"), Since: "1.1"},
+		},
+	}
+
+	srv := NewServer(":9947")
+	srv.AddAPI(a)
+
+	if assert.Len(t, srv.apis, 3) {
+		assert.Equal(t, "/versiontung/1.0", srv.apis[0].root())
+		assert.Equal(t, "/versiontung/2.0", srv.apis[2].root())
+		assert.Len(t, srv.apis[0].Routes, 2)
+		assert.Len(t, srv.apis[1].Routes, 3)
+		assert.Len(t, srv.apis[2].Routes, 2)
+	}
+
+	// the hooks are called once, not once per version
+	assert.NoError(t, srv.start())
+	assert.Equal(t, 1, started)
+
+	s := httptest.NewServer(srv.Handler())
+	defer s.Close()
+
+	do := func(method, path, version string) (int, string) {
+		req, _ := http.NewRequest(method, s.URL+path, nil)
+		if version != "" {
+			req.Header.Set(AcceptVersionHeader, version)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, string(b)
+	}
+	get := func(path, version string) (int, string) {
+		return do("GET", path, version)
+	}
+
+	// only the versions in Sunsets are retired
+	code, _ := get("/versiontung/1.0/old", "")
+	assert.Equal(t, http.StatusGone, code)
+	assert.Equal(t, sunset, srv.apis[0].Sunset)
+	assert.True(t, srv.apis[1].Sunset.IsZero())
+	assert.True(t, srv.apis[2].Sunset.IsZero())
+
+	code, _ = get("/versiontung/1.1/old", "")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = get("/versiontung/1.0/new", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = get("/versiontung/2.0/old", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// the unversioned root serves the latest version, or the accepted one
+	code, _ = get("/versiontung/new", "")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = get("/versiontung/old", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = get("/versiontung/old", "1.1")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = get("/versiontung/old", "1")
+	assert.Equal(t, http.StatusOK, code)
+
+	// a route with another method is not allowed, rather than not found
+	code, _ = do("POST", "/versiontung/new", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+	code, _ = do("POST", "/versiontung/old", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// versions we don't serve are not acceptable, and versions we can't order are bad requests
+	code, _ = get("/versiontung/all", "3.0")
+	assert.Equal(t, http.StatusNotAcceptable, code)
+	code, _ = get("/versiontung/all", "1.2")
+	assert.Equal(t, http.StatusNotAcceptable, code)
+	code, _ = get("/versiontung/all", "v2")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = get("/versiontung/all", "2")
+	assert.Equal(t, http.StatusOK, code)
+
+	// each version has its own swagger
+	code, body := get("/versiontung/1.0/swagger", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"/old"`)
+	assert.NotContains(t, body, `"/new"`)
+
+	code, body = get("/versiontung/swagger", "2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"basePath":"/versiontung/2.0"`)
+}
+
+func TestInvalidVersions(t *testing.T) {
+
+	a := &API{
+		Name:          "badversions",
+		Versions:      []string{"1.0", "v2", "beta", "1.0", "1.1"},
+		Renderer:      JSONRenderer{},
+		AllowInsecure: true,
+		Routes: Routes{
+			{Path: "/all", Methods: GET, Handler: versionHandler("all")},
+		},
+	}
+
+	// invalid and duplicate versions are skipped
+	srv := NewServer(":9947")
+	srv.AddAPI(a)
+	if assert.Len(t, srv.apis, 2) {
+		assert.Equal(t, "/badversions/1.0", srv.apis[0].root())
+		assert.Equal(t, "/badversions/1.1", srv.apis[1].root())
+	}
+	assert.Equal(t, []string{"1.0", "v2", "beta", "1.0", "1.1"}, a.Versions)
+
+	// an API without valid versions is not served
+	srv = NewServer(":9947")
+	srv.AddAPI(&API{Name: "noversions", Versions: []string{"latest"}, Renderer: JSONRenderer{}})
+	assert.Empty(t, srv.apis)
+	assert.Empty(t, srv.versioned)
+}
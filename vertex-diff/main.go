@@ -0,0 +1,109 @@
+// vertex-diff compares two versions of a vertex API's swagger description, and exits with a non zero status if the
+// new one has changes that may break existing clients.
+//
+// Usage:
+//
+//	vertex-diff -old file://api-1.0.yaml -new http://localhost:9944/myapi/1.0/swagger
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/EverythingMe/vertex/swagger"
+)
+
+// exit statuses
+const (
+	statusOK       = 0
+	statusBreaking = 1
+	statusError    = 2
+)
+
+func die(msg string, args ...interface{}) {
+	fmt.Fprintln(os.Stderr, fmt.Sprintf(msg, args...))
+	os.Exit(statusError)
+}
+
+// load reads a swagger description from an http URL, a file:// URI or stdin
+func load(uri string) *swagger.API {
+
+	var input io.Reader
+
+	switch {
+	case strings.HasPrefix(uri, "http"):
+		resp, err := http.Get(uri)
+		if err != nil {
+			die("Error getting swagger from url '%s': %s", uri, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			b, _ := ioutil.ReadAll(resp.Body)
+			die("Error reading swagger: status code %d, content: %s", resp.StatusCode, string(b))
+		}
+		input = resp.Body
+	case strings.HasPrefix(uri, "file://"):
+		fp, err := os.Open(strings.TrimPrefix(uri, "file://"))
+		if err != nil {
+			die("Could not open %s: %s", uri, err)
+		}
+		defer fp.Close()
+		input = fp
+	case uri == "-":
+		input = os.Stdin
+	default:
+		die("Invalid swagger URI given: %s", uri)
+	}
+
+	ret, err := swagger.Load(input)
+	if err != nil {
+		die("Error decoding swagger from %s: %s", uri, err)
+	}
+	return ret
+}
+
+func main() {
+
+	oldUrl := flag.String("old", "", "http URL or file:// URI of the old swagger description, json or yaml. - for stdin")
+	newUrl := flag.String("new", "", "http URL or file:// URI of the new swagger description, json or yaml. - for stdin")
+	quiet := flag.Bool("breaking-only", false, "Only print breaking changes")
+
+	flag.Parse()
+
+	if *oldUrl == "" || *newUrl == "" {
+		die("Both -old and -new must be given")
+	}
+	if *oldUrl == "-" && *newUrl == "-" {
+		die("Only one description can be read from stdin")
+	}
+
+	changes := swagger.Diff(load(*oldUrl), load(*newUrl))
+	breaking := changes.Breaking()
+
+	if len(breaking) > 0 {
+		fmt.Printf("Breaking changes (%d):\n", len(breaking))
+		for _, c := range breaking {
+			fmt.Printf("  %s\n", c)
+		}
+	}
+
+	if nonBreaking := changes.NonBreaking(); len(nonBreaking) > 0 && !*quiet {
+		fmt.Printf("Non-breaking changes (%d):\n", len(nonBreaking))
+		for _, c := range nonBreaking {
+			fmt.Printf("  %s\n", c)
+		}
+	}
+
+	if len(breaking) > 0 {
+		os.Exit(statusBreaking)
+	}
+	if len(changes) == 0 {
+		fmt.Println("No changes")
+	}
+	os.Exit(statusOK)
+}
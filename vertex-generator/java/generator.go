@@ -6,15 +6,19 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"path"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 
 	"gopkg.in/yaml.v2"
 
 	"github.com/alecthomas/jsonschema"
+	"github.com/EverythingMe/vertex/signature"
 	"github.com/EverythingMe/vertex/swagger"
 	"github.com/EverythingMe/vertex/vertex-generator/registry"
 )
@@ -121,16 +125,69 @@ func formatMethodName(path, verb string) string {
 	return strings.ToLower(verb) + strings.Join(parts, "")
 }
 
+// responseType returns the java type of a response's body. Responses without a body are parsed as Objects
+func responseType(resp swagger.Response) TypeRef {
+	if resp.Schema == nil || resp.Schema.Type == nil {
+		return TypeRef{Type: Object}
+	}
+	return newTypeRef(resp.Schema.Type)
+}
+
+// successResponse returns the response of a method's successful calls - the first 2xx response, or the default one
+func successResponse(method swagger.Method) swagger.Response {
+	for code := http.StatusOK; code < http.StatusMultipleChoices; code++ {
+		if resp, found := method.Responses[strconv.Itoa(code)]; found {
+			return resp
+		}
+	}
+	return method.Responses["default"]
+}
+
+// newExceptions creates typed exceptions for the error responses of a method, sorted by their status codes
+func newExceptions(methodName string, method swagger.Method) []Exception {
+
+	ret := []Exception{}
+	for code, resp := range method.Responses {
+		status, err := strconv.Atoi(code)
+		if err != nil || status < http.StatusBadRequest {
+			continue
+		}
+
+		name := cleanRe.ReplaceAllString(http.StatusText(status), "")
+		if name == "" {
+			name = "Status" + code
+		}
+
+		doc := resp.Description
+		if doc == "" {
+			doc = http.StatusText(status)
+		}
+
+		ret = append(ret, Exception{
+			Name:   strings.Title(methodName) + name + "Exception",
+			Status: status,
+			Body:   responseType(resp),
+			Doc:    doc,
+		})
+	}
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Status < ret[j].Status })
+	return ret
+}
+
 // newJavaMathod creates a new method definition based on a swagger method definition and a return value
 func (g *Generator) newJavaMethod(pth, verb string, method swagger.Method) Method {
 
+	name := formatMethodName(pth, verb)
 	ret := Method{
-		Name:     formatMethodName(pth, verb),
-		Returns:  newTypeRef(method.Responses["default"].Schema.Type),
-		Params:   make([]Param, 0, len(method.Parameters)),
-		HttpVerb: strings.ToUpper(verb),
-		Path:     pth,
-		Doc:      method.Description,
+		Name:       name,
+		Returns:    responseType(successResponse(method)),
+		Throws:     newExceptions(name, method),
+		Params:     make([]Param, 0, len(method.Parameters)),
+		HttpVerb:   strings.ToUpper(verb),
+		Path:       pth,
+		Doc:        method.Description,
+		Deprecated: method.Deprecated,
 	}
 
 	for _, param := range method.Parameters {
@@ -165,7 +222,7 @@ func (g *Generator) Generate(swapi *swagger.API) ([]byte, error) {
 	}
 	japi := g.newJavaAPI(swapi)
 
-	return g.render(tpl, &japi)
+	return g.render(tpl+signerTpl, &japi)
 
 }
 
@@ -216,10 +273,19 @@ func (g *Generator) newJavaAPI(swapi *swagger.API) API {
 		api.Types = append(api.Types, g.newJavaClass(name, tp.Type))
 	}
 
+	for _, def := range swapi.SecurityDefinitions {
+		if def.Signature == signature.Algorithm {
+			api.Signed = true
+		}
+	}
+
 	for path, methods := range swapi.Paths {
 		for verb, method := range methods {
 
-			api.Methods = append(api.Methods, g.newJavaMethod(path, verb, method))
+			m := g.newJavaMethod(path, verb, method)
+			m.Signed = api.Signed
+			api.Methods = append(api.Methods, m)
+			api.Exceptions = append(api.Exceptions, m.Throws...)
 		}
 	}
 
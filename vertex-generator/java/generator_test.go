@@ -3,10 +3,18 @@ package java
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/alecthomas/jsonschema"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/EverythingMe/vertex/signature"
 	"github.com/EverythingMe/vertex/swagger"
 )
 
@@ -96,3 +104,193 @@ func TestFormatMethodName(t *testing.T) {
 
 	}
 }
+
+func TestSignedAPI(t *testing.T) {
+	var api swagger.API
+
+	if err := json.Unmarshal([]byte(swg), &api); err != nil {
+		t.Fatal(err)
+	}
+
+	g := &Generator{substitutions: map[string]string{}}
+
+	b, err := g.Generate(&api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, string(b), "class Signer")
+
+	api.SecurityDefinitions = map[string]swagger.SecurityDefinition{
+		"hmac": {Type: "apiKey", In: "header", Name: "X-Vertex-Signature", Signature: "hmac-sha256"},
+	}
+
+	assert.True(t, g.newJavaAPI(&api).Signed)
+
+	b, err = g.Generate(&api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, string(b), "public static class Signer")
+	assert.Contains(t, string(b), "import javax.crypto.Mac;")
+	assert.Contains(t, string(b), "char nl = (char) 10;")
+
+	// every method signs the path it sends and its params
+	api.Paths["/User/{id}"] = swagger.Path{
+		"get": swagger.Method{
+			Parameters: []swagger.Param{
+				{Name: "id", Type: swagger.String, In: "path", Required: true},
+				{Name: "tags", Type: swagger.Array, Items: swagger.String, In: "query"},
+			},
+			Responses: map[string]swagger.Response{"200": {Description: "ok"}},
+		},
+	}
+	b, err = g.Generate(&api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(b)
+	assert.Contains(t, out, `String path = "/User/{id}"
+                .replace("{id}", Signer.escapePath(String.valueOf(id)));`)
+	assert.Contains(t, out, `Signer.add(signed, "tags", tags);`)
+	assert.Contains(t, out, `signer.sign("GET", ROOT + path, signed, null)`)
+	assert.Contains(t, out, "return perform(Request.Method.GET, path,")
+	assert.Equal(t, strings.Count(out, "public CompletableFuture<"), strings.Count(out, "signer.sign("))
+}
+
+// TestSignerCrossCheck runs the generated java signer, and checks that it signs requests exactly like the signature
+// package. It needs a JDK, and is skipped without one
+func TestSignerCrossCheck(t *testing.T) {
+
+	javac, err := exec.LookPath("javac")
+	if err != nil {
+		t.Skip("javac not found")
+	}
+
+	src := `
+import java.io.UnsupportedEncodingException;
+import java.net.URLEncoder;
+import java.security.GeneralSecurityException;
+import java.security.MessageDigest;
+import java.security.SecureRandom;
+import java.util.ArrayList;
+import java.util.Arrays;
+import java.util.HashMap;
+import java.util.List;
+import java.util.Map;
+import java.util.TreeMap;
+import javax.crypto.Mac;
+import javax.crypto.spec.SecretKeySpec;
+
+public class SignerCheck {
+{{ template "signer" . }}
+    public static void main(String[] args) throws Exception {
+        Map<String,List<String>> params = new HashMap<>();
+        Signer.add(params, "tag", Arrays.asList("b", "a"));
+        Signer.add(params, "name", "x y*" + (char) 126);
+        Signer.add(params, "id", 42);
+        Signer.add(params, "missing", null);
+        String path = "/api/1.0/user/" + Signer.escapePath("a/b c@d");
+        System.out.print(Signer.signature("s3cr3t", Signer.canonical("post", path, params, null, "1700000000", "abc")));
+    }
+}
+`
+	g := &Generator{substitutions: map[string]string{}}
+	b, err := g.render(src+signerTpl, &API{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "SignerCheck.java"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command(javac, "-d", dir, filepath.Join(dir, "SignerCheck.java")).CombinedOutput(); err != nil {
+		t.Fatalf("Could not compile the signer: %s\n%s", err, out)
+	}
+	out, err := exec.Command("java", "-cp", dir, "SignerCheck").CombinedOutput()
+	if err != nil {
+		t.Fatalf("Could not run the signer: %s\n%s", err, out)
+	}
+
+	params := url.Values{"tag": {"b", "a"}, "name": {"x y*~"}, "id": {"42"}}
+	canonical := signature.CanonicalString("POST", "/api/1.0/user/"+url.PathEscape("a/b c@d"), params,
+		signature.BodyHash(nil), "1700000000", "abc")
+	assert.Equal(t, signature.Compute("s3cr3t", canonical), string(out))
+}
+
+func TestExceptions(t *testing.T) {
+	var api swagger.API
+
+	if err := json.Unmarshal([]byte(swg), &api); err != nil {
+		t.Fatal(err)
+	}
+
+	api.Paths["/User"] = swagger.Path{
+		"post": swagger.Method{
+			Description: "Create a user",
+			Responses: map[string]swagger.Response{
+				"201": {Description: "Created", Schema: swagger.Schema(&jsonschema.Schema{Type: &jsonschema.Type{Ref: "#/definitions/User"}}),
+					Headers: map[string]swagger.Header{"Location": {Type: swagger.String}}},
+				"404":     {Description: "No such user", Schema: swagger.Schema(&jsonschema.Schema{Type: &jsonschema.Type{Ref: "#/definitions/Client"}})},
+				"409":     {},
+				"default": {Description: "Error"},
+			},
+		},
+	}
+
+	g := &Generator{substitutions: map[string]string{}}
+	japi := g.newJavaAPI(&api)
+
+	var method Method
+	for _, m := range japi.Methods {
+		if m.Name == "postUser" {
+			method = m
+		}
+	}
+
+	assert.Equal(t, "Types.User", method.Returns.String())
+	if assert.Len(t, method.Throws, 2) {
+		assert.Equal(t, Exception{Name: "PostUserNotFoundException", Status: 404, Body: TypeRef{Namespace: "Types", Type: "Client"}, Doc: "No such user"}, method.Throws[0])
+		assert.Equal(t, Exception{Name: "PostUserConflictException", Status: 409, Body: TypeRef{Type: Object}, Doc: "Conflict"}, method.Throws[1])
+	}
+	assert.Len(t, japi.Exceptions, 2)
+
+	b, err := g.Generate(&api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, string(b), "public static class PostUserNotFoundException extends APIException {")
+	assert.Contains(t, string(b), "public final Types.Client body;")
+	assert.Contains(t, string(b), "errors.put(404, PostUserNotFoundException.class);")
+	// the runtime's perform has no error mapping, so the errors are mapped by the generated class
+	assert.Contains(t, string(b), "parser(Types.User.class))\n                .exceptionally(e -> { throw errorFor(e, errors); });")
+	assert.NotContains(t, string(b), "pathParams,\n                       parser(Types.User.class),\n                       errors);")
+	assert.Contains(t, string(b), "private RuntimeException errorFor(Throwable failure, Map<Integer, Class<? extends APIException>> errors) {")
+	assert.Contains(t, string(b), "this.errorDecoder = decoder;")
+	assert.Contains(t, string(b), "@throws PostUserConflictException Conflict")
+}
+
+func TestDeprecated(t *testing.T) {
+	var api swagger.API
+
+	if err := json.Unmarshal([]byte(swg), &api); err != nil {
+		t.Fatal(err)
+	}
+
+	method := api.Paths["/ping"]["get"]
+	method.Deprecated = true
+	api.Paths["/ping"]["get"] = method
+
+	g := &Generator{substitutions: map[string]string{}}
+	b, err := g.Generate(&api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, string(b), "@Deprecated\n    public CompletableFuture<String> getPing()")
+	assert.Equal(t, 1, strings.Count(string(b), "@Deprecated"))
+}
@@ -14,7 +14,18 @@ package {{ .Package }};
 import java.util.HashMap;
 import java.util.Map;
 import java.io.Serializable;
-
+{{ if .Exceptions }}import java.lang.reflect.Method;
+{{ end }}{{ if .Signed }}import java.io.UnsupportedEncodingException;
+import java.net.URLEncoder;
+import java.security.GeneralSecurityException;
+import java.security.MessageDigest;
+import java.security.SecureRandom;
+import java.util.ArrayList;
+import java.util.List;
+import java.util.TreeMap;
+import javax.crypto.Mac;
+import javax.crypto.spec.SecretKeySpec;
+{{ end }}
 import everything.me.vertex.BaseAPI;
 import everything.me.vertex.Client;
 import everything.me.vertex.Decoder;
@@ -44,8 +55,49 @@ public class {{.Name}} extends BaseAPI {
     }
     
     
+    /**
+    * Base class of the exceptions thrown for the error responses the API describes
+    */
+    public static class APIException extends RuntimeException {
+        public final int status;
+
+        public APIException(int status, String message) {
+            super(message);
+            this.status = status;
+        }
+    }
+    {{ range .Exceptions }}
+    /**
+    * {{ .Doc }} ({{ .Status }})
+    */
+    public static class {{ .Name }} extends APIException {
+        public static final int STATUS = {{ .Status }};
+        public static final Class<?> BODY = {{ .Body.Raw }}.class;
+        public final {{ .Body }} body;
+
+        public {{ .Name }}({{ .Body }} body) {
+            super(STATUS, {{ printf "%q" .Doc }});
+            this.body = body;
+        }
+    }
+    {{ end }}
+    {{ if .Signed }}{{ template "signer" . }}
+    private Signer signer;
+
+    /**
+    * Signs all the requests of the client. The signature is sent in the request params
+    */
+    public {{ .Name }} withSigner(Signer signer) {
+        this.signer = signer;
+        return this;
+    }
+    {{ end }}
+{{ if .Exceptions }}{{ template "errors" . }}
+    private final Decoder errorDecoder;
+{{ end }}
     public {{ .Name }}(boolean secure, String host, Decoder decoder, Client client) {
-        super(secure, host, "{{ .Root }}", decoder, client);
+        super(secure, host, "{{ .Root }}", decoder, client);{{ if .Exceptions }}
+        this.errorDecoder = decoder;{{ end }}
     }
 
 
@@ -58,20 +110,39 @@ public class {{.Name}} extends BaseAPI {
     *{{if .Params }}\
     {{ range .Params }}
     * @param {{ .Name }} {{ .Doc }}\
-    {{ end }}{{end}}
+    {{ end }}{{end}}\
+    {{ range .Throws }}
+    * @throws {{ .Name }} {{ .Doc }}\
+    {{ end }}
     **/{{ template "decorators" . }}\
     public CompletableFuture<{{ .Returns }}> {{ .Name }}({{ renderArguments .Params }}) {
         {{ template "buildMaps" . }}\
+        {{ if .Signed }}{{ template "sign" . }}{{ end }}\
+        {{ if .Throws }}
+        final Map<Integer, Class<? extends APIException>> errors = new HashMap<>();\
+        {{ range .Throws }}
+        errors.put({{ .Status }}, {{ .Name }}.class);\
+        {{ end }}
         
-        return perform(Request.Method.{{ .HttpVerb }}, "{{.Path}}",
+        return perform(Request.Method.{{ .HttpVerb }}, {{ if .Signed }}path{{ else }}"{{.Path}}"{{ end }},
+                       params,
+                       pathParams,
+                       parser({{ .Returns.Raw }}.class))
+                .exceptionally(e -> { throw errorFor(e, errors); });
+        {{ else }}
+        return perform(Request.Method.{{ .HttpVerb }}, {{ if .Signed }}path{{ else }}"{{.Path}}"{{ end }},
                        params,
                        pathParams,
-                       parser({{ .Returns }}.class));
+                       parser({{ .Returns.Raw }}.class));
+        {{ end }}
     }
 
 {{ end }}
 }
 {{ define "decorators" }}
+{{ if .Deprecated }}\
+    @Deprecated
+{{ end }}\
 {{ range .Params }}\
 {{ if eq .In "header" }}\
     @RequiredHeader("{{.Name}}")
@@ -81,6 +152,83 @@ public class {{.Name}} extends BaseAPI {
 {{ end }}\
 {{ end }}\
 
+{{ define "errors" }}
+    /**
+    * Returns the exception of a failed request's response status, if the method declares one, or the failure itself.
+    *
+    * The failure of the runtime, or one of its causes, must expose the response status with getStatus() or
+    * getStatusCode(), and the response body with getBody(). The body is decoded to the exception's body type with the
+    * decode(body, type) method of the client's decoder, and is null if it can't be decoded
+    */
+    private RuntimeException errorFor(Throwable failure, Map<Integer, Class<? extends APIException>> errors) {
+        for (Throwable t = failure; t != null; t = t.getCause()) {
+            Object status = call(t, "getStatus", "getStatusCode");
+            if (!(status instanceof Integer)) {
+                continue;
+            }
+
+            Class<? extends APIException> cls = errors.get(status);
+            if (cls == null) {
+                break;
+            }
+            try {
+                Class<?> type = (Class<?>) cls.getField("BODY").get(null);
+                return cls.getConstructor(type).newInstance(decodeError(call(t, "getBody"), type));
+            } catch (ReflectiveOperationException e) {
+                break;
+            }
+        }
+        return failure instanceof RuntimeException ? (RuntimeException) failure : new RuntimeException(failure);
+    }
+
+    private Object decodeError(Object body, Class<?> type) {
+        if (body == null || type.isInstance(body)) {
+            return body;
+        }
+        for (Method m : errorDecoder.getClass().getMethods()) {
+            Class<?>[] params = m.getParameterTypes();
+            if (m.getName().equals("decode") && params.length == 2 && params[0].isInstance(body) &&
+                    params[1] == Class.class) {
+                try {
+                    return m.invoke(errorDecoder, body, type);
+                } catch (ReflectiveOperationException e) {
+                    return null;
+                }
+            }
+        }
+        return null;
+    }
+
+    private static Object call(Object o, String... names) {
+        for (String name : names) {
+            try {
+                return o.getClass().getMethod(name).invoke(o);
+            } catch (ReflectiveOperationException e) {
+                // try the next name
+            }
+        }
+        return null;
+    }
+{{ end }}
+{{ define "sign" }}
+        // the path is expanded here and not by the runtime, so the signed path is exactly the one sent
+        String path = "{{ .Path }}"{{ range .Params }}{{ if eq .In "path" }}
+                .replace("{{ printf "{%s}" .Name }}", Signer.escapePath(String.valueOf({{ .Name }}))){{ end }}{{ end }};
+        pathParams.clear();
+        if (signer != null) {
+            Map<String,List<String>> signed = new HashMap<>();\
+{{ range .Params }}{{ if eq .In "query" "body" }}
+            Signer.add(signed, "{{ .Name }}", {{ .Name }});\
+{{ end }}{{ end }}
+            try {
+                for (Map.Entry<String,String> e : signer.sign("{{ .HttpVerb }}", ROOT + path, signed, null).entrySet()) {
+                    params.set(e.getKey(), e.getValue());
+                }
+            } catch (GeneralSecurityException | UnsupportedEncodingException e) {
+                throw new IllegalStateException("Could not sign request", e);
+            }
+        }
+{{ end }}
 {{ define "buildMaps" }}
         Map<String,Object> pathParams = new HashMap<>();
         Request.ParamMap params = new Request.ParamMap();\
@@ -91,3 +239,142 @@ public class {{.Name}} extends BaseAPI {
 {{ end}}\
 {{ end }}
 `
+
+// signerTpl is the request signer of APIs that require signed requests. It has a template of its own, so its
+// output can be checked against the signature package
+var signerTpl = `
+{{ define "signer" }}
+    /**
+    * Signer signs requests to the API with HMAC-SHA256, as the API requires, the same way the server's
+    * signature package does.
+    *
+    * path is the escaped full path of the request, including ROOT, and params are the query and form params.
+    * For form encoded requests the body should be null, as the params cover it
+    */
+    public static class Signer {
+
+        private final String keyId;
+        private final String secret;
+        private final SecureRandom random = new SecureRandom();
+
+        public Signer(String keyId, String secret) {
+            this.keyId = keyId;
+            this.secret = secret;
+        }
+
+        private static String hex(byte[] b) {
+            StringBuilder sb = new StringBuilder();
+            for (byte x : b) {
+                sb.append(String.format("%02x", x));
+            }
+            return sb.toString();
+        }
+
+        private static String encode(String s) throws UnsupportedEncodingException {
+            return URLEncoder.encode(s, "UTF-8").replace("*", "%2A").replace("%7E", String.valueOf((char) 126));
+        }
+
+        /**
+        * Escapes a path param value the way the server escapes paths, so the signed path is the one it sees
+        */
+        public static String escapePath(String s) {
+            byte[] bytes;
+            try {
+                bytes = s.getBytes("UTF-8");
+            } catch (UnsupportedEncodingException e) {
+                throw new IllegalStateException(e);
+            }
+
+            StringBuilder sb = new StringBuilder();
+            for (byte b : bytes) {
+                int c = b & 0xff;
+                if ((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == 126 ||
+                        "-_.$&+:=@".indexOf(c) >= 0) {
+                    sb.append((char) c);
+                } else {
+                    sb.append(String.format("%%%02X", c));
+                }
+            }
+            return sb.toString();
+        }
+
+        /**
+        * Adds a param to the params to sign. Lists are repeated params, and null values are not sent
+        */
+        public static void add(Map<String,List<String>> params, String name, Object value) {
+            if (value == null) {
+                return;
+            }
+
+            List<String> values = params.get(name);
+            if (values == null) {
+                values = new ArrayList<>();
+                params.put(name, values);
+            }
+
+            if (value instanceof Iterable) {
+                for (Object v : (Iterable<?>) value) {
+                    if (v != null) {
+                        values.add(String.valueOf(v));
+                    }
+                }
+            } else {
+                values.add(String.valueOf(value));
+            }
+        }
+
+        /**
+        * Builds the string we sign for a request
+        */
+        public static String canonical(String method, String path, Map<String,List<String>> params, byte[] body,
+                String timestamp, String nonce) throws GeneralSecurityException, UnsupportedEncodingException {
+
+            StringBuilder query = new StringBuilder();
+            for (Map.Entry<String,List<String>> e : new TreeMap<>(params).entrySet()) {
+                for (String v : e.getValue()) {
+                    if (query.length() > 0) {
+                        query.append('&');
+                    }
+                    query.append(encode(e.getKey())).append('=').append(encode(v));
+                }
+            }
+
+            String bodyHash = hex(MessageDigest.getInstance("SHA-256").digest(body == null ? new byte[0] : body));
+
+            char nl = (char) 10;
+            return method.toUpperCase() + nl + (path.isEmpty() ? "/" : path) + nl + query + nl + bodyHash + nl +
+                    timestamp + nl + nonce;
+        }
+
+        /**
+        * Returns the hex encoded HMAC-SHA256 of a canonical string with a secret
+        */
+        public static String signature(String secret, String canonical)
+                throws GeneralSecurityException, UnsupportedEncodingException {
+
+            Mac mac = Mac.getInstance("HmacSHA256");
+            mac.init(new SecretKeySpec(secret.getBytes("UTF-8"), "HmacSHA256"));
+            return hex(mac.doFinal(canonical.getBytes("UTF-8")));
+        }
+
+        /**
+        * Returns the signature values of a request, to send as its headers or params
+        */
+        public Map<String,String> sign(String method, String path, Map<String,List<String>> params, byte[] body)
+                throws GeneralSecurityException, UnsupportedEncodingException {
+
+            byte[] nonceBytes = new byte[16];
+            random.nextBytes(nonceBytes);
+            String nonce = hex(nonceBytes);
+            String timestamp = String.valueOf(System.currentTimeMillis() / 1000);
+
+            Map<String,String> ret = new HashMap<>();
+            ret.put("X-Vertex-Key", keyId);
+            ret.put("X-Vertex-Timestamp", timestamp);
+            ret.put("X-Vertex-Nonce", nonce);
+            ret.put("X-Vertex-Signature", signature(secret, canonical(method, path, params, body, timestamp, nonce)));
+            return ret;
+        }
+    }
+{{ end }}
+`
@@ -93,6 +93,11 @@ func newTypeRefSwagger(t swagger.Type, items swagger.Type) TypeRef {
 	return ret
 }
 
+// Raw returns the type without its type parameters, for class literals
+func (t TypeRef) Raw() string {
+	return TypeRef{Namespace: t.Namespace, Type: t.Type}.String()
+}
+
 func (t TypeRef) String() string {
 
 	ret := ""
@@ -112,6 +117,14 @@ func (t TypeRef) String() string {
 	return ret
 }
 
+// Exception is a typed exception for one of the error responses of a method
+type Exception struct {
+	Name   string
+	Status int
+	Body   TypeRef
+	Doc    string
+}
+
 // Method hodls the mapping of a route to a java method and its parameters
 type Method struct {
 	Name     string
@@ -120,6 +133,11 @@ type Method struct {
 	HttpVerb string
 	Doc      string
 	Path     string
+	Throws   []Exception
+	// Deprecated methods are annotated with @Deprecated
+	Deprecated bool
+	// Signed methods sign their requests with the API's Signer
+	Signed bool
 }
 
 // Param is a method parameter
@@ -141,4 +159,8 @@ type API struct {
 	Types   []Class
 	Methods []Method
 	Globals []Param
+	// The exceptions thrown by all the methods
+	Exceptions []Exception
+	// Signed is true if the API requires HMAC signed requests
+	Signed bool
 }
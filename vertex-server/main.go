@@ -1,22 +1,121 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/dvirsky/go-pylog/logging"
 	"github.com/EverythingMe/vertex"
+	"github.com/EverythingMe/vertex/middleware"
 	_ "github.com/EverythingMe/vertex/vertex-server/example"
 )
 
 func init() {
 
 }
+
+// shutdownOnSignal gracefully shuts the server down when we get SIGINT or SIGTERM
+func shutdownOnSignal(srv *vertex.Server) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	s := <-sig
+	logging.Info("Got signal %s, shutting down", s)
+	signal.Stop(sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(vertex.Config.Server.ShutdownTimeout)*time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		logging.Error("Error shutting down: %s", err)
+	}
+}
+
+// checkConfig prints all the problems found in the config, and exits with a non zero status if there are any
+func checkConfig(readErr error) {
+
+	problems := vertex.CheckConfigs()
+
+	// if the file could not be read at all, that's the first problem
+	if _, isProblems := readErr.(vertex.ConfigErrors); readErr != nil && !isProblems {
+		problems = append([]error{readErr}, problems...)
+	}
+
+	for _, p := range problems {
+		fmt.Fprintln(os.Stderr, p)
+	}
+
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "Found %d problems in config\n", len(problems))
+		os.Exit(1)
+	}
+
+	fmt.Println("Config OK")
+	os.Exit(0)
+}
+
+// dumpSwagger prints the swagger description of an API to stdout and exits, without listening
+func dumpSwagger(apiName, host, format string) {
+
+	if host == "" {
+		host = vertex.Config.Server.ListenAddr
+	}
+
+	if err := vertex.DumpSwagger(apiName, host, format, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
 func main() {
-	vertex.ReadConfigs()
+	check := flag.Bool("check-config", false, "Check the config file, print all the problems found in it and exit")
+	dump := flag.String("dump-swagger", "", "Print the swagger description of the named API and exit")
+	format := flag.String("format", vertex.SwaggerJSON, "The format of -dump-swagger: json or yaml")
+	host := flag.String("host", "", "The host of -dump-swagger descriptions. Defaults to the listen address")
+
+	err := vertex.ReadConfigs()
+	if *check {
+		checkConfig(err)
+	}
+	if *dump != "" {
+		dumpSwagger(*dump, *host, *format)
+	}
+	// in strict mode, we refuse to start with a bad config
+	if _, isProblems := err.(vertex.ConfigErrors); isProblems {
+		logging.Error("Not starting: %s", err)
+		os.Exit(1)
+	}
+
+	if watcher, err := vertex.WatchConfigs(time.Duration(vertex.Config.Server.ConfigReloadInterval) * time.Second); err != nil {
+		logging.Warning("Not watching config file for changes: %s", err)
+	} else {
+		defer watcher.Close()
+	}
 
 	logging.SetMinimalLevelByName(vertex.Config.Server.LoggingLevel)
 	srv := vertex.NewServer(vertex.Config.Server.ListenAddr)
 	srv.InitAPIs()
+
+	// the admin endpoints are served only if admin_listen is configured
+	srv.AdminMiddleware(middleware.NewIPRangeFilter().AllowPrivate())
+
+	done := make(chan struct{})
+	go func() {
+		shutdownOnSignal(srv)
+		close(done)
+	}()
+
 	if err := srv.Run(); err != nil {
 		panic(err)
 	}
 
+	// Run returns as soon as we stop listening, so we wait for the draining and stop hooks to finish
+	<-done
+
 }
@@ -8,6 +8,8 @@ import (
 
 	gorilla "github.com/gorilla/schema"
 
+	"github.com/EverythingMe/vertex/swagger"
+
 	"github.com/dvirsky/go-pylog/logging"
 )
 
@@ -152,6 +154,19 @@ func (f SecuritySchemeFunc) Validate(r *Request) error {
 	return f(r)
 }
 
+// SwaggerSecurityScheme is a security scheme that can describe itself in the API's swagger definition.
+// It returns the scheme's name and definition
+type SwaggerSecurityScheme interface {
+	SecurityScheme
+	SecurityDefinition() (string, swagger.SecurityDefinition)
+}
+
+// RequestSigner signs outgoing requests to the API, for APIs that require signed requests.
+// The integration tests use the API's signer to sign their requests
+type RequestSigner interface {
+	Sign(r *http.Request) error
+}
+
 var NopSecurity = SecuritySchemeFunc(func(r *Request) error {
 	return nil
 })
@@ -168,8 +183,9 @@ const (
 
 var schemaDecoder = gorilla.NewDecoder()
 
-// Parse the user input into a request handler struct, with input validation
-func parseInput(r *http.Request, input interface{}, validator *RequestValidator) error {
+// Parse the user input into a request handler struct, with input validation. Params named after the handler's
+// injected fields are ignored
+func parseInput(r *http.Request, input interface{}, validator *RequestValidator, deps *injector) error {
 
 	schemaDecoder.IgnoreUnknownKeys(true)
 
@@ -180,7 +196,7 @@ func parseInput(r *http.Request, input interface{}, validator *RequestValidator)
 	// We do not map and validate input to non-struct handlers
 	if reflect.TypeOf(input).Kind() != reflect.Func {
 
-		if err := schemaDecoder.Decode(input, r.Form); err != nil {
+		if err := schemaDecoder.Decode(input, deps.params(r.Form)); err != nil {
 			return InvalidRequestError("Error decoding schema: %s", err)
 		}
 
@@ -169,6 +169,11 @@ func TestRegistration(t *testing.T) {
 
 func TestIntegration(t *testing.T) {
 	////t.SkipNow()
+	Config.Server.TrustLocalConnections = true
+	defer func() {
+		Config.Server.TrustLocalConnections = false
+	}()
+
 	srv := NewServer(":9947")
 	srv.AddAPI(mockAPI)
 
@@ -268,7 +273,7 @@ func TestIntegration(t *testing.T) {
 	})
 	// Test integration tests
 
-	u = fmt.Sprintf("http://%s/test/%s/warning", s.Listener.Addr().String(), mockAPI.root())
+	u = fmt.Sprintf("http://%s/test%s/warning", s.Listener.Addr().String(), mockAPI.root())
 
 	res, err := http.Get(u)
 	if err != nil {
@@ -289,7 +294,7 @@ func TestIntegration(t *testing.T) {
 	}
 	res.Body.Close()
 
-	u = fmt.Sprintf("http://%s/test/%s/critical", s.Listener.Addr().String(), mockAPI.root())
+	u = fmt.Sprintf("http://%s/test%s/critical", s.Listener.Addr().String(), mockAPI.root())
 
 	if res, err = http.Get(u); err != nil {
 		t.Fatal(err)